@@ -0,0 +1,212 @@
+// Package artifact keeps copies of the files a node produced in its workspace, so that they
+// outlive the workspace, which is wiped or reused by later runs.
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned when there is no such artifact
+var ErrNotFound = errors.New("artifact not found")
+
+// File describes a stored artifact
+type File struct {
+	Name   string // the slash separated path relative to the workspace it was collected from
+	Size   int64
+	SHA256 string // hex encoded
+}
+
+// Store keeps the artifacts in files under its root as flow/run/node/name
+type Store struct {
+	root string
+}
+
+// New returns a Store keeping its files below root
+func New(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{root: root}, nil
+}
+
+// CheckPattern returns an error if the pattern is not a valid glob relative to a workspace
+func CheckPattern(p string) error {
+	if p == "" {
+		return errors.New("empty artifact pattern")
+	}
+	if _, err := path.Match(p, ""); err != nil {
+		return fmt.Errorf("bad artifact pattern %s: %v", p, err)
+	}
+	clean := path.Clean(filepath.ToSlash(p))
+	if path.IsAbs(p) || filepath.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("artifact pattern %s must be inside the workspace", p)
+	}
+	return nil
+}
+
+// dir returns the directory for the artifacts of the node, or an error if any id could escape the root
+func (s *Store) dir(flowID, runID, nodeID string) (string, error) {
+	for _, id := range []string{flowID, runID, nodeID} {
+		if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) {
+			return "", fmt.Errorf("bad artifact id: %s/%s/%s", flowID, runID, nodeID)
+		}
+	}
+	return filepath.Join(s.root, flowID, runID, nodeID), nil
+}
+
+// Collect copies the files in the workspace at base that match any of the glob patterns into the
+// store for the node. A pattern matching a directory collects every file below it. Only regular
+// files inside the workspace are collected, links to files are followed if they stay inside it.
+// It returns the collected files in name order, and the patterns that matched nothing.
+func (s *Store) Collect(flowID, runID, nodeID, base string, patterns []string) ([]File, []string, error) {
+	dst, err := s.dir(flowID, runID, nodeID)
+	if err != nil {
+		return nil, nil, err
+	}
+	base, err = filepath.EvalSymlinks(base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// find all the files first, keyed by name so overlapping patterns collect a file once
+	found := map[string]string{}
+	var unmatched []string
+	for _, p := range patterns {
+		if err := CheckPattern(p); err != nil {
+			return nil, nil, err
+		}
+		matches, err := filepath.Glob(filepath.Join(base, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, nil, err
+		}
+		n := 0
+		for _, m := range matches {
+			c, err := walk(base, m, found)
+			if err != nil {
+				return nil, nil, err
+			}
+			n += c
+		}
+		if n == 0 {
+			unmatched = append(unmatched, p)
+		}
+	}
+
+	names := make([]string, 0, len(found))
+	for n := range found {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	files := make([]File, 0, len(names))
+	for _, n := range names {
+		f, err := store(found[n], filepath.Join(dst, filepath.FromSlash(n)))
+		if err != nil {
+			return nil, nil, err
+		}
+		f.Name = n
+		files = append(files, f)
+	}
+	return files, unmatched, nil
+}
+
+// walk adds the file at p, or all the files below it if it is a directory, to found and returns
+// how many files it found
+func walk(base, p string, found map[string]string) (int, error) {
+	n := 0
+	err := filepath.Walk(p, func(fp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(fp)
+		if err != nil {
+			return nil // a broken link
+		}
+		if real != base && !strings.HasPrefix(real, base+string(filepath.Separator)) {
+			return nil // a link out of the workspace
+		}
+		st, err := os.Stat(real)
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, fp)
+		if err != nil {
+			return err
+		}
+		found[filepath.ToSlash(rel)] = real
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// store copies the file at src to dst, via a temporary file, hashing it as it goes
+func store(src, dst string) (File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return File{}, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".collect-")
+	if err != nil {
+		return File{}, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), in)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return File{}, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return File{}, err
+	}
+	return File{
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Open opens the named artifact of the node for reading
+func (s *Store) Open(flowID, runID, nodeID, name string) (*os.File, error) {
+	dir, err := s.dir(flowID, runID, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	clean := path.Clean("/" + name)[1:]
+	if clean == "" || clean != strings.TrimPrefix(name, "/") {
+		return nil, fmt.Errorf("bad artifact name: %s", name)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(clean)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if st, err := f.Stat(); err != nil || !st.Mode().IsRegular() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+	return f, nil
+}
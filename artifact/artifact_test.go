@@ -0,0 +1,115 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testDirs(t *testing.T) (*Store, string, func()) {
+	root, err := ioutil.TempDir("", "floe-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(filepath.Join(root, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := filepath.Join(root, "ws")
+	for name, content := range map[string]string{
+		"bin/app":            "binary",
+		"reports/unit.xml":   "<unit/>",
+		"reports/deep/x.xml": "<x/>",
+		"main.go":            "package main",
+	} {
+		p := filepath.Join(ws, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0700)
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// links inside the workspace are followed, links out of it are not
+	os.Symlink(filepath.Join(ws, "main.go"), filepath.Join(ws, "bin", "link.go"))
+	os.Symlink(filepath.Join(root, "store"), filepath.Join(ws, "bin", "out"))
+	ioutil.WriteFile(filepath.Join(root, "secret"), []byte("x"), 0600)
+	os.Symlink(filepath.Join(root, "secret"), filepath.Join(ws, "bin", "secret"))
+	return s, ws, func() { os.RemoveAll(root) }
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	s, ws, cleanup := testDirs(t)
+	defer cleanup()
+
+	files, unmatched, err := s.Collect("build", "h1-5", "compile", ws, []string{"bin/*", "reports", "reports/*.xml", "*.exe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"bin/app", "bin/link.go", "reports/deep/x.xml", "reports/unit.xml"}
+	if len(files) != len(exp) {
+		t.Fatal("bad files", files)
+	}
+	for i, f := range files {
+		if f.Name != exp[i] {
+			t.Errorf("file %d is %s not %s", i, f.Name, exp[i])
+		}
+	}
+	if len(unmatched) != 1 || unmatched[0] != "*.exe" {
+		t.Error("bad unmatched", unmatched)
+	}
+
+	sum := sha256.Sum256([]byte("binary"))
+	if files[0].Size != 6 || files[0].SHA256 != hex.EncodeToString(sum[:]) {
+		t.Error("bad size or hash", files[0])
+	}
+
+	// the copy outlives the workspace
+	os.RemoveAll(ws)
+	f, err := s.Open("build", "h1-5", "compile", "/bin/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(f)
+	f.Close()
+	if string(b) != "binary" {
+		t.Error("bad content", string(b))
+	}
+
+	for _, name := range []string{"bin/missing", "bin", "../compile/bin/app", "bin/../bin/app", ""} {
+		if _, err := s.Open("build", "h1-5", "compile", name); err == nil {
+			t.Error("should not open", name)
+		}
+	}
+	if _, err := s.Open("build", "..", "compile", "bin/app"); err == nil {
+		t.Error("should not open a bad id")
+	}
+}
+
+func TestCheckPattern(t *testing.T) {
+	t.Parallel()
+
+	fix := []struct {
+		p  string
+		ok bool
+	}{
+		{p: "bin/*", ok: true},
+		{p: "reports/*.xml", ok: true},
+		{p: "dist", ok: true},
+		{p: "", ok: false},
+		{p: "/etc/passwd", ok: false},
+		{p: "..", ok: false},
+		{p: "a/../..", ok: false},
+		{p: "../other/*", ok: false},
+		{p: "a/../../b", ok: false},
+		{p: "[bad", ok: false},
+	}
+	for i, f := range fix {
+		if err := CheckPattern(f.p); (err == nil) != f.ok {
+			t.Errorf("%d - %s expected ok %v got %v", i, f.p, f.ok, err)
+		}
+	}
+}
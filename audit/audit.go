@@ -0,0 +1,143 @@
+// Package audit keeps an append only log of who did what to which flows and runs.
+package audit
+
+import (
+	"time"
+
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/log"
+)
+
+// System is the actor recorded for actions not caused by a user
+const System = "system"
+
+// The event tags and actions the audit log observes from the hub
+const (
+	tagStateChange = "sys.state"
+	tagEndFlow     = "sys.end.all"
+)
+
+// Record is a single audited action
+type Record struct {
+	Seq    int64     // the position in the log, later records have higher sequence numbers
+	Time   time.Time // when the action happened
+	Host   string    // the host that recorded the action
+	Actor  string    // the user, or api token, or System
+	Action string    // what was done e.g. login, trigger, approve, run.cancel
+	FlowID string    // the flow acted on, if any
+	Run    string    // the run acted on, if any
+	IP     string    // the source ip of the request that caused the action, if any
+	Result int       // the http status of the request, 0 for system actions
+	Detail string    // any other information about the action
+}
+
+// Query filters the records returned, empty fields match everything.
+type Query struct {
+	Actor  string
+	Action string
+	FlowID string
+	Run    string
+	Since  time.Time
+	Until  time.Time
+	Before int64 // only records with a lower sequence number, 0 for the latest records
+	Limit  int   // the maximum number of records to return, defaults to 100
+}
+
+func (q Query) match(r Record) bool {
+	switch {
+	case q.Before > 0 && r.Seq >= q.Before,
+		q.Actor != "" && r.Actor != q.Actor,
+		q.Action != "" && r.Action != q.Action,
+		q.FlowID != "" && r.FlowID != q.FlowID,
+		q.Run != "" && r.Run != q.Run,
+		!q.Since.IsZero() && r.Time.Before(q.Since),
+		!q.Until.IsZero() && r.Time.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// Page is a page of records, newest first.
+type Page struct {
+	Records []Record
+	Next    int64 // the Before to use to get the next page, 0 if there are no more records
+}
+
+// Store is an append only store of records
+type Store interface {
+	// Append sets the records sequence number and appends it to the store
+	Append(r *Record) error
+	// Query returns a page of the records matching the query
+	Query(q Query) (Page, error)
+}
+
+// Log records actions to a Store, it observes the hub events to record the system actions.
+type Log struct {
+	store Store
+	host  string
+}
+
+// New returns a Log recording actions on this host to the store
+func New(s Store, host string) *Log {
+	return &Log{
+		store: s,
+		host:  host,
+	}
+}
+
+// Record appends the record to the log setting its time and host
+func (l *Log) Record(r Record) {
+	if r.Time.IsZero() {
+		r.Time = time.Now().UTC()
+	}
+	if r.Actor == "" {
+		r.Actor = System
+	}
+	r.Host = l.host
+	if err := l.store.Append(&r); err != nil {
+		log.Error("audit - could not append record", err, r)
+	}
+}
+
+// Query returns a page of matching records
+func (l *Log) Query(q Query) (Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	return l.store.Query(q)
+}
+
+// Notify satisfies event.Observer, recording the run state changes and data supplied by users.
+func (l *Log) Notify(e event.Event) {
+	r := Record{
+		Actor:  e.Actor,
+		FlowID: e.RunRef.FlowRef.ID,
+	}
+	if e.RunRef.Adopted() {
+		r.Run = e.RunRef.Run.String()
+	}
+	switch {
+	case e.Tag == tagStateChange:
+		switch e.Opts["action"] {
+		case "add-pend":
+			r.Action = "run.pend"
+		case "activate":
+			r.Action = "run.start"
+		default:
+			return
+		}
+	case e.Tag == tagEndFlow:
+		r.Action = "run.end"
+		r.Detail = "bad"
+		if e.Good {
+			r.Detail = "good"
+		}
+	case e.Actor != "" && !e.IsSystem() && e.RunRef.Adopted() && e.SourceNode.ID != "":
+		// a user supplied the data that completed a data node
+		r.Action = "data"
+		r.Detail = e.SourceNode.ID + " " + e.Tag
+	default:
+		return
+	}
+	l.Record(r)
+}
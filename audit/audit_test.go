@@ -0,0 +1,210 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/store"
+)
+
+func testStores(t *testing.T) (map[string]Store, func()) {
+	dir, err := ioutil.TempDir("", "floe-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := NewFileStore(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kv, err := newKVStore(store.NewMemStore(), "audit", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Store{
+		"mem":  NewMemStore(),
+		"file": fs,
+		"kv":   kv,
+	}, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	stores, cleanup := testStores(t)
+	defer cleanup()
+
+	t0 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for name, s := range stores {
+		l := New(s, "h1")
+		for i := 0; i < 5; i++ {
+			l.Record(Record{Time: t0.Add(time.Duration(i) * time.Hour), Actor: "bob", Action: "trigger", FlowID: "build"})
+		}
+		l.Record(Record{Time: t0.Add(10 * time.Hour), Actor: "ann", Action: "run.cancel", FlowID: "deploy", Run: "h1-1"})
+		l.Record(Record{Time: t0.Add(11 * time.Hour), Action: "run.end", FlowID: "deploy", Run: "h1-1"})
+
+		fix := []struct {
+			q    Query
+			seqs []int64
+			next int64
+		}{
+			{q: Query{}, seqs: []int64{7, 6, 5, 4, 3, 2, 1}},
+			{q: Query{Actor: "ann"}, seqs: []int64{6}},
+			{q: Query{Actor: System}, seqs: []int64{7}},
+			{q: Query{FlowID: "deploy", Run: "h1-1"}, seqs: []int64{7, 6}},
+			{q: Query{Action: "trigger", Limit: 2}, seqs: []int64{5, 4}, next: 4},
+			{q: Query{Action: "trigger", Limit: 2, Before: 4}, seqs: []int64{3, 2}, next: 2},
+			{q: Query{Action: "trigger", Limit: 2, Before: 2}, seqs: []int64{1}},
+			{q: Query{Since: t0.Add(time.Hour), Until: t0.Add(3 * time.Hour)}, seqs: []int64{4, 3, 2}},
+		}
+		for i, fx := range fix {
+			p, err := l.Query(fx.q)
+			if err != nil {
+				t.Fatal(name, i, err)
+			}
+			if len(p.Records) != len(fx.seqs) {
+				t.Errorf("%s %d - expected %d records got %d", name, i, len(fx.seqs), len(p.Records))
+				continue
+			}
+			for j, r := range p.Records {
+				if r.Seq != fx.seqs[j] {
+					t.Errorf("%s %d - record %d expected seq %d got %d", name, i, j, fx.seqs[j], r.Seq)
+				}
+				if r.Host != "h1" {
+					t.Errorf("%s %d - host not set", name, i)
+				}
+			}
+			if p.Next != fx.next {
+				t.Errorf("%s %d - expected next %d got %d", name, i, fx.next, p.Next)
+			}
+		}
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	New(fs, "h1").Record(Record{Action: "login"})
+	New(fs, "h1").Record(Record{Action: "logout"})
+	fs.f.Close()
+
+	// a torn last line is ignored
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	f.WriteString(`{"Seq":3,"Act`)
+	f.Close()
+
+	fs, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Action: "login"}
+	if err := fs.Append(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 3 {
+		t.Errorf("expected the sequence to carry on at 3 got %d", r.Seq)
+	}
+	p, err := fs.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Records) != 3 || p.Records[0].Seq != 3 {
+		t.Errorf("expected the appended record after the torn line got %+v", p.Records)
+	}
+}
+
+func TestKVStoreReopen(t *testing.T) {
+	t.Parallel()
+
+	kv := store.NewMemStore()
+	s, err := newKVStore(kv, "audit", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ { // fills two chunks exactly
+		if err := s.Append(&Record{Action: "login"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// all the chunks are loaded and the sequence carries on in a new chunk
+	s, err = newKVStore(kv, "audit", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := Record{Action: "logout"}
+	if err := s.Append(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Seq != 5 {
+		t.Errorf("expected the sequence to carry on at 5 got %d", r.Seq)
+	}
+	var chunk []Record
+	if err := kv.Load("audit/000002", &chunk); err != nil || len(chunk) != 1 || chunk[0].Seq != 5 {
+		t.Errorf("expected the record in its own chunk got %+v %v", chunk, err)
+	}
+	p, err := s.Query(Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Records) != 5 || p.Records[0].Seq != 5 || p.Records[4].Seq != 1 {
+		t.Errorf("expected all 5 records got %+v", p.Records)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	t.Parallel()
+
+	run := event.RunRef{
+		FlowRef: config.FlowRef{ID: "build", Ver: 1},
+		Run:     event.HostedIDRef{HostID: "h1", ID: 2},
+	}
+	fix := []struct {
+		e      event.Event
+		action string
+		actor  string
+	}{
+		{e: event.Event{RunRef: run, Tag: tagStateChange, Opts: map[string]interface{}{"action": "add-pend"}, Actor: "bob"}, action: "run.pend", actor: "bob"},
+		{e: event.Event{RunRef: run, Tag: tagStateChange, Opts: map[string]interface{}{"action": "activate"}}, action: "run.start", actor: System},
+		{e: event.Event{RunRef: run, Tag: tagStateChange, Opts: map[string]interface{}{"action": "archive"}}},
+		{e: event.Event{RunRef: run, Tag: tagEndFlow, Good: true}, action: "run.end", actor: System},
+		{e: event.Event{RunRef: run, Tag: "data.approve.good", SourceNode: config.NodeRef{ID: "approve"}, Actor: "ann"}, action: "data", actor: "ann"},
+		{e: event.Event{RunRef: run, Tag: "task.build.good", SourceNode: config.NodeRef{ID: "build"}}},
+	}
+	for i, fx := range fix {
+		s := NewMemStore()
+		New(s, "h1").Notify(fx.e)
+		p, _ := s.Query(Query{})
+		if fx.action == "" {
+			if len(p.Records) != 0 {
+				t.Errorf("%d - expected no record got %+v", i, p.Records)
+			}
+			continue
+		}
+		if len(p.Records) != 1 {
+			t.Errorf("%d - expected one record got %d", i, len(p.Records))
+			continue
+		}
+		r := p.Records[0]
+		if r.Action != fx.action || r.Actor != fx.actor || r.FlowID != "build" || r.Run != "h1-2" {
+			t.Errorf("%d - bad record %+v", i, r)
+		}
+	}
+}
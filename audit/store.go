@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/floeit/floe/store"
+)
+
+// chunkSize is how many records a KVStore saves to each key
+const chunkSize = 500
+
+// MemStore keeps the records in memory
+type MemStore struct {
+	sync.RWMutex
+	records []Record
+}
+
+// NewMemStore returns an empty MemStore
+func NewMemStore() *MemStore {
+	return &MemStore{}
+}
+
+// Append satisfies Store
+func (m *MemStore) Append(r *Record) error {
+	m.Lock()
+	defer m.Unlock()
+	r.Seq = int64(len(m.records) + 1)
+	m.records = append(m.records, *r)
+	return nil
+}
+
+// Query satisfies Store
+func (m *MemStore) Query(q Query) (Page, error) {
+	m.RLock()
+	defer m.RUnlock()
+	return page(m.records, q), nil
+}
+
+// FileStore appends records to a file, one json record per line.
+type FileStore struct {
+	sync.Mutex
+	path string
+	f    *os.File
+	seq  int64
+}
+
+// NewFileStore opens or creates the file at path for appending records to
+func NewFileStore(path string) (*FileStore, error) {
+	fs := &FileStore{path: path}
+	// find the last sequence number
+	err := fs.scan(func(r Record) {
+		fs.seq = r.Seq
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	fs.f, err = os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// terminate any partly written last line so the next record starts on its own line
+	if st, err := fs.f.Stat(); err == nil && st.Size() > 0 {
+		b := make([]byte, 1)
+		if _, err := fs.f.ReadAt(b, st.Size()-1); err == nil && b[0] != '\n' {
+			fs.f.Write([]byte{'\n'})
+		}
+	}
+	return fs, nil
+}
+
+// Append satisfies Store
+func (fs *FileStore) Append(r *Record) error {
+	fs.Lock()
+	defer fs.Unlock()
+	r.Seq = fs.seq + 1
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := fs.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	fs.seq = r.Seq
+	return nil
+}
+
+// Query satisfies Store
+func (fs *FileStore) Query(q Query) (Page, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	var recs []Record
+	err := fs.scan(func(r Record) {
+		if q.match(r) {
+			recs = append(recs, r)
+		}
+	})
+	if err != nil {
+		return Page{}, err
+	}
+	return page(recs, q), nil
+}
+
+// scan calls fn with each record in the file in order
+func (fs *FileStore) scan(fn func(r Record)) error {
+	f, err := os.Open(fs.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		r := Record{}
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			continue // a partly written last line
+		}
+		fn(r)
+	}
+	return s.Err()
+}
+
+// KVStore keeps the records in a key value store, such as the s3 store, chunkSize records to
+// each key so an append only saves the last chunk. The records are also kept in memory.
+type KVStore struct {
+	sync.RWMutex
+	kv      store.Store
+	prefix  string
+	size    int
+	records []Record
+}
+
+// NewKVStore loads all the records saved in kv below the key prefix
+func NewKVStore(kv store.Store, prefix string) (*KVStore, error) {
+	return newKVStore(kv, prefix, chunkSize)
+}
+
+func newKVStore(kv store.Store, prefix string, size int) (*KVStore, error) {
+	s := &KVStore{kv: kv, prefix: prefix, size: size}
+	// every chunk but the last is full
+	for i := 0; ; i++ {
+		var chunk []Record
+		if err := kv.Load(s.key(i), &chunk); err != nil {
+			return nil, err
+		}
+		s.records = append(s.records, chunk...)
+		if len(chunk) < size {
+			return s, nil
+		}
+	}
+}
+
+func (s *KVStore) key(chunk int) string {
+	return fmt.Sprintf("%s/%06d", s.prefix, chunk)
+}
+
+// Append satisfies Store
+func (s *KVStore) Append(r *Record) error {
+	s.Lock()
+	defer s.Unlock()
+	r.Seq = int64(len(s.records) + 1)
+	i := len(s.records) / s.size
+	chunk := append(append([]Record{}, s.records[i*s.size:]...), *r)
+	if err := s.kv.Save(s.key(i), chunk); err != nil {
+		return err
+	}
+	s.records = append(s.records, *r)
+	return nil
+}
+
+// Query satisfies Store
+func (s *KVStore) Query(q Query) (Page, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return page(s.records, q), nil
+}
+
+// page returns the newest q.Limit records matching q from the records in sequence order
+func page(records []Record, q Query) Page {
+	if q.Limit <= 0 {
+		q.Limit = 100
+	}
+	p := Page{Records: []Record{}}
+	for i := len(records) - 1; i >= 0; i-- {
+		r := records[i]
+		if !q.match(r) {
+			continue
+		}
+		if len(p.Records) == q.Limit {
+			p.Next = p.Records[len(p.Records)-1].Seq
+			break
+		}
+		p.Records = append(p.Records, r)
+	}
+	return p
+}
@@ -0,0 +1,416 @@
+// Package cache keeps tarballs of workspace directories, such as downloaded dependencies, between
+// runs on a host. Each is stored under a key, and the least recently used are removed when the
+// cache grows too big.
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned when no entry matches the keys
+var ErrNotFound = errors.New("cache entry not found")
+
+const ext = ".tgz"
+
+// Store keeps each entry as a gzipped tarball named by its key in a directory per flow, so one
+// flow can never restore what another saved. The modification time of the tarball is when it was
+// last used.
+type Store struct {
+	root string
+	max  int64 // the total size the entries are kept below, 0 is unlimited
+
+	mu sync.Mutex // serialises the use times and eviction
+}
+
+// New returns a Store keeping up to max bytes of entries below root, 0 max is unlimited.
+func New(root string, max int64) (*Store, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{root: root, max: max}, nil
+}
+
+// CheckKey returns an error if the key can not be used to name an entry
+func CheckKey(key string) error {
+	if key == "" {
+		return errors.New("empty cache key")
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("bad cache key %s: only letters, numbers, '-', '_' and '.' are allowed", key)
+		}
+	}
+	if key[0] == '.' {
+		return fmt.Errorf("bad cache key %s: can not start with '.'", key)
+	}
+	return nil
+}
+
+// Has returns true if the flow has an entry with the key
+func (s *Store) Has(flow, key string) bool {
+	_, err := os.Stat(s.path(flow, key))
+	return err == nil
+}
+
+// path returns the file of the entry for the key of the flow
+func (s *Store) path(flow, key string) string {
+	return filepath.Join(s.root, flow, key+ext)
+}
+
+// Save archives the paths, relative to the workspace at base, as the entry of the flow for the
+// key replacing any existing entry. Paths that do not exist are skipped. It returns the size of
+// the entry.
+func (s *Store) Save(flow, key, base string, paths []string) (int64, error) {
+	if err := CheckKey(flow); err != nil {
+		return 0, err
+	}
+	if err := CheckKey(key); err != nil {
+		return 0, err
+	}
+	dir := filepath.Join(s.root, flow)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return 0, err
+	}
+	tmp, err := ioutil.TempFile(dir, ".save-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	err = archive(tmp, base, paths)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path(flow, key)); err != nil {
+		return 0, err
+	}
+	s.evict()
+	return st.Size(), nil
+}
+
+// Restore extracts the entry of the flow for the first key that matches into the workspace at base,
+// returning the key of the entry. The key must match exactly, and any prefixes match the most
+// recently used entry of the flow starting with them, each prefix being tried in turn.
+func (s *Store) Restore(flow, key string, prefixes []string, base string) (string, error) {
+	if err := CheckKey(flow); err != nil {
+		return "", err
+	}
+	found := ""
+	if s.Has(flow, key) {
+		found = key
+	} else {
+		for _, p := range prefixes {
+			if found = s.latest(flow, p); found != "" {
+				break
+			}
+		}
+	}
+	if found == "" {
+		return "", ErrNotFound
+	}
+
+	f, err := os.Open(s.path(flow, found))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	s.touch(flow, found)
+	return found, extract(f, base)
+}
+
+// entry is a stored tarball
+type entry struct {
+	flow string
+	key  string
+	size int64
+	used time.Time
+}
+
+// entries returns the entries of all the flows most recently used first
+func (s *Store) entries() []entry {
+	dirs, err := ioutil.ReadDir(s.root)
+	if err != nil {
+		return nil
+	}
+	es := []entry{}
+	for _, d := range dirs {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		fis, err := ioutil.ReadDir(filepath.Join(s.root, d.Name()))
+		if err != nil {
+			continue
+		}
+		for _, fi := range fis {
+			n := fi.Name()
+			if !fi.Mode().IsRegular() || strings.HasPrefix(n, ".") || !strings.HasSuffix(n, ext) {
+				continue
+			}
+			es = append(es, entry{flow: d.Name(), key: strings.TrimSuffix(n, ext), size: fi.Size(), used: fi.ModTime()})
+		}
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].used.After(es[j].used)
+	})
+	return es
+}
+
+// latest returns the key of the most recently used entry of the flow starting with prefix
+func (s *Store) latest(flow, prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	for _, e := range s.entries() {
+		if e.flow == flow && strings.HasPrefix(e.key, prefix) {
+			return e.key
+		}
+	}
+	return ""
+}
+
+func (s *Store) touch(flow, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	os.Chtimes(s.path(flow, key), now, now)
+}
+
+// evict removes the least recently used entries until the total size is within the max, always
+// keeping the most recent even if that alone is too big.
+func (s *Store) evict() {
+	if s.max <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var total int64
+	for i, e := range s.entries() {
+		total += e.size
+		if i > 0 && total > s.max {
+			os.Remove(s.path(e.flow, e.key))
+		}
+	}
+}
+
+// Size returns the total size of the entries
+func (s *Store) Size() int64 {
+	var total int64
+	for _, e := range s.entries() {
+		total += e.size
+	}
+	return total
+}
+
+// archive writes the directories and files at paths relative to base to w as a gzipped tar
+func archive(w io.Writer, base string, paths []string) error {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, p := range paths {
+		rel, err := relPath(p)
+		if err != nil {
+			return err
+		}
+		// a linked parent would archive whatever it points at outside the workspace
+		if err := noLinkedParents(base, rel); err != nil {
+			return err
+		}
+		root := filepath.Join(base, rel)
+		if _, err := os.Lstat(root); os.IsNotExist(err) {
+			continue
+		}
+		err = filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return add(tw, base, fp, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// add writes the file, directory or link at fp to the tar with its name relative to base
+func add(tw *tar.Writer, base, fp string, info os.FileInfo) error {
+	rel, err := filepath.Rel(base, fp)
+	if err != nil {
+		return err
+	}
+	link := ""
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		l, err := os.Readlink(fp)
+		if err != nil {
+			return err
+		}
+		// extract would refuse the link, so the entry could never be restored
+		if err := linkInside(rel, l); err != nil {
+			return err
+		}
+		link = l
+	case info.Mode().IsRegular(), info.IsDir():
+	default:
+		return nil // no devices, pipes or sockets
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extract writes the contents of the gzipped tar r below base
+func extract(r io.Reader, base string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := relPath(hdr.Name)
+		if err != nil {
+			return err
+		}
+		// an earlier entry, or the workspace, may have made a parent a link to outside it
+		if err := noLinkedParents(base, rel); err != nil {
+			return err
+		}
+		dst := filepath.Join(base, rel)
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(dst); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("cache path %s is a link", hdr.Name)
+			}
+			if err := os.MkdirAll(dst, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := linkInside(rel, hdr.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+				return err
+			}
+			os.Remove(dst)
+			if err := os.Symlink(hdr.Linkname, dst); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+				return err
+			}
+			if err := write(dst, mode, tr); err != nil {
+				return err
+			}
+			os.Chtimes(dst, hdr.ModTime, hdr.ModTime)
+		}
+	}
+}
+
+func write(dst string, mode os.FileMode, r io.Reader) error {
+	os.Remove(dst) // it may be a link, which must not be written through
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// noLinkedParents returns an error if any directory of the relative path rel, below base, is a
+// link, as writing or reading through it could reach outside the workspace. Directories that do
+// not exist yet are fine as they are made as real directories.
+func noLinkedParents(base, rel string) error {
+	dir := base
+	parts := strings.Split(filepath.Dir(rel), string(filepath.Separator))
+	for _, p := range parts {
+		if p == "." {
+			continue
+		}
+		dir = filepath.Join(dir, p)
+		fi, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("cache path %s is below a link", filepath.ToSlash(rel))
+		}
+	}
+	return nil
+}
+
+// linkInside returns an error if the target of the link at rel is absolute or leaves the
+// directory the link is relative to.
+func linkInside(rel, target string) error {
+	t := filepath.ToSlash(target)
+	if t == "" || path.IsAbs(t) || filepath.IsAbs(target) || filepath.VolumeName(target) != "" {
+		return fmt.Errorf("cache link %s to %s must be relative", filepath.ToSlash(rel), target)
+	}
+	to := path.Join(path.Dir(filepath.ToSlash(rel)), t)
+	if to == ".." || strings.HasPrefix(to, "../") {
+		return fmt.Errorf("cache link %s to %s must stay inside the workspace", filepath.ToSlash(rel), target)
+	}
+	return nil
+}
+
+// relPath returns the slash separated p as a clean path that can not escape the directory it is relative to
+func relPath(p string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(p))
+	if clean == "." || path.IsAbs(clean) || filepath.IsAbs(p) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("cache path %s must be inside the workspace", p)
+	}
+	return filepath.FromSlash(clean), nil
+}
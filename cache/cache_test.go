@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "floe-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, name, content string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	t.Parallel()
+
+	root := testDir(t)
+	defer os.RemoveAll(root)
+	s, err := New(filepath.Join(root, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := filepath.Join(root, "ws")
+	writeFile(t, filepath.Join(ws, "mod", "a", "a.go"), "package a")
+	writeFile(t, filepath.Join(ws, "mod", "b.txt"), "b")
+	if err := os.Symlink("b.txt", filepath.Join(ws, "mod", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Save("build", "go-abc", ws, []string{"mod", "missing"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save("build", "go-abc", ws, []string{"../out"}); err == nil {
+		t.Error("saved a path out of the workspace")
+	}
+	// links extract would refuse are refused when saving, so no entry can be saved that can not be restored
+	writeFile(t, filepath.Join(ws, "bad", "c.txt"), "c")
+	for i, l := range []string{"/etc/passwd", "../../outside", "../.."} {
+		ln := filepath.Join(ws, "bad", "link")
+		os.Remove(ln)
+		if err := os.Symlink(l, ln); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Save("build", "bad", ws, []string{"bad"}); err == nil {
+			t.Errorf("%d - saved a link to %s out of the workspace", i, l)
+		}
+	}
+	if s.Has("build", "bad") {
+		t.Error("bad entry should not be in the cache")
+	}
+	if _, err := s.Save("build", "go/abc", ws, []string{"mod"}); err == nil {
+		t.Error("saved a bad key")
+	}
+	if _, err := s.Save("../build", "go-abc", ws, []string{"mod"}); err == nil {
+		t.Error("saved a bad flow")
+	}
+
+	fixtures := []struct {
+		flow     string
+		key      string
+		prefixes []string
+		found    string
+	}{
+		{flow: "build", key: "go-abc", found: "go-abc"},
+		{flow: "build", key: "go-def", prefixes: []string{"npm-", "go-"}, found: "go-abc"},
+		{flow: "build", key: "go-def", prefixes: []string{"npm-"}},
+		{flow: "build", key: "go-def"},
+		{flow: "other", key: "go-abc"},                            // another flow can not restore it
+		{flow: "other", key: "go-def", prefixes: []string{"go-"}}, // even by prefix
+	}
+	for i, fx := range fixtures {
+		dst := filepath.Join(root, "restore", strconv.Itoa(i))
+		found, err := s.Restore(fx.flow, fx.key, fx.prefixes, dst)
+		if fx.found == "" {
+			if err != ErrNotFound {
+				t.Errorf("%d - expected not found got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if found != fx.found {
+			t.Errorf("%d - found %s wanted %s", i, found, fx.found)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dst, "mod", "a", "a.go"))
+		if err != nil || string(b) != "package a" {
+			t.Errorf("%d - bad restore %s %v", i, b, err)
+		}
+		if l, err := os.Readlink(filepath.Join(dst, "mod", "link")); err != nil || l != "b.txt" {
+			t.Errorf("%d - bad link %s %v", i, l, err)
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	t.Parallel()
+
+	root := testDir(t)
+	defer os.RemoveAll(root)
+	ws := filepath.Join(root, "ws")
+	writeFile(t, filepath.Join(ws, "dep"), "some dependency")
+
+	s, err := New(filepath.Join(root, "cache"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := s.Save("f1", "one", ws, []string{"dep"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.max = 2*size + size/2 // room for two
+
+	// make one older than two, then use one so that two is the least recently used
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(s.path("f1", "one"), old, old)
+	if _, err := s.Save("f2", "two", ws, []string{"dep"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(s.path("f2", "two"), old, old)
+	if _, err := s.Restore("f1", "one", nil, filepath.Join(root, "r")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save("f1", "three", ws, []string{"dep"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// eviction is across all the flows
+	if !s.Has("f1", "one") || s.Has("f2", "two") || !s.Has("f1", "three") {
+		t.Error("wrong entries evicted", s.entries())
+	}
+	if s.Size() > s.max {
+		t.Error("cache too big", s.Size())
+	}
+}
+
+func TestExtractEscapes(t *testing.T) {
+	t.Parallel()
+
+	root := testDir(t)
+	defer os.RemoveAll(root)
+	outside := filepath.Join(root, "outside")
+	os.MkdirAll(outside, 0700)
+
+	type file struct {
+		name string
+		link string // a symlink to this if set, else a file
+	}
+	fixtures := []struct {
+		files []file
+		bad   bool
+	}{
+		{files: []file{{name: "a/b.txt"}, {name: "a/l", link: "b.txt"}, {name: "a/up", link: "../x"}}},
+		{files: []file{{name: "abs", link: outside}}, bad: true},
+		{files: []file{{name: "a/up", link: "../../outside"}}, bad: true},
+		{files: []file{{name: "dir", link: "."}, {name: "dir/f.txt"}}, bad: true}, // through a link it made
+		{files: []file{{name: "out", link: "x"}, {name: "out/f.txt"}}, bad: true},
+	}
+	for i, fx := range fixtures {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(zw)
+		for _, f := range fx.files {
+			hdr := &tar.Header{Name: f.name, Mode: 0600, Typeflag: tar.TypeReg, Size: 1}
+			if f.link != "" {
+				hdr = &tar.Header{Name: f.name, Mode: 0777, Typeflag: tar.TypeSymlink, Linkname: f.link}
+			}
+			tw.WriteHeader(hdr)
+			if f.link == "" {
+				tw.Write([]byte("x"))
+			}
+		}
+		tw.Close()
+		zw.Close()
+
+		base := filepath.Join(root, "ws", strconv.Itoa(i))
+		err := extract(buf, base)
+		if (err != nil) != fx.bad {
+			t.Errorf("%d - got error %v", i, err)
+		}
+		if fis, _ := ioutil.ReadDir(outside); len(fis) != 0 {
+			t.Errorf("%d - wrote outside the workspace", i)
+		}
+	}
+
+	// a workspace directory linked to outside it is neither archived nor extracted through
+	ws := filepath.Join(root, "linked")
+	os.MkdirAll(ws, 0700)
+	writeFile(t, filepath.Join(outside, "secret", "key"), "secret")
+	os.Symlink(outside, filepath.Join(ws, "deps"))
+	if err := archive(ioutil.Discard, ws, []string{"deps/secret"}); err == nil {
+		t.Error("archived through a linked directory")
+	}
+	buf := &bytes.Buffer{}
+	src := filepath.Join(root, "src")
+	writeFile(t, filepath.Join(src, "deps", "secret", "key"), "replaced")
+	if err := archive(buf, src, []string{"deps"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := extract(buf, ws); err == nil {
+		t.Error("extracted through a linked directory")
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(outside, "secret", "key")); string(b) != "secret" {
+		t.Error("overwrote a file outside the workspace", string(b))
+	}
+}
@@ -5,25 +5,95 @@ package client
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/floeit/floe/artifact"
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/metrics"
+	"github.com/floeit/floe/runlog"
 )
 
+// reqTimeout is the longest any single call to another host may take
+const reqTimeout = time.Second * 30
+
+var (
+	callSeconds = metrics.NewHistogram("floe_p2p_call_seconds",
+		"How long calls to other hosts take to respond, by the peer base url.", metrics.DefBuckets, "peer")
+	callErrors = metrics.NewCounter("floe_p2p_call_errors_total",
+		"Calls to other hosts that failed or got a server error, by the peer base url.", "peer")
+)
+
+// measured records the latency and errors of the calls to the peer
+type measured struct {
+	peer string
+	next http.RoundTripper
+}
+
+func (m measured) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := m.next.RoundTrip(req)
+	callSeconds.Since(start, m.peer)
+	if err != nil || resp.StatusCode >= http.StatusInternalServerError {
+		callErrors.Inc(m.peer)
+	}
+	return resp, err
+}
+
 // HostConfig the public config data of a host
 type HostConfig struct {
-	HostID  string
-	BaseURL string
-	Online  bool
-	Tags    []string
+	HostID     string
+	BaseURL    string
+	Online     bool // the host is reachable and ready to accept runs
+	Tags       []string
+	MaxRuns    int    // the maximum number of concurrent runs the host will accept, 0 is unlimited
+	ActiveRuns int    // the number of runs currently active on the host
+	FreeDisk   uint64 // bytes free in the hosts workspace root
+
+	LastSeen  time.Time // when we last successfully got the config from the host
+	Reachable bool      // the host answered the last ping, even if it is not online
+}
+
+// HasCapacity returns true if the host is online and can accept another run
+func (h HostConfig) HasCapacity() bool {
+	if !h.Online {
+		return false
+	}
+	return h.MaxRuns == 0 || h.ActiveRuns < h.MaxRuns
+}
+
+// lessLoaded returns true if h is considered less busy than o. Hosts with fewer active
+// runs are preferred, then those with more free slots, then those with more free disk.
+func (h HostConfig) lessLoaded(o HostConfig) bool {
+	if h.ActiveRuns != o.ActiveRuns {
+		return h.ActiveRuns < o.ActiveRuns
+	}
+	hs, ofs := h.freeSlots(), o.freeSlots()
+	if hs != ofs {
+		return hs > ofs
+	}
+	return h.FreeDisk > o.FreeDisk
+}
+
+// freeSlots returns how many more runs the host can take, unlimited hosts return max int
+func (h HostConfig) freeSlots() int {
+	if h.MaxRuns == 0 {
+		return int(^uint(0) >> 1)
+	}
+	return h.MaxRuns - h.ActiveRuns
 }
 
 // TagsMatch returns true is all tags are present in the receivers tags
@@ -43,6 +113,45 @@ func (h HostConfig) TagsMatch(tags []string) bool {
 	return true
 }
 
+// Creds are the credentials a host uses to authenticate itself with its peers.
+type Creds struct {
+	// Token is the shared cluster token sent with each request.
+	Token string
+	// TLS if not nil is used for all peer connections, if it contains a certificate this
+	// host will present it, identifying itself to its peers by the certificate common name.
+	TLS *tls.Config
+}
+
+// TLSConfig returns a tls config that presents the certificate and key, and only trusts
+// peers with certificates signed by the CAs in the caFile bundle.
+func TLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := LoadCAs(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	}, nil
+}
+
+// LoadCAs returns a cert pool of all the certificates in the PEM encoded caFile bundle.
+func LoadCAs(caFile string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in the ca bundle: " + caFile)
+	}
+	return pool, nil
+}
+
 // FloeHost provides methods to access a host api
 type FloeHost struct {
 	sync.RWMutex
@@ -50,22 +159,45 @@ type FloeHost struct {
 	// Config is the public config
 	config HostConfig
 
-	token string
+	token  string
+	client *http.Client
+
+	stop     chan struct{} // closed to stop the pinger
+	stopOnce sync.Once
 }
 
 // New returns a new FloeHost
-func New(base, token string) *FloeHost {
+func New(base string, creds Creds) *FloeHost {
+	var tr http.RoundTripper = http.DefaultTransport
+	if creds.TLS != nil {
+		tr = &http.Transport{
+			TLSClientConfig: creds.TLS,
+		}
+	}
+	hc := &http.Client{
+		Timeout:   reqTimeout,
+		Transport: measured{peer: base, next: tr},
+	}
 	fh := &FloeHost{
 		config: HostConfig{
 			BaseURL: base + "/p2p",
 		},
-		token: token,
+		token:  creds.Token,
+		client: hc,
+		stop:   make(chan struct{}),
 	}
 	// start the ping heartbeat to the target floe host
 	go fh.pinger()
 	return fh
 }
 
+// Stop stops the heartbeat to the host, it is used when a host leaves the cluster.
+func (f *FloeHost) Stop() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
 // GetConfig returns the config
 func (f *FloeHost) GetConfig() HostConfig {
 	f.RLock()
@@ -74,25 +206,70 @@ func (f *FloeHost) GetConfig() HostConfig {
 }
 
 // AttemptExecute tries to execute the flow matching the flowref and instigating event.
-// Returns true if the host accepted the run.
-func (f *FloeHost) AttemptExecute(pend interface{}) bool {
+// Returns true if the host accepted the run. A non nil error means the outcome is unknown,
+// the host may or may not have started the run, so the same pend should only be retried
+// against the same host.
+func (f *FloeHost) AttemptExecute(pend interface{}) (bool, error) {
 	w := wrap{}
 
 	code, err := f.post("/flows/exec", pend, &w)
 	if err != nil {
-		log.Error(err)
-		return false
+		return false, err
 	}
 	switch code {
 	case http.StatusOK:
-		return true
+		// count it locally so that further dispatches before the next ping see the extra load
+		f.Lock()
+		f.config.ActiveRuns++
+		f.Unlock()
+		return true, nil
 	case http.StatusConflict:
 		log.Debugf("host %s busy: %s", f.GetConfig().HostID, w.Message)
-	default:
-		log.Errorf("got response: %d from %s, with: %s", code, f.GetConfig().HostID, w.Message)
+		return false, nil
 	}
+	return false, fmt.Errorf("got response: %d from %s, with: %s", code, f.GetConfig().HostID, w.Message)
+}
 
-	return false
+// GetPends decodes the pending list of the host into pends, returning false if there was a problem.
+func (f *FloeHost) GetPends(pends interface{}) bool {
+	w := wrap{
+		Payload: pends,
+	}
+	code, err := f.get("/pends", &w)
+	if err != nil {
+		log.Error(err)
+		return false
+	}
+	if code != http.StatusOK {
+		log.Errorf("got pends response: %d from %s, with: %s", code, f.GetConfig().HostID, w.Message)
+		return false
+	}
+	return true
+}
+
+// ByLoad returns the hosts that have capacity for another run ordered least loaded first.
+// Hosts that are offline or full are excluded.
+func ByLoad(hosts []*FloeHost) []*FloeHost {
+	type hc struct {
+		host *FloeHost
+		conf HostConfig
+	}
+	avail := []hc{}
+	for _, h := range hosts {
+		c := h.GetConfig()
+		if !c.HasCapacity() {
+			continue
+		}
+		avail = append(avail, hc{host: h, conf: c})
+	}
+	sort.SliceStable(avail, func(i, j int) bool {
+		return avail[i].conf.lessLoaded(avail[j].conf)
+	})
+	res := make([]*FloeHost, len(avail))
+	for i, a := range avail {
+		res[i] = a.host
+	}
+	return res
 }
 
 // RunSummaries holds slices of RunSummary for each group of run
@@ -153,18 +330,20 @@ type merge struct {
 }
 
 type data struct {
-	Enabled bool
-	Started time.Time
-	Stopped time.Time
-	Opts    nt.Opts
+	Enabled    bool
+	Started    time.Time
+	Stopped    time.Time
+	Opts       nt.Opts
+	ApprovedBy string
 }
 
 type exec struct {
-	Started time.Time
-	Stopped time.Time
-	Good    bool
-	Opts    nt.Opts
-	Logs    []string
+	Started   time.Time
+	Stopped   time.Time
+	Good      bool
+	Opts      nt.Opts
+	Log       string
+	Artifacts []artifact.File
 }
 
 // Run is a specific invocation of a flow
@@ -181,6 +360,7 @@ type Run struct {
 	MergeNodes map[string]merge
 	DataNodes  map[string]data
 	ExecNodes  map[string]exec
+	Logs       map[string]runlog.Page // the tail of the output of each exec node by node id
 }
 
 // FindRun - finds the run in any of the peer hosts
@@ -205,29 +385,187 @@ func (f *FloeHost) FindRun(flowID, runID string) *Run {
 	return nil
 }
 
+// CancelRun asks the host to cancel the run on behalf of user, returning false if the host
+// does not have the run pending or active.
+func (f *FloeHost) CancelRun(flowID, runID, user string) (bool, error) {
+	w := wrap{}
+	rq := struct {
+		FlowID string
+		RunID  string
+		User   string
+	}{flowID, runID, user}
+	code, err := f.post("/cancel", rq, &w)
+	if err != nil {
+		return false, err
+	}
+	switch code {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("got cancel response: %d from %s, with: %s", code, f.GetConfig().HostID, w.Message)
+}
+
+// NodeLog returns a page of the output of the node of the run, runlog.ErrNotFound if the host
+// does not have it.
+func (f *FloeHost) NodeLog(flowID, runID, nodeID string, offset int64, limit int) (runlog.Page, error) {
+	pg := runlog.Page{}
+	w := wrap{Payload: &pg}
+	code, err := f.get(fmt.Sprintf("/flows/%s/runs/%s/nodes/%s/logs?offset=%d&limit=%d",
+		url.PathEscape(flowID), url.PathEscape(runID), url.PathEscape(nodeID), offset, limit), &w)
+	if err != nil {
+		return pg, err
+	}
+	switch code {
+	case http.StatusOK:
+		return pg, nil
+	case http.StatusNotFound:
+		return pg, runlog.ErrNotFound
+	}
+	return pg, fmt.Errorf("got node log response: %d from %s, with: %s", code, f.GetConfig().HostID, w.Message)
+}
+
+// OpenNodeLog returns the whole output of the node of the run, runlog.ErrNotFound if the host
+// does not have it. The caller must close it.
+func (f *FloeHost) OpenNodeLog(flowID, runID, nodeID string) (io.ReadCloser, error) {
+	f.RLock()
+	path := f.config.BaseURL + fmt.Sprintf("/flows/%s/runs/%s/nodes/%s/logs/raw",
+		url.PathEscape(flowID), url.PathEscape(runID), url.PathEscape(nodeID))
+	f.RUnlock()
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Floe-Auth", f.token)
+
+	// the download can take longer than a normal request
+	c := *f.client
+	c.Timeout = 0
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, runlog.ErrNotFound
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("got node log download response: %d from %s", resp.StatusCode, f.GetConfig().HostID)
+}
+
+// OpenArtifact returns the named artifact kept from the node of the run, artifact.ErrNotFound if
+// the host does not have it. The caller must close it.
+func (f *FloeHost) OpenArtifact(flowID, runID, nodeID, name string) (io.ReadCloser, error) {
+	f.RLock()
+	path := f.config.BaseURL + fmt.Sprintf("/flows/%s/runs/%s/artifacts/%s/%s",
+		url.PathEscape(flowID), url.PathEscape(runID), url.PathEscape(nodeID), (&url.URL{Path: name}).EscapedPath())
+	f.RUnlock()
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Floe-Auth", f.token)
+
+	// the download can take longer than a normal request
+	c := *f.client
+	c.Timeout = 0
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, artifact.ErrNotFound
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("got artifact download response: %d from %s", resp.StatusCode, f.GetConfig().HostID)
+}
+
+// OpenRunStream opens the server sent event stream of the run, resuming after lastEventID if it
+// is set, with the query passed on as is. It returns nil if the host is not executing the run.
+// The caller must close it.
+func (f *FloeHost) OpenRunStream(flowID, runID, lastEventID, query string) (io.ReadCloser, error) {
+	f.RLock()
+	path := f.config.BaseURL + fmt.Sprintf("/flows/%s/runs/%s/stream", url.PathEscape(flowID), url.PathEscape(runID))
+	f.RUnlock()
+	if query != "" {
+		path += "?" + query
+	}
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Floe-Auth", f.token)
+	req.Header.Add("Accept", "text/event-stream")
+	if lastEventID != "" {
+		req.Header.Add("Last-Event-ID", lastEventID)
+	}
+
+	// the stream lasts as long as the run
+	c := *f.client
+	c.Timeout = 0
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("got run stream response: %d from %s", resp.StatusCode, f.GetConfig().HostID)
+}
+
 type wrap struct {
 	Message string
 	Payload interface{}
 }
 
 func (f *FloeHost) pinger() {
+	// ping straight away so newly discovered hosts are usable as soon as possible
+	f.ping()
 	tk := time.NewTicker(time.Second * 10)
-	for range tk.C {
-		baseURL := f.config.BaseURL
-		conf, err := f.fetchConf()
-		f.Lock()
-		if conf.HostID == "" || err != nil {
-			log.Error("cant get config from", f.config.BaseURL, err)
-			f.config.Online = false
-		} else {
-			f.config = conf
-			f.config.Online = true
-			f.config.BaseURL = baseURL
+	defer tk.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-tk.C:
+			f.ping()
 		}
-		f.Unlock()
 	}
 }
 
+func (f *FloeHost) ping() {
+	f.RLock()
+	baseURL := f.config.BaseURL
+	f.RUnlock()
+	conf, err := f.fetchConf()
+	f.Lock()
+	if conf.HostID == "" || err != nil {
+		log.Error("cant get config from", baseURL, err)
+		f.config.Online = false
+		f.config.Reachable = false
+	} else {
+		// the host says if it is online, it is not if it is not ready for more runs
+		f.config = conf
+		f.config.Reachable = true
+		f.config.BaseURL = baseURL
+		f.config.LastSeen = time.Now()
+	}
+	f.Unlock()
+}
+
 func (f *FloeHost) fetchConf() (HostConfig, error) {
 	w := wrap{}
 	c := struct {
@@ -277,9 +615,7 @@ func (f *FloeHost) req(method, sPath string, rq, rp interface{}) (status int, er
 	// add the auth
 	req.Header.Add("X-Floe-Auth", f.token)
 
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
+	resp, err := f.client.Do(req)
 	if err != nil {
 		return 0, err
 	}
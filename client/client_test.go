@@ -1,6 +1,24 @@
 package client
 
-import "testing"
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestTagsMatch(t *testing.T) {
 	fix := []struct {
@@ -54,3 +72,114 @@ func TestTagsMatch(t *testing.T) {
 		}
 	}
 }
+
+func TestByLoad(t *testing.T) {
+	hosts := []*FloeHost{
+		{config: HostConfig{HostID: "offline", Online: false}},
+		{config: HostConfig{HostID: "full", Online: true, MaxRuns: 2, ActiveRuns: 2}},
+		{config: HostConfig{HostID: "busy", Online: true, ActiveRuns: 3}},
+		{config: HostConfig{HostID: "one-slot", Online: true, MaxRuns: 2, ActiveRuns: 1}},
+		{config: HostConfig{HostID: "unlimited", Online: true, ActiveRuns: 1}},
+		{config: HostConfig{HostID: "idle", Online: true, MaxRuns: 4, FreeDisk: 10}},
+		{config: HostConfig{HostID: "idle-more-disk", Online: true, MaxRuns: 4, FreeDisk: 20}},
+	}
+	expected := []string{"idle-more-disk", "idle", "unlimited", "one-slot", "busy"}
+
+	got := ByLoad(hosts)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d hosts got %d", len(expected), len(got))
+	}
+	for i, h := range got {
+		if h.GetConfig().HostID != expected[i] {
+			t.Errorf("%d expected %s got %s", i, expected[i], h.GetConfig().HostID)
+		}
+	}
+}
+
+// writeCert creates a key and certificate for cn signed by parent (or self signed if parent is nil)
+// and writes them as PEM files in dir, returning the certificate and key
+func writeCert(t *testing.T, dir, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	kb, _ := x509.MarshalECPrivateKey(key)
+	ioutil.WriteFile(filepath.Join(dir, cn+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, cn+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0600)
+	return cert, key
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "h1", ca, caKey)
+	srvCert, srvKey := writeCert(t, dir, "h2", ca, caKey)
+
+	// a peer that requires client certs and reports the caller common name as its id
+	pool, err := LoadCAs(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		json.NewEncoder(w).Encode(struct{ Payload interface{} }{
+			struct{ Config HostConfig }{HostConfig{HostID: "h2-saw-" + cn}},
+		})
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{srvCert.Raw},
+			PrivateKey:  srvKey,
+		}},
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tc, err := TLSConfig(filepath.Join(dir, "h1.crt"), filepath.Join(dir, "h1.key"), filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh := New(ts.URL, Creds{TLS: tc})
+	defer fh.Stop()
+	conf, err := fh.fetchConf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.HostID != "h2-saw-h1" {
+		t.Errorf("peer did not identify us by our certificate, got: %s", conf.HostID)
+	}
+
+	// without a client certificate the peer must refuse us
+	noCert := New(ts.URL, Creds{TLS: &tls.Config{RootCAs: tc.RootCAs}})
+	defer noCert.Stop()
+	if _, err := noCert.fetchConf(); err == nil {
+		t.Error("request without a client certificate should have failed")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
 )
 
 const adminToken = "a-test-admin-token"
@@ -53,6 +54,64 @@ func TestWebUnauth(t *testing.T) {
 	}
 }
 
+func TestWebAPIToken(t *testing.T) {
+	setup(t)
+	tok := webLogin(t)
+
+	create := func(scopes ...string) string {
+		pl := struct {
+			Token string
+		}{}
+		resp := &genResp{Payload: &pl}
+		rq := struct {
+			Name   string
+			Scopes []string
+		}{"ci", scopes}
+		if !webPost(t, tok, "/tokens", rq, resp, []int{201}) {
+			t.Fatal("token create failed", resp.Message)
+		}
+		return pl.Token
+	}
+
+	// a read token sees all the flows
+	flows := &flowsResp{}
+	if !webGet(t, create("read"), "/flows", &genResp{Payload: flows}, []int{200}) {
+		t.Error("read token should list flows")
+	}
+	if len(flows.Flows) != 2 {
+		t.Error("read token should see 2 flows got", len(flows.Flows))
+	}
+
+	// and none of the common config beyond what the web app needs
+	all := struct {
+		Common map[string]interface{}
+	}{}
+	if !webGet(t, create("read"), "/flows", &genResp{Payload: &all}, []int{200}) {
+		t.Error("read token should list flows")
+	}
+	if _, ok := all.Common["Auth"]; ok || len(all.Common) != 1 {
+		t.Error("read token should only see the base url of the common config got", all.Common)
+	}
+
+	// a trigger token can not read
+	trig := create("trigger:build-project")
+	if !webGet(t, trig, "/flows/build-project", &genResp{}, []int{403}) {
+		t.Error("trigger token should not view the flow")
+	}
+
+	// and can not manage tokens
+	if !webGet(t, trig, "/tokens", &genResp{}, []int{403}) {
+		t.Error("api token should not list tokens")
+	}
+
+	// bad scopes are rejected
+	rq := struct {
+		Name   string
+		Scopes []string
+	}{"bad", []string{"everything"}}
+	webPost(t, tok, "/tokens", rq, &genResp{}, []int{400})
+}
+
 func TestWebLaunch(t *testing.T) {
 	setup(t)
 
@@ -123,6 +182,9 @@ common:
     
     workspace-root: "%tmp/floe"
 
+    auth:
+        user-file: "%users"
+
 flows:
     - id: build-project              # the name of this flow
       ver: 1
@@ -200,6 +262,19 @@ flows:
           ignore-fail: false    
 `)
 
+	// the user that webLogin logs in as
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := ioutil.TempFile("", "floe-users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users.WriteString("admin:" + hash + "\n")
+	users.Close()
+	in = bytes.Replace(in, []byte("%users"), []byte(users.Name()), 1)
+
 	log.SetLevel(8)
 	basePath := "/build/api"
 	addr := "127.0.0.1:0"
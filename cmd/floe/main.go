@@ -5,13 +5,18 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
 
+	"github.com/floeit/floe/client"
 	"github.com/floeit/floe/config"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/hub"
 	"github.com/floeit/floe/log"
 	"github.com/floeit/floe/path"
+	"github.com/floeit/floe/secret"
 	"github.com/floeit/floe/server"
 	"github.com/floeit/floe/store"
 )
@@ -22,6 +27,7 @@ func main() {
 	flag.StringVar(&c.HostName, "host_name", "h1", "a short host name to use in id creation and routing")
 	flag.StringVar(&c.AdminToken, "admin", "", "admin token to share in a cluster to confirm it's a p2p call")
 	flag.StringVar(&c.Tags, "tags", "master", "host tags")
+	flag.IntVar(&c.MaxRuns, "max_runs", 0, "maximum number of concurrent runs this host will accept (0 is unlimited)")
 
 	flag.StringVar(&c.PubBind, "pub_bind", ":443", "what to bind the public server to")
 	flag.StringVar(&c.PubCert, "pub_cert", "", "public certificate path")
@@ -30,6 +36,7 @@ func main() {
 	flag.StringVar(&c.PrvBind, "prv_bind", "", "what to bind the private server to")
 	flag.StringVar(&c.PrvCert, "prv_cert", "", "private certificate path")
 	flag.StringVar(&c.PrvKey, "prv_key", "", "key path for the private endpoint")
+	flag.StringVar(&c.PrvCA, "prv_ca", "", "ca bundle path - if set peers must use certificates signed by these CAs (mutual tls)")
 
 	flag.BoolVar(&c.WebDev, "dev", false, "set to true to use local webapp folder during development")
 
@@ -41,9 +48,99 @@ func main() {
 		os.Exit(1)
 	}
 
-	log.Error(start(c, cfg, nil))
+	switch flag.Arg(0) {
+	case "secret":
+		err = secretCmd(cfg, flag.Args()[1:])
+	case "migrate-store":
+		err = migrateCmd(cfg)
+	default:
+		err = start(c, cfg, nil)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
+// migrateCmd imports all the json files of the local store into the bolt store, it must be run
+// while floe is stopped. The json files are left in place.
+//
+//	floe -conf config.yml migrate-store
+func migrateCmd(conf []byte) error {
+	c, err := config.ParseYAML(conf)
+	if err != nil {
+		return err
+	}
+	c.Defaults()
+	root, err := path.Expand(c.Common.StoreRoot)
+	if err != nil {
+		return err
+	}
+	bs, err := store.NewBoltStore(filepath.Join(root, boltFile))
+	if err != nil {
+		return err
+	}
+	defer bs.Close()
+	keys, err := bs.ImportLocal(filepath.Join(root, "store"))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		fmt.Println("imported", k)
+	}
+	fmt.Printf("imported %d keys into %s, set store-type: bolt to use it\n", len(keys), filepath.Join(root, boltFile))
+	return nil
+}
+
+// secretCmd manages the secrets file named in the config:
+//
+//	floe -conf config.yml secret list
+//	floe -conf config.yml secret set NAME < value
+//	floe -conf config.yml secret rm NAME
+func secretCmd(conf []byte, args []string) error {
+	c, err := config.ParseYAML(conf)
+	if err != nil {
+		return err
+	}
+	c.Defaults()
+	sc := c.Common.Secrets
+	if sc.File == "" {
+		return fmt.Errorf("no secrets file in the config")
+	}
+	p, err := path.Expand(sc.File)
+	if err != nil {
+		return err
+	}
+	f, err := secret.OpenFile(p, os.Getenv(sc.KeyEnv))
+	if err != nil {
+		return err
+	}
+	usage := fmt.Errorf("usage: secret list | set NAME (value on stdin) | rm NAME")
+	if len(args) == 0 {
+		return usage
+	}
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		for _, n := range f.Names() {
+			fmt.Println(n)
+		}
+		return nil
+	case args[0] == "set" && len(args) == 2:
+		// read the value from stdin so it is not left in the shell history
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return f.Set(args[1], strings.TrimRight(string(b), "\r\n"))
+	case args[0] == "rm" && len(args) == 2:
+		return f.Delete(args[1])
+	}
+	return usage
+}
+
+// boltFile is the name of the bolt store database in the store root
+const boltFile = "floe.db"
+
 type srvConf struct {
 	server.Conf
 
@@ -51,11 +148,13 @@ type srvConf struct {
 	HostName   string // the name of this host
 	AdminToken string // the token to use to verify nodes in the cluster
 	Tags       string // tags for this server to be matched against tags specified in the flows
+	MaxRuns    int    // the maximum number of concurrently active runs on this host
 
 	WebDev bool // use local file system for web assets
 }
 
 // %store_root%/store         "~/.floe/store"
+// %store_root%/floe.db       "~/.floe/floe.db"
 // %workspace_root%/spaces    "~/.floe/spaces"
 
 func start(sc srvConf, conf []byte, addr chan string) error {
@@ -78,15 +177,93 @@ func start(sc srvConf, conf []byte, addr chan string) error {
 		if err != nil {
 			return err
 		}
+	case "bolt":
+		root, err := path.Expand(c.Common.StoreRoot)
+		if err != nil {
+			return err
+		}
+		s, err = store.NewBoltStore(filepath.Join(root, boltFile))
+		if err != nil {
+			return err
+		}
+	case "s3":
+		s, err = store.NewS3Store(c.Common.StoreRoot, sc.HostName, store.S3Options{
+			Endpoint:  c.Common.S3.Endpoint,
+			Region:    c.Common.S3.Region,
+			AccessKey: c.Common.S3.AccessKey,
+			SecretKey: c.Common.S3.SecretKey,
+		})
+		if err != nil {
+			return err
+		}
 	default:
 		return fmt.Errorf("%s is not a supported store", c.Common.StoreType)
 	}
-	// TODO - implement other stores e.g. s3
+
+	creds := client.Creds{
+		Token: sc.AdminToken,
+	}
+	if sc.PrvCA != "" {
+		if sc.PrvBind == "" || sc.PrvBind == sc.PubBind || sc.PrvCert == "" || sc.PrvKey == "" {
+			return fmt.Errorf("prv_ca needs a separate prv_bind with a prv_cert and prv_key")
+		}
+		// present our own private cert to peers and only trust peers signed by the CA
+		creds.TLS, err = client.TLSConfig(sc.PrvCert, sc.PrvKey, sc.PrvCA)
+		if err != nil {
+			return err
+		}
+	}
 
 	q := &event.Queue{}
-	hub := hub.New(sc.HostName, sc.Tags, sc.AdminToken, c, s, q)
+	hub := hub.New(sc.HostName, sc.Tags, creds, sc.MaxRuns, c, s, q)
 	server.AdminToken = sc.AdminToken
+	server.LoadConfig = confLoader(sc.ConfFile)
+
+	go drainOnSignal(hub)
+	go reloadOnSignal(hub, server.LoadConfig)
 
 	server.LaunchWeb(sc.Conf, c.Common.BaseURL, hub, q, addr, sc.WebDev)
 	return nil
 }
+
+// drainOnSignal drains the hub when the process is asked to stop, which shuts the servers down
+// once it has drained. A second signal exits immediately.
+func drainOnSignal(h *hub.Hub) {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	sig := <-sigs
+	log.Info("got signal", sig, "- draining, signal again to exit now")
+	go h.Drain()
+	<-sigs
+	log.Error("exiting without draining")
+	os.Exit(1)
+}
+
+// confLoader returns a function that reads and parses the config file again
+func confLoader(file string) func() (*config.Config, error) {
+	return func() (*config.Config, error) {
+		if file == "" {
+			return nil, fmt.Errorf("no config file to reload")
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return config.ParseYAML(b)
+	}
+}
+
+// reloadOnSignal reloads the config each time the process gets a SIGHUP, a config that fails to
+// load is logged and the current config is kept.
+func reloadOnSignal(h *hub.Hub, load func() (*config.Config, error)) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	for range sigs {
+		c, err := load()
+		if err != nil {
+			log.Error("config not reloaded:", err)
+			continue
+		}
+		h.Reload(c, "")
+	}
+}
@@ -27,28 +27,203 @@ func (c *Config) Defaults() {
 	if c.Common.StoreRoot == "" {
 		c.Common.StoreRoot = c.Common.WorkspaceRoot
 	}
+	if c.Common.Cache.MaxMB == 0 {
+		c.Common.Cache.MaxMB = 2048
+	}
+	if c.Common.Retention.Period == 0 {
+		c.Common.Retention.Period = 10
+	}
+	if c.Common.Sandbox.CleanEnv && len(c.Common.Sandbox.EnvAllow) == 0 {
+		c.Common.Sandbox.EnvAllow = []string{"PATH", "HOME", "USER", "LANG", "TZ", "TMPDIR"}
+	}
+	if c.Common.DrainGrace == 0 {
+		c.Common.DrainGrace = 300
+	}
+	if c.Common.Health.MinFreeMB == 0 {
+		c.Common.Health.MinFreeMB = c.Common.Retention.MinFreeMB
+	}
+	if c.Common.Secrets.KeyEnv == "" {
+		c.Common.Secrets.KeyEnv = "FLOE_SECRETS_KEY"
+	}
+	if c.Common.Secrets.EnvPrefix == "" {
+		c.Common.Secrets.EnvPrefix = "FLOE_SECRET_"
+	}
 }
 
 type commonConfig struct {
 	// all other floe Hosts
 	Hosts []string
+	// Discovery describes how to find hosts that are not in the static Hosts list
+	Discovery Discovery
 	// the api base url - in case hosting on a sub domain
 	BaseURL string `yaml:"base-url"`
 
 	// WorkspaceRoot is the local disk root directory to store workspace output.
 	WorkspaceRoot string `yaml:"workspace-root"`
 	// StoreType define which type of store to use
-	StoreType string `yaml:"store-type"` // memory, local, ec2, git // TODO
-	// Store Root is local file location, ec2 bucket path, github url
+	StoreType string `yaml:"store-type"` // memory, local, bolt, s3, git // TODO
+	// Store Root is local file location, s3 bucket and key prefix, github url
 	StoreRoot string `yaml:"store-root"`
+	// S3 configures the s3 store, any settings not given are taken from the standard AWS_ env vars
+	S3 S3Config
 
 	GitKey string `yaml:"git-key"` // path to the git key to use
 
+	// ContainerCLI is the docker compatible cli used to run exec tasks with an image, docker or
+	// podman whichever is found first if not set
+	ContainerCLI string `yaml:"container-cli"`
+
+	// Sandbox puts guardrails around the exec tasks run directly on this host
+	Sandbox SandboxConfig
+
+	// Auth configures how users are authenticated
+	Auth AuthConfig
+
+	// Secrets configures where the values of {{secret.NAME}} references in env vars come from
+	Secrets SecretsConfig
+
+	// Cache configures the host level cache used by the cache-save and cache-restore tasks
+	Cache CacheConfig
+
+	// Retention configures when run workspaces and cached downloads are removed
+	Retention RetentionConfig
+
+	// Health configures when the host is ready to accept runs
+	Health HealthConfig
+
+	// DrainGrace is the seconds a draining host waits for its active runs to end, default 300
+	DrainGrace int `yaml:"drain-grace"`
+
 	// StoreCredentials is a string in some format or other to provide needed credentials for
 	// specific store type.
 	// StoreCredentials string `yaml:"store-credentials"`
 }
 
+// Discovery configures how the hosts in a cluster are found at runtime.
+type Discovery struct {
+	// Type is the discovery mechanism - "" or "static" uses the Hosts list only,
+	// "dns" looks up Name, "file" reads hosts from File.
+	Type string
+	// Name is the dns name to resolve. Names starting with an underscore e.g. _floe._tcp.example.com
+	// are looked up as SRV records, others as A (or AAAA) records combined with Port.
+	Name string
+	// Port to use with hosts resolved from A records.
+	Port int
+	// Scheme is the url scheme to prefix discovered hosts with, defaults to http.
+	Scheme string
+	// File is the path of a file listing one host address per line, it is re-read whenever it changes.
+	File string
+	// Period is how many seconds between each discovery refresh, defaults to 10.
+	Period int
+}
+
+// AuthConfig configures how users are authenticated
+type AuthConfig struct {
+	// UserFile is an htpasswd style file of user:bcrypt-hash lines e.g. created with `htpasswd -B`
+	UserFile string `yaml:"user-file"`
+	// Tokens are static tokens that each authenticate as the given user
+	Tokens []AuthToken
+	// Groups lists the users that are members of each group
+	Groups map[string][]string
+	// Roles grants roles to users and groups, if there are none every user is an admin
+	Roles []RoleBinding
+	// OIDC configures single sign on with an OpenID Connect issuer
+	OIDC OIDCConfig `yaml:"oidc"`
+}
+
+// S3Config configures the connection to an S3 compatible object store
+type S3Config struct {
+	Endpoint  string // default https://s3.<region>.amazonaws.com, e.g. http://localhost:9000 for MinIO
+	Region    string
+	AccessKey string `yaml:"access-key"`
+	SecretKey string `yaml:"secret-key" json:"-"`
+}
+
+// SecretsConfig configures the secret providers, a secret is looked for in the environment first
+// then in the file.
+type SecretsConfig struct {
+	// File is the encrypted secrets file, managed with `floe secret`
+	File string
+	// KeyEnv is the environment variable holding the master key of File, default FLOE_SECRETS_KEY
+	KeyEnv string `yaml:"key-env"`
+	// EnvPrefix is the prefix of the environment variables that are secrets, default FLOE_SECRET_
+	// e.g. FLOE_SECRET_NPM_TOKEN provides {{secret.NPM_TOKEN}}
+	EnvPrefix string `yaml:"env-prefix"`
+}
+
+// CacheConfig configures the host level cache of workspace directories
+type CacheConfig struct {
+	// MaxMB is the size the cache is kept below by removing the least recently used entries,
+	// default 2048, -1 is unlimited
+	MaxMB int `yaml:"max-mb"`
+}
+
+// RetentionConfig configures the removal of old run workspaces and cached downloads, the workspaces
+// of active runs and of flows that reuse their space are never removed. Nothing is removed if none
+// of KeepRuns, MaxAgeHours or MinFreeMB are set.
+type RetentionConfig struct {
+	// KeepRuns is how many of the most recent run workspaces of each flow are kept, 0 keeps all
+	KeepRuns int `yaml:"keep-runs"`
+	// MaxAgeHours is the age of workspaces and cached downloads that are removed, 0 is any age
+	MaxAgeHours int `yaml:"max-age-hours"`
+	// MinFreeMB is the free disk below which the oldest workspaces then cached downloads are removed
+	MinFreeMB int `yaml:"min-free-mb"`
+	// Period is the minutes between each clean up, default 10
+	Period int
+}
+
+// SandboxConfig puts guardrails around the exec tasks that are not run in a container, the
+// limits apply to each process and are only supported on linux, 0 is no limit.
+type SandboxConfig struct {
+	// ProcessGroup runs each task in its own session and process group, all the processes in
+	// the group are killed when the task ends
+	ProcessGroup bool   `yaml:"process-group"`
+	CPUSeconds   uint64 `yaml:"cpu-seconds"`
+	MemoryMB     uint64 `yaml:"memory-mb"`
+	OpenFiles    uint64 `yaml:"open-files"`
+	// MaxOutputMB is the output after which a task is killed
+	MaxOutputMB int64 `yaml:"max-output-mb"`
+	// UID and GID are the user and group the tasks run as, floe must run as root to set them
+	UID uint32 `yaml:"uid"`
+	GID uint32 `yaml:"gid"`
+	// CleanEnv starts the tasks with only the EnvAllow vars of the floe environment, by default
+	// PATH, HOME, USER, LANG, TZ and TMPDIR
+	CleanEnv bool     `yaml:"clean-env"`
+	EnvAllow []string `yaml:"env-allow"`
+}
+
+// HealthConfig configures the readiness checks of the host
+type HealthConfig struct {
+	// MinFreeMB is the free disk in the workspace root below which the host is not ready,
+	// default the retention min-free-mb
+	MinFreeMB int `yaml:"min-free-mb"`
+}
+
+// OIDCConfig configures an OpenID Connect login, it is enabled if Issuer is set
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string   `yaml:"client-id"`
+	ClientSecret string   `yaml:"client-secret" json:"-"`
+	RedirectURL  string   `yaml:"redirect-url"` // must be the public .../oidc/callback url
+	Scopes       []string // extra scopes to request, e.g. groups
+	UserClaim    string   `yaml:"user-claim"`   // the claim to use as the user name, default email
+	GroupsClaim  string   `yaml:"groups-claim"` // the claim listing the users groups, default groups
+}
+
+// RoleBinding grants a role to users and groups, optionally only for some flows
+type RoleBinding struct {
+	Role   string // viewer, trigger, approver or admin
+	Users  []string
+	Groups []string
+	Flows  []string // the ids of the flows this role is granted for, all flows if empty
+}
+
+// AuthToken is a static token for a user
+type AuthToken struct {
+	User  string
+	Token string `json:"-"`
+}
+
 // FoundFlow is a struct containing a Flow and trigger that matched this flow.
 // It can be used to decide on the best host to use to run this Flow.
 type FoundFlow struct {
@@ -52,6 +52,7 @@ type Flow struct {
 	HostTags     []string `yaml:"host-tags"`     // tags that must match the tags on the host
 	ResourceTags []string `yaml:"resource-tags"` // tags that if any flow is running with any matching tags then don't launch
 	Env          []string // key=value environment variables with
+	Image        string   // the default container image for the exec tasks
 
 	// Triggers are the node types that define how a run is triggered for this flow.
 	Triggers []*node
@@ -126,6 +127,9 @@ func (f *Flow) Load(cacheDir string) (err error) {
 	if len(newFlow.Env) != 0 {
 		f.Env = newFlow.Env
 	}
+	if newFlow.Image != "" {
+		f.Image = newFlow.Image
+	}
 	if len(newFlow.Tasks) != 0 {
 		f.Tasks = newFlow.Tasks
 	}
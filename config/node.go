@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/floeit/floe/artifact"
 	nt "github.com/floeit/floe/config/nodetype"
 )
 
@@ -52,6 +53,8 @@ type node struct {
 	// TODO - consider if mapping status codes to good and bad is all the complexity we need
 	UseStatus bool    `yaml:"use-status"`
 	Opts      nt.Opts // static config options
+	// Artifacts are glob patterns relative to the workspace of the files to keep when the task succeeds
+	Artifacts []string
 }
 
 func (t *node) Execute(ws *nt.Workspace, opts nt.Opts, output chan string) (int, nt.Opts, error) {
@@ -115,6 +118,11 @@ func (t *node) Waits() int {
 	return len(t.Wait)
 }
 
+// ArtifactPatterns returns the glob patterns of the files to keep if the node succeeds
+func (t *node) ArtifactPatterns() []string {
+	return t.Artifacts
+}
+
 func (t *node) GetTag(subTag string) string {
 	return fmt.Sprintf("%s.%s.%s", t.Class, t.Ref.ID, subTag)
 }
@@ -177,12 +185,21 @@ func (t *node) zero(defaultClass NodeClass, flow FlowRef) error {
 		ID:    t.ID,
 	}
 
+	if t.Class != NcTask && len(t.Artifacts) != 0 {
+		return errors.New("only task nodes can have artifacts")
+	}
+
 	// node specific checks
 	switch t.Class {
 	case NcTask:
 		if len(t.Wait) != 0 {
 			return errors.New("task nodes can not have waits")
 		}
+		for _, p := range t.Artifacts {
+			if err := artifact.CheckPattern(p); err != nil {
+				return err
+			}
+		}
 	case NcMerge:
 		// default to waiting for all of them if not specified
 		if t.Type == "" {
@@ -37,3 +37,28 @@ func TestNodeExec(t *testing.T) {
 	close(output)
 	<-captured
 }
+
+func TestNodeArtifacts(t *testing.T) {
+	t.Parallel()
+
+	fix := []struct {
+		n  node
+		ok bool
+	}{
+		{n: node{Name: "build", Class: NcTask, Artifacts: []string{"bin/*", "reports"}}, ok: true},
+		{n: node{Name: "build", Class: NcTask, Artifacts: []string{"../out/*"}}, ok: false},
+		{n: node{Name: "build", Class: NcTask, Artifacts: []string{"/tmp/x"}}, ok: false},
+		{n: node{Name: "wait", Class: NcMerge, Artifacts: []string{"bin/*"}}, ok: false},
+		{n: node{Name: "start", Class: NcTrigger, Artifacts: []string{"bin/*"}}, ok: false},
+	}
+	for i, f := range fix {
+		err := f.n.zero(NcTask, FlowRef{ID: "flow"})
+		if (err == nil) != f.ok {
+			t.Errorf("%d - expected ok %v got %v", i, f.ok, err)
+		}
+	}
+	n := fix[0].n
+	if p := n.ArtifactPatterns(); len(p) != 2 || p[0] != "bin/*" {
+		t.Error("bad patterns", p)
+	}
+}
@@ -0,0 +1,185 @@
+package nodetype
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/floeit/floe/artifact"
+)
+
+type artifactGetOpts struct {
+	Flow     string   `json:"flow"`     // the flow whose latest good run kept the artifacts, this run if empty
+	Node     string   `json:"node"`     // the id of the node that kept the artifacts
+	Names    []string `json:"names"`    // names or glob patterns of the artifacts to get, all of them if empty
+	Location string   `json:"location"` // the directory in the workspace to copy them into, the workspace if empty
+}
+
+// artifactGet gets artifacts kept by an earlier node, caching them by checksum
+type artifactGet struct{}
+
+func (g artifactGet) Match(ol, or Opts) bool {
+	return true
+}
+
+func (g artifactGet) Execute(ws *Workspace, in Opts, output chan string) (int, Opts, error) {
+	op := artifactGetOpts{}
+	err := decode(in, &op)
+	if err != nil {
+		return 255, nil, err
+	}
+	if op.Node == "" {
+		return 255, nil, fmt.Errorf("problem getting artifact-get node option")
+	}
+	if ws.Artifacts == nil {
+		return 255, nil, fmt.Errorf("no artifacts available to this workspace")
+	}
+	loc := filepath.Clean(filepath.FromSlash(op.Location))
+	if filepath.IsAbs(loc) || loc == ".." || strings.HasPrefix(loc, ".."+string(filepath.Separator)) {
+		return 255, nil, fmt.Errorf("artifact-get location %s must be inside the workspace", op.Location)
+	}
+
+	runID, files, err := ws.Artifacts.Find(op.Flow, op.Node)
+	if err != nil {
+		return 255, nil, err
+	}
+	files, err = pickArtifacts(files, op.Names)
+	if err != nil {
+		return 255, nil, err
+	}
+	from := op.Node
+	if op.Flow != "" {
+		from = op.Flow + "/" + op.Node
+	}
+	output <- fmt.Sprintf("Getting %d artifacts from %s in run %s", len(files), from, runID)
+
+	cache := filepath.Join(ws.FetchCache, "artifacts")
+	if err := os.MkdirAll(cache, 0700); err != nil {
+		return 255, nil, err
+	}
+	for _, f := range files {
+		cached, err := cacheArtifact(ws.Artifacts, cache, op.Flow, runID, op.Node, f)
+		if err != nil {
+			output <- fmt.Sprintf("Getting %s failed: %v", f.Name, err)
+			return 255, nil, err
+		}
+		dst := filepath.Join(ws.BasePath, loc, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return 255, nil, err
+		}
+		// copied not linked, so a task changing its copy can not corrupt the cache
+		if err := copyFile(cached, dst); err != nil {
+			return 255, nil, err
+		}
+		output <- fmt.Sprintf("  %s (%d bytes) copied to %s", f.Name, f.Size, dst)
+	}
+	return 0, nil, nil
+}
+
+// pickArtifacts returns the files matching any of the names, all of them if there are no names.
+// It is an error if any name matches nothing.
+func pickArtifacts(files []artifact.File, names []string) ([]artifact.File, error) {
+	if len(names) == 0 {
+		return files, nil
+	}
+	picked := []artifact.File{}
+	seen := map[string]bool{}
+	for _, n := range names {
+		found := false
+		for _, f := range files {
+			ok, err := path.Match(n, f.Name)
+			if err != nil {
+				return nil, fmt.Errorf("bad artifact name %s: %v", n, err)
+			}
+			if !ok {
+				continue
+			}
+			found = true
+			if !seen[f.Name] {
+				seen[f.Name] = true
+				picked = append(picked, f)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no artifact matches %s", n)
+		}
+	}
+	return picked, nil
+}
+
+// cacheArtifact returns the path of the artifact in the cache, named by its checksum, getting it
+// from the source if it is not already there, or what is there no longer matches its checksum.
+// The checksum of what was got must match.
+func cacheArtifact(src ArtifactSource, cache, flowID, runID, nodeID string, f artifact.File) (string, error) {
+	if b, err := hex.DecodeString(f.SHA256); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("bad artifact checksum: %s", f.SHA256)
+	}
+	cached := filepath.Join(cache, f.SHA256)
+	if sum, err := fileSum(cached); err == nil && sum == f.SHA256 {
+		return cached, nil
+	}
+
+	rc, err := src.Open(flowID, runID, nodeID, f.Name)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	tmp, err := ioutil.TempFile(cache, ".get-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), rc)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return "", fmt.Errorf("checksum mismatch got: %s expected: %s", sum, f.SHA256)
+	}
+	return cached, os.Rename(tmp.Name(), cached)
+}
+
+// fileSum returns the hex encoded sha256 of the file
+func fileSum(name string) (string, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyFile replaces dst with a copy of src
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	os.Remove(dst) // so a link to somewhere else is replaced not written through
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
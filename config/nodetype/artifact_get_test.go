@@ -0,0 +1,145 @@
+package nodetype
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/floeit/floe/artifact"
+)
+
+// testArtifacts is a source of artifacts held in memory
+type testArtifacts struct {
+	content map[string]string // by name
+	sums    map[string]string // override the checksum reported for a name
+	opened  int
+}
+
+func (a *testArtifacts) Find(flowID, nodeID string) (string, []artifact.File, error) {
+	if nodeID != "build" {
+		return "", nil, errors.New("no artifacts")
+	}
+	files := []artifact.File{}
+	for n, c := range a.content {
+		h := sha256.Sum256([]byte(c))
+		sum := hex.EncodeToString(h[:])
+		if s, ok := a.sums[n]; ok {
+			sum = s
+		}
+		files = append(files, artifact.File{Name: n, Size: int64(len(c)), SHA256: sum})
+	}
+	return "h1-1", files, nil
+}
+
+func (a *testArtifacts) Open(flowID, runID, nodeID, name string) (io.ReadCloser, error) {
+	a.opened++
+	return ioutil.NopCloser(strings.NewReader(a.content[name])), nil
+}
+
+func TestArtifactGet(t *testing.T) {
+	t.Parallel()
+
+	fixtures := []struct {
+		opts   Opts
+		sums   map[string]string
+		status int
+		linked []string
+	}{
+		{ // all of them
+			opts:   Opts{"node": "build"},
+			linked: []string{"bin/app", "notes.txt"},
+		},
+		{ // by name and pattern into a location
+			opts:   Opts{"node": "build", "names": []interface{}{"bin/*"}, "location": "deploy"},
+			linked: []string{"deploy/bin/app"},
+		},
+		{ // a name that matches nothing
+			opts:   Opts{"node": "build", "names": []interface{}{"missing"}},
+			status: 255,
+		},
+		{ // a node with no artifacts
+			opts:   Opts{"node": "test"},
+			status: 255,
+		},
+		{ // out of the workspace
+			opts:   Opts{"node": "build", "location": "../up"},
+			status: 255,
+		},
+		{ // corrupted
+			opts:   Opts{"node": "build", "names": []interface{}{"notes.txt"}},
+			sums:   map[string]string{"notes.txt": strings.Repeat("0", 64)},
+			status: 255,
+		},
+	}
+
+	for i, fx := range fixtures {
+		cache, err := ioutil.TempDir("", "floe-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(cache)
+		base, err := ioutil.TempDir("", "floe-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(base)
+
+		src := &testArtifacts{
+			content: map[string]string{"bin/app": "binary", "notes.txt": "notes"},
+			sums:    fx.sums,
+		}
+		ws := &Workspace{BasePath: base, FetchCache: cache, Artifacts: src}
+		op := make(chan string, 100)
+		status, _, _ := artifactGet{}.Execute(ws, fx.opts, op)
+		if status != fx.status {
+			t.Errorf("%d - got status %d wanted %d", i, status, fx.status)
+		}
+		for _, l := range fx.linked {
+			if _, err := os.Stat(filepath.Join(base, l)); err != nil {
+				t.Errorf("%d - %s not linked: %v", i, l, err)
+			}
+		}
+		if fx.status != 0 {
+			continue
+		}
+
+		// a second get comes from the cache
+		opened := src.opened
+		if status, _, err := (artifactGet{}).Execute(ws, fx.opts, op); status != 0 || err != nil {
+			t.Errorf("%d - second get failed %d %v", i, status, err)
+		}
+		if src.opened != opened {
+			t.Errorf("%d - second get did not use the cache", i)
+		}
+
+		// changing a got file leaves the cache alone, and a corrupt cached file is got again
+		l := filepath.Join(base, fx.linked[0])
+		if err := ioutil.WriteFile(l, []byte("changed"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cached, _ := filepath.Glob(filepath.Join(cache, "artifacts", "*"))
+		for _, c := range cached {
+			if b, _ := ioutil.ReadFile(c); string(b) == "changed" {
+				t.Errorf("%d - changing the workspace copy changed the cache", i)
+			}
+			ioutil.WriteFile(c, []byte("corrupt"), 0600)
+		}
+		if status, _, err := (artifactGet{}).Execute(ws, fx.opts, op); status != 0 || err != nil {
+			t.Errorf("%d - get after corruption failed %d %v", i, status, err)
+		}
+		if src.opened != opened+len(fx.linked) {
+			t.Errorf("%d - corrupt cache was used", i)
+		}
+		for _, l := range fx.linked {
+			if b, _ := ioutil.ReadFile(filepath.Join(base, l)); string(b) == "corrupt" || string(b) == "changed" {
+				t.Errorf("%d - %s got %s", i, l, b)
+			}
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package nodetype
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/floeit/floe/cache"
+)
+
+type cacheOpts struct {
+	Key         string   `json:"key"`          // template of the key e.g. go-{{hashFiles "go.sum"}}
+	RestoreKeys []string `json:"restore-keys"` // templates of key prefixes to restore from if the key is not found
+	Paths       []string `json:"paths"`        // the directories or files relative to the workspace to save
+}
+
+// cacheSave saves paths in the workspace to the host cache
+type cacheSave struct{}
+
+func (c cacheSave) Match(ol, or Opts) bool {
+	return true
+}
+
+func (c cacheSave) Execute(ws *Workspace, in Opts, output chan string) (int, Opts, error) {
+	op := cacheOpts{}
+	if err := decode(in, &op); err != nil {
+		return 255, nil, err
+	}
+	if ws.Cache == nil {
+		return 255, nil, fmt.Errorf("no cache available to this workspace")
+	}
+	if len(op.Paths) == 0 {
+		return 255, nil, fmt.Errorf("problem getting cache-save paths option")
+	}
+	key, err := expandKey(op.Key, ws.BasePath)
+	if err != nil {
+		return 255, nil, err
+	}
+	// keys are named by the content of what they depend on so an existing entry is up to date
+	if ws.Cache.Has(ws.Flow, key) {
+		output <- fmt.Sprintf("Cache %s exists, not saving", key)
+		return 0, nil, nil
+	}
+	size, err := ws.Cache.Save(ws.Flow, key, ws.BasePath, op.Paths)
+	if err != nil {
+		output <- fmt.Sprintf("Saving cache %s failed: %v", key, err)
+		return 255, nil, err
+	}
+	output <- fmt.Sprintf("Saved cache %s (%d bytes)", key, size)
+	return 0, nil, nil
+}
+
+// cacheRestore restores an entry from the host cache into the workspace
+type cacheRestore struct{}
+
+func (c cacheRestore) Match(ol, or Opts) bool {
+	return true
+}
+
+func (c cacheRestore) Execute(ws *Workspace, in Opts, output chan string) (int, Opts, error) {
+	op := cacheOpts{}
+	if err := decode(in, &op); err != nil {
+		return 255, nil, err
+	}
+	if ws.Cache == nil {
+		return 255, nil, fmt.Errorf("no cache available to this workspace")
+	}
+	key, err := expandKey(op.Key, ws.BasePath)
+	if err != nil {
+		return 255, nil, err
+	}
+	prefixes := make([]string, len(op.RestoreKeys))
+	for i, rk := range op.RestoreKeys {
+		if prefixes[i], err = expandKey(rk, ws.BasePath); err != nil {
+			return 255, nil, err
+		}
+	}
+
+	found, err := ws.Cache.Restore(ws.Flow, key, prefixes, ws.BasePath)
+	switch {
+	case err == cache.ErrNotFound:
+		// a miss is not a failure, the dependencies will just be downloaded
+		output <- fmt.Sprintf("No cache found for %s", key)
+		return 0, nil, nil
+	case err != nil:
+		output <- fmt.Sprintf("Restoring cache %s failed: %v", found, err)
+		return 255, nil, err
+	case found == key:
+		output <- fmt.Sprintf("Restored cache %s", found)
+	default:
+		output <- fmt.Sprintf("Restored cache %s, the closest match for %s", found, key)
+	}
+	return 0, nil, nil
+}
+
+// expandKey executes the key template, which can use hashFiles, with paths relative to the workspace at base
+func expandKey(tmpl, base string) (string, error) {
+	if tmpl == "" {
+		return "", fmt.Errorf("problem getting cache key option")
+	}
+	t, err := template.New("key").Funcs(template.FuncMap{
+		"hashFiles": func(patterns ...string) (string, error) {
+			return hashFiles(base, patterns)
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("bad cache key %s: %v", tmpl, err)
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, nil); err != nil {
+		return "", fmt.Errorf("bad cache key %s: %v", tmpl, err)
+	}
+	key := buf.String()
+	if err := cache.CheckKey(key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// hashFiles returns the hex sha256 of the names and contents of all the files in the workspace at
+// base matching the glob patterns. It is an error if none match.
+func hashFiles(base string, patterns []string) (string, error) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		if filepath.IsAbs(p) || hasDotDot(p) {
+			return "", fmt.Errorf("hashFiles pattern %s must be relative to the workspace", p)
+		}
+		matches, err := filepath.Glob(filepath.Join(base, filepath.FromSlash(p)))
+		if err != nil {
+			return "", err
+		}
+		for _, m := range matches {
+			if st, err := os.Stat(m); err != nil || !st.Mode().IsRegular() || seen[m] {
+				continue
+			}
+			seen[m] = true
+			names = append(names, m)
+		}
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("hashFiles found no files matching %v", patterns)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, n := range names {
+		rel, _ := filepath.Rel(base, n)
+		fmt.Fprintf(h, "%s\x00", filepath.ToSlash(rel))
+		f, err := os.Open(n)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hasDotDot returns true if any element of the slash or os separated path is ..
+func hasDotDot(p string) bool {
+	for _, e := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == filepath.Separator }) {
+		if e == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package nodetype
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/floeit/floe/cache"
+)
+
+func TestExpandKey(t *testing.T) {
+	t.Parallel()
+
+	base, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	ioutil.WriteFile(filepath.Join(base, "go.sum"), []byte("sums"), 0600)
+	ioutil.WriteFile(filepath.Join(base, "package-lock.json"), []byte("lock"), 0600)
+
+	sum, err := hashFiles(base, []string{"go.sum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures := []struct {
+		tmpl string
+		key  string
+		bad  bool
+	}{
+		{tmpl: "go-", key: "go-"},
+		{tmpl: `go-{{hashFiles "go.sum"}}`, key: "go-" + sum},
+		{tmpl: `go-{{hashFiles "go.sum" "*.sum"}}`, key: "go-" + sum},
+		{tmpl: `all-{{hashFiles "go.sum" "package-lock.json"}}`},
+		{tmpl: `go-{{hashFiles "nope.sum"}}`, bad: true},
+		{tmpl: `go-{{hashFiles "../*/go.sum"}}`, bad: true},
+		{tmpl: `go-{{hashFiles "a/../../go.sum"}}`, bad: true},
+		{tmpl: `go/{{hashFiles "go.sum"}}`, bad: true},
+		{tmpl: `go-{{hashFiles "go.sum"`, bad: true},
+		{tmpl: "", bad: true},
+	}
+	for i, fx := range fixtures {
+		key, err := expandKey(fx.tmpl, base)
+		if (err != nil) != fx.bad {
+			t.Errorf("%d - got error %v", i, err)
+		}
+		if fx.key != "" && key != fx.key {
+			t.Errorf("%d - got key %s wanted %s", i, key, fx.key)
+		}
+	}
+}
+
+func TestCacheSaveRestore(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	c, err := cache.New(filepath.Join(root, "cache"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws1 := &Workspace{BasePath: filepath.Join(root, "ws1"), Cache: c, Flow: "build"}
+	os.MkdirAll(filepath.Join(ws1.BasePath, "deps"), 0700)
+	ioutil.WriteFile(filepath.Join(ws1.BasePath, "go.sum"), []byte("v1"), 0600)
+	ioutil.WriteFile(filepath.Join(ws1.BasePath, "deps", "dep.go"), []byte("dep"), 0600)
+
+	op := make(chan string, 100)
+	save := Opts{"key": `go-{{hashFiles "go.sum"}}`, "paths": []interface{}{"deps"}}
+	if status, _, err := (cacheSave{}).Execute(ws1, save, op); status != 0 || err != nil {
+		t.Fatal("save failed", status, err)
+	}
+
+	// the lock file changed so only the prefix matches
+	ws2 := &Workspace{BasePath: filepath.Join(root, "ws2"), Cache: c, Flow: "build"}
+	os.MkdirAll(ws2.BasePath, 0700)
+	ioutil.WriteFile(filepath.Join(ws2.BasePath, "go.sum"), []byte("v2"), 0600)
+	restore := Opts{"key": `go-{{hashFiles "go.sum"}}`, "restore-keys": []interface{}{"go-"}}
+	if status, _, err := (cacheRestore{}).Execute(ws2, restore, op); status != 0 || err != nil {
+		t.Fatal("restore failed", status, err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(ws2.BasePath, "deps", "dep.go")); err != nil || string(b) != "dep" {
+		t.Error("not restored", string(b), err)
+	}
+
+	// a miss is not a failure
+	ws3 := &Workspace{BasePath: filepath.Join(root, "ws3"), Cache: c, Flow: "build"}
+	if status, _, err := (cacheRestore{}).Execute(ws3, Opts{"key": "npm-1"}, op); status != 0 || err != nil {
+		t.Error("miss failed", status, err)
+	}
+
+	// another flow does not see the entries of this one
+	ws4 := &Workspace{BasePath: filepath.Join(root, "ws4"), Cache: c, Flow: "deploy"}
+	os.MkdirAll(ws4.BasePath, 0700)
+	ioutil.WriteFile(filepath.Join(ws4.BasePath, "go.sum"), []byte("v1"), 0600)
+	if status, _, err := (cacheRestore{}).Execute(ws4, restore, op); status != 0 || err != nil {
+		t.Error("other flow restore failed", status, err)
+	}
+	if _, err := os.Stat(filepath.Join(ws4.BasePath, "deps")); err == nil {
+		t.Error("restored the entry of another flow")
+	}
+}
@@ -23,6 +23,7 @@ type exec struct {
 	Args   []string
 	SubDir string `json:"sub-dir"`
 	Env    []string
+	Image  string // if set the command is run in a container of this image
 }
 
 func (e exec) Match(ol, or Opts) bool {
@@ -65,22 +66,53 @@ func (e exec) Execute(ws *Workspace, in Opts, output chan string) (int, Opts, er
 
 	// expand the workspace var and any env vars for the vars, command and args
 	e.Env = expandEnvOpts(e.Env, ws.BasePath)
+	// then any secrets, which are only ever put in the env
+	for i, v := range e.Env {
+		if e.Env[i], err = ws.Secrets.Expand(v); err != nil {
+			return 255, nil, err
+		}
+	}
 	for i, arg := range args {
 		args[i] = expandEnv(arg, ws.BasePath)
 	}
 	cmd = expandWs(cmd, ws.BasePath)
-	// use any cmd on the new env path, rather than current path
-	cmd = useEnvPathCmd(cmd, e.Env)
 
 	// add in the env var path to the workspace so scripts can use it
 	e.Env = append(e.Env, "FLOEWS="+ws.BasePath)
 
-	status := doRun(filepath.Join(ws.BasePath, e.SubDir), e.Env, output, cmd, args...)
+	dir := filepath.Join(ws.BasePath, e.SubDir)
+	if e.Image != "" {
+		if ws.Containers == nil {
+			return 255, nil, fmt.Errorf("no container runtime to run image %s", e.Image)
+		}
+		// the workspace is at the same path in the container so the paths in the env and args still work
+		cmd, args = ws.Containers.Command(exe.Container{
+			Image:  e.Image,
+			Mounts: []string{ws.BasePath},
+			Dir:    dir,
+			Env:    envNames(e.Env),
+			UID:    ws.Sandbox.UID,
+			GID:    ws.Sandbox.GID,
+		}, cmd, args...)
+	} else {
+		// use any cmd on the new env path, rather than current path
+		cmd = useEnvPathCmd(cmd, e.Env)
+	}
+
+	// a command run as another user needs to own the workspace to work in it
+	if ws.Sandbox.UID != 0 || ws.Sandbox.GID != 0 {
+		if err := chownAll(ws.BasePath, ws.Sandbox.UID, ws.Sandbox.GID); err != nil {
+			return 255, nil, err
+		}
+	}
+
+	status := doRun(ws.Sandbox, ws.Cancel, dir, e.Env, output, cmd, args...)
 
 	return status, Opts{}, nil
 }
 
-func doRun(dir string, env []string, output chan string, cmd string, args ...string) int {
+// doRun runs the command within the sandbox, killing it if cancel is closed
+func doRun(sb exe.Sandbox, cancel <-chan struct{}, dir string, env []string, output chan string, cmd string, args ...string) int {
 	stop := make(chan bool)
 	out := make(chan string)
 
@@ -92,7 +124,7 @@ func doRun(dir string, env []string, output chan string, cmd string, args ...str
 		stop <- true
 	}()
 
-	status := exe.Run(log.Log{}, out, env, dir, cmd, args...)
+	status := exe.RunSandboxed(log.Log{}, out, sb, cancel, env, dir, cmd, args...)
 
 	// wait for output to complete
 	<-stop
@@ -104,6 +136,23 @@ func doRun(dir string, env []string, output chan string, cmd string, args ...str
 	return status
 }
 
+// chownAll gives everything in dir to the user and group, a 0 id is left unchanged
+func chownAll(dir string, uid, gid uint32) error {
+	u, g := int(uid), int(gid)
+	if u == 0 {
+		u = -1
+	}
+	if g == 0 {
+		g = -1
+	}
+	return filepath.Walk(dir, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, u, g)
+	})
+}
+
 // expand the workspace template item with the actual workspace
 func expandEnvOpts(es []string, path string) []string {
 	ne := make([]string, len(es))
@@ -143,6 +192,15 @@ func expandWs(e string, path string) string {
 	return os.ExpandEnv(strings.Replace(e, wsSub, path, -1))
 }
 
+// envNames returns the names of the key=value env vars
+func envNames(env []string) []string {
+	names := make([]string, len(env))
+	for i, e := range env {
+		names[i] = strings.SplitN(e, "=", 2)[0]
+	}
+	return names
+}
+
 func useEnvPathCmd(cmd string, env []string) string {
 	// find path
 	for _, e := range env {
@@ -2,8 +2,14 @@ package nodetype
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/floeit/floe/exe"
+	"github.com/floeit/floe/secret"
 )
 
 func TestExec(t *testing.T) {
@@ -58,6 +64,60 @@ func TestEnvVars(t *testing.T) {
 	testNode(t, "exe env vars", exec{}, opts, []string{`DAN="fart"`, `FLOEWS="`})
 }
 
+func TestEnvSecrets(t *testing.T) {
+	t.Parallel()
+
+	tmp, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal("can't create tmp dir")
+	}
+	defer os.RemoveAll(tmp)
+	ws := &Workspace{
+		BasePath: tmp,
+		Secrets:  secret.NewResolver(secret.Env{"TOK": "s3cret"}),
+	}
+
+	op := make(chan string)
+	var out []string
+	captured := make(chan bool)
+	go func() {
+		for l := range op {
+			out = append(out, ws.Secrets.Mask(l))
+		}
+		captured <- true
+	}()
+	status, _, err := exec{}.Execute(ws, Opts{
+		"cmd": "printenv TOK",
+		"env": []string{"TOK={{secret.TOK}}"},
+	}, op)
+	close(op)
+	<-captured
+	if err != nil || status != 0 {
+		t.Fatal("exec failed", status, err)
+	}
+	found := false
+	for _, l := range out {
+		if strings.Contains(l, "s3cret") {
+			t.Error("secret not masked", l)
+		}
+		if l == secret.Mask {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("secret not in the env", out)
+	}
+
+	// a missing secret fails the node before running anything
+	_, _, err = exec{}.Execute(ws, Opts{
+		"shell": "echo $NOPE",
+		"env":   []string{"NOPE={{secret.NOPE}}"},
+	}, make(chan string, 10))
+	if err == nil {
+		t.Error("missing secret should error")
+	}
+}
+
 func testNode(t *testing.T, msg string, nt NodeType, opts Opts, expected []string) bool {
 	op := make(chan string)
 	var out []string
@@ -110,6 +170,75 @@ func testNode(t *testing.T, msg string, nt NodeType, opts Opts, expected []strin
 	return prob
 }
 
+// fakeRuntime runs the command on the host with the image in the env, recording the container
+type fakeRuntime struct {
+	got exe.Container
+}
+
+func (f *fakeRuntime) Command(c exe.Container, cmd string, args ...string) (string, []string) {
+	f.got = c
+	return "env", append([]string{"IMAGE=" + c.Image, cmd}, args...)
+}
+
+func TestExecImage(t *testing.T) {
+	t.Parallel()
+
+	tmp, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal("can't create tmp dir")
+	}
+	defer os.RemoveAll(tmp)
+	rt := &fakeRuntime{}
+	ws := &Workspace{
+		BasePath:   tmp,
+		Secrets:    secret.NewResolver(secret.Env{"TOK": "s3cret"}),
+		Containers: rt,
+	}
+
+	op := make(chan string, 100)
+	status, _, err := exec{}.Execute(ws, Opts{
+		"cmd":     "printenv IMAGE TOK",
+		"image":   "alpine:3",
+		"sub-dir": "src",
+		"env":     []string{"TOK={{secret.TOK}}"},
+	}, op)
+	close(op)
+	if err != nil || status != 0 {
+		t.Fatal("exec failed", status, err)
+	}
+	out := []string{}
+	for l := range op {
+		out = append(out, l)
+	}
+	if !contains(out, "alpine:3") || !contains(out, "s3cret") {
+		t.Error("did not run in the image", out)
+	}
+	exp := exe.Container{
+		Image:  "alpine:3",
+		Mounts: []string{tmp},
+		Dir:    filepath.Join(tmp, "src"),
+		Env:    []string{"TOK", "FLOEWS"},
+	}
+	if !reflect.DeepEqual(rt.got, exp) {
+		t.Errorf("wrong container %+v", rt.got)
+	}
+
+	// no runtime fails before running anything
+	ws.Containers = nil
+	if _, _, err := (exec{}).Execute(ws, Opts{"cmd": "true", "image": "alpine:3"}, make(chan string, 10)); err == nil {
+		t.Error("no runtime should error")
+	}
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func TestExpandEnvOpts(t *testing.T) {
 	t.Parallel()
 
@@ -154,3 +283,49 @@ func TestExpandEnvOpts(t *testing.T) {
 		}
 	}
 }
+
+func TestExecSandbox(t *testing.T) {
+	t.Parallel()
+
+	tmp, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal("can't create tmp dir")
+	}
+	defer os.RemoveAll(tmp)
+
+	// only the allowed and task vars are in a clean env
+	ws := &Workspace{
+		BasePath: tmp,
+		Sandbox:  exe.Sandbox{Group: true, CleanEnv: true, EnvAllow: []string{"PATH"}},
+	}
+	op := make(chan string, 100)
+	status, _, err := exec{}.Execute(ws, Opts{
+		"cmd": "env",
+		"env": []string{"TASK=task"},
+	}, op)
+	close(op)
+	if err != nil || status != 0 {
+		t.Fatal("exec failed", status, err)
+	}
+	out := []string{}
+	for l := range op {
+		out = append(out, l)
+	}
+	if !contains(out, "TASK=task") || !contains(out, "PATH="+os.Getenv("PATH")) {
+		t.Error("missing env", out)
+	}
+	for _, l := range out {
+		if strings.HasPrefix(l, "HOME=") {
+			t.Error("env was not clean", out)
+		}
+	}
+
+	// a cancelled run kills the command
+	cancel := make(chan struct{})
+	close(cancel)
+	ws.Cancel = cancel
+	status, _, err = exec{}.Execute(ws, Opts{"cmd": "sleep 30"}, make(chan string, 100))
+	if err != nil || status == 0 {
+		t.Error("cancelled exec should fail", status, err)
+	}
+}
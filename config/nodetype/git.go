@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"github.com/floeit/floe/exe"
 	"github.com/floeit/floe/log"
 )
 
@@ -83,7 +84,8 @@ func (g gitCheckout) Execute(ws *Workspace, in Opts, output chan string) (int, O
 	}
 	// git clone --branch mytag0.1 --depth 1 https://example.com/my/repo.git
 	args := []string{"clone", "--branch", gop.Branch, "--depth", "1", gop.URL}
-	status := doRun(filepath.Join(ws.BasePath, gop.SubDir), env, output, "git", args...)
+	// git is run by floe itself, with its key, so it is not sandboxed
+	status := doRun(exe.Sandbox{}, ws.Cancel, filepath.Join(ws.BasePath, gop.SubDir), env, output, "git", args...)
 
 	return status, nil, nil
 }
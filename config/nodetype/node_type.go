@@ -7,13 +7,16 @@ type NType string
 
 // NType reserved node types
 const (
-	NtEnd         NType = "end" // the special end node
-	NtData        NType = "data"
-	NtTimer       NType = "timer"
-	NtExec        NType = "exec"
-	NtFetch       NType = "fetch"
-	NtGitMerge    NType = "git-merge"
-	NtGitCheckout NType = "git-checkout"
+	NtEnd          NType = "end" // the special end node
+	NtData         NType = "data"
+	NtTimer        NType = "timer"
+	NtExec         NType = "exec"
+	NtFetch        NType = "fetch"
+	NtArtifactGet  NType = "artifact-get"
+	NtCacheSave    NType = "cache-save"
+	NtCacheRestore NType = "cache-restore"
+	NtGitMerge     NType = "git-merge"
+	NtGitCheckout  NType = "git-checkout"
 )
 
 // NodeType is the interface for a node. All implementations on NodeType are stateless
@@ -24,12 +27,15 @@ type NodeType interface {
 }
 
 var nts = map[NType]NodeType{
-	NtData:        data{},
-	NtTimer:       timer{},
-	NtExec:        exec{},
-	NtFetch:       fetch{},
-	NtGitMerge:    gitMerge{},
-	NtGitCheckout: gitCheckout{},
+	NtData:         data{},
+	NtTimer:        timer{},
+	NtExec:         exec{},
+	NtFetch:        fetch{},
+	NtArtifactGet:  artifactGet{},
+	NtCacheSave:    cacheSave{},
+	NtCacheRestore: cacheRestore{},
+	NtGitMerge:     gitMerge{},
+	NtGitCheckout:  gitCheckout{},
 }
 
 // GetNodeType returns the node from the given the type and opts
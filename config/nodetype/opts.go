@@ -1,9 +1,39 @@
 package nodetype
 
+import (
+	"io"
+
+	"github.com/floeit/floe/artifact"
+	"github.com/floeit/floe/cache"
+	"github.com/floeit/floe/exe"
+	"github.com/floeit/floe/secret"
+)
+
 // Workspace is anything specific to a workspace for a single run or any locations common between runs
 type Workspace struct {
 	BasePath   string // The root path for this workspace
 	FetchCache string // The host level cache of downloaded files (not per workspace, but handy to have listed in this struct)
+
+	Secrets *secret.Resolver // expands any {{secret.NAME}} in exec env vars, and masks their values
+
+	Artifacts ArtifactSource // finds the artifacts kept by earlier nodes, wherever they ran
+	Cache     *cache.Store   // The host level cache of workspace directories, such as dependencies
+	Flow      string         // the id of the flow of the run, the cache entries are kept per flow
+
+	Containers exe.Runtime // runs the exec commands that have an image in a container
+
+	Sandbox exe.Sandbox     // the guardrails around the exec commands run on the host
+	Cancel  <-chan struct{} // closed when the run ends so any commands still running are killed
+}
+
+// ArtifactSource finds and opens the artifacts kept by the nodes of this run or of other flows.
+// An empty flowID means the flow of this run.
+type ArtifactSource interface {
+	// Find returns the id of the run and the artifacts the node kept in it, from this run if
+	// flowID is empty, otherwise from the latest good run of the flow.
+	Find(flowID, nodeID string) (string, []artifact.File, error)
+	// Open opens the named artifact from whichever host has it
+	Open(flowID, runID, nodeID, name string) (io.ReadCloser, error)
 }
 
 // Opts are the options on the node type that will be compared to those on the event
@@ -8,8 +8,12 @@ import (
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/metrics"
 )
 
+var published = metrics.NewCounter("floe_events_published_total",
+	"Events published to the queue, by the tag prefix e.g. sys or task.", "prefix")
+
 const sysPrefix = "sys." // all internal events that nodes can not see
 
 // HostedIDRef is any ID unique within the scope of the host that created it.
@@ -96,6 +100,9 @@ type Event struct {
 
 	// Opts - some optional data in the event
 	Opts nt.Opts
+
+	// Actor is the authenticated user that caused this event, if any
+	Actor string
 }
 
 // copy makes a copy without sharing the underlying Opts aps.
@@ -158,6 +165,7 @@ func (q *Queue) Publish(e Event) {
 	}
 	log.Debugf("<%s-ev:%d> - queue publish type:<%s>%s from: %s", e.RunRef, e.ID, e.Tag, isTrig, e.SourceNode)
 	// }
+	published.Inc(strings.SplitN(e.Tag, ".", 2)[0])
 
 	// and notify all observers - in background goroutines
 	for _, o := range q.observers {
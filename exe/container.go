@@ -0,0 +1,81 @@
+package exe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Container describes the container a command is run in
+type Container struct {
+	Image  string   // the image to run
+	Mounts []string // host directories bind-mounted at the same path in the container
+	Dir    string   // the working directory in the container
+	Env    []string // the names of the env vars passed through from the env of the runtime command
+	UID    uint32   // the user to run as when not 0, otherwise the user floe runs as
+	GID    uint32   // the group to run as when not 0, otherwise the group floe runs as
+}
+
+// Runtime wraps a command so that it runs in a container
+type Runtime interface {
+	// Command returns the command and args that run cmd with args in the container c. The
+	// values of the env vars named in c are taken from the env the returned command is run with,
+	// so that they, which may be secret, are not in its args.
+	Command(c Container, cmd string, args ...string) (string, []string)
+}
+
+// CLI is a Runtime using a docker compatible command line tool such as docker or podman
+type CLI struct {
+	Path string
+}
+
+// NewCLI returns a CLI for the named tool, if name is empty then docker or podman, whichever is
+// found first.
+func NewCLI(name string) CLI {
+	if name != "" {
+		return CLI{Path: name}
+	}
+	for _, n := range []string{"docker", "podman"} {
+		if p, err := exec.LookPath(n); err == nil {
+			return CLI{Path: p}
+		}
+	}
+	return CLI{Path: "docker"} // fails when run, saying it can not be found
+}
+
+// Command returns the cli run command, the container is removed when the command ends and runs
+// as the user of the container if given, else as the current user, so that the files it writes in
+// the workspace belong to the user the workspace was given to.
+func (c CLI) Command(ct Container, cmd string, args ...string) (string, []string) {
+	a := []string{"run", "--rm", "--init"}
+	switch uid := os.Getuid(); {
+	case ct.UID != 0 || ct.GID != 0:
+		u, g := ct.UID, ct.GID
+		if u == 0 {
+			u = uint32(uid)
+		}
+		if g == 0 {
+			g = uint32(os.Getgid())
+		}
+		a = append(a, "--user", fmt.Sprintf("%d:%d", u, g))
+	case uid > 0:
+		if strings.HasPrefix(filepath.Base(c.Path), "podman") {
+			a = append(a, "--userns=keep-id")
+		} else {
+			a = append(a, "--user", fmt.Sprintf("%d:%d", uid, os.Getgid()))
+		}
+	}
+	for _, m := range ct.Mounts {
+		a = append(a, "-v", m+":"+m)
+	}
+	if ct.Dir != "" {
+		a = append(a, "-w", ct.Dir)
+	}
+	for _, e := range ct.Env {
+		a = append(a, "-e", e)
+	}
+	a = append(a, ct.Image, cmd)
+	return c.Path, append(a, args...)
+}
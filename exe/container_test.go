@@ -0,0 +1,46 @@
+package exe
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCLICommand(t *testing.T) {
+	t.Parallel()
+
+	ct := Container{
+		Image:  "golang:1.21",
+		Mounts: []string{"/ws/h1-5"},
+		Dir:    "/ws/h1-5/src",
+		Env:    []string{"TOKEN", "FLOEWS"},
+	}
+	// the user only changes when not run as root
+	user := map[string][]string{"docker": nil, "podman": nil}
+	if uid := os.Getuid(); uid > 0 {
+		user["docker"] = []string{"--user", fmt.Sprintf("%d:%d", uid, os.Getgid())}
+		user["podman"] = []string{"--userns=keep-id"}
+	}
+
+	for _, cli := range []string{"docker", "podman"} {
+		cmd, args := NewCLI(cli).Command(ct, "go", "test", "./...")
+		if cmd != cli {
+			t.Error("wrong cli", cmd)
+		}
+		exp := append([]string{"run", "--rm", "--init"}, user[cli]...)
+		exp = append(exp, "-v", "/ws/h1-5:/ws/h1-5", "-w", "/ws/h1-5/src",
+			"-e", "TOKEN", "-e", "FLOEWS", "golang:1.21", "go", "test", "./...")
+		if !reflect.DeepEqual(args, exp) {
+			t.Errorf("%s wrong args\n%v\n%v", cli, args, exp)
+		}
+
+		// the sandbox user is used whoever floe runs as
+		sb := ct
+		sb.UID, sb.GID = 1500, 1600
+		_, args = NewCLI(cli).Command(sb, "go", "test")
+		if args[3] != "--user" || args[4] != "1500:1600" {
+			t.Errorf("%s wrong sandbox user %v", cli, args)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package exe
 
 import (
 	"bufio"
-	"io"
+	"fmt"
 	"os"
 	"os/exec"
 	"strings"
@@ -38,6 +38,12 @@ func RunOutput(log logger, env []string, wd, cmd string, args ...string) ([]stri
 
 // Run executes the command in a bash process
 func Run(log logger, out chan string, env []string, wd, cmd string, args ...string) int {
+	return RunSandboxed(log, out, Sandbox{}, nil, env, wd, cmd, args...)
+}
+
+// RunSandboxed executes the command within the sandbox, the command is killed if cancel is closed
+// before it ends.
+func RunSandboxed(log logger, out chan string, sb Sandbox, cancel <-chan struct{}, env []string, wd, cmd string, args ...string) int {
 
 	log.Info("Exec Cmd:", cmd, "Args:", args)
 
@@ -49,58 +55,107 @@ func Run(log logger, out chan string, env []string, wd, cmd string, args ...stri
 		}
 	}
 
-	eCmd := exec.Command(cmd, args...)
+	// any resource limits are set by a wrapper that then execs the command
+	wCmd, wArgs := sb.wrap(cmd, args)
+	eCmd := exec.Command(wCmd, wArgs...)
 
-	eCmd.Env = os.Environ()
+	eCmd.Env = sb.environ()
 	eCmd.Env = append(eCmd.Env, env...)
 
 	// this is mandatory
 	eCmd.Dir = wd
 	log.Info("In working directory:", eCmd.Dir)
-	log.Info("Env vars:", eCmd.Env)
+	log.Debug("Env var names:", envNames(env))
 
 	out <- cmd + " " + strings.Join(args, " ")
 	out <- ""
 
-	// safely aggregate both to a single reader
-	pr, pw := io.Pipe()
+	fail := func(err error) int {
+		log.Error("start failed", err)
+		out <- err.Error()
+		out <- ""
+		close(out)
+		return 1
+	}
+
+	if err := sb.setup(eCmd); err != nil {
+		return fail(err)
+	}
+
+	// aggregate both to a single os pipe, so that waiting for the command does not also wait
+	// for any processes it left running that still hold the pipe
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return fail(err)
+	}
 	eCmd.Stdout = pw
 	eCmd.Stderr = pw
 
 	// start scanning from the common pipe
+	overflow := make(chan struct{})
 	scanDone := make(chan bool)
 	go func() {
+		var size int64
 		scanner := bufio.NewScanner(pr)
 		for scanner.Scan() {
+			size += int64(len(scanner.Bytes()) + 1)
+			if sb.MaxOutput > 0 && size > sb.MaxOutput {
+				// keep draining the pipe until the command is killed
+				select {
+				case <-overflow:
+				default:
+					out <- fmt.Sprintf("output exceeded %d bytes, killing the command", sb.MaxOutput)
+					close(overflow)
+				}
+				continue
+			}
 			out <- scanner.Text()
 		}
 		if e := scanner.Err(); e != nil {
 			out <- "scanning output failed with: " + e.Error()
 		}
+		pr.Close()
 		scanDone <- true
 	}()
 
 	log.Debug("Exec starting")
-	err := eCmd.Start()
+	err = eCmd.Start()
+	// the command has its own copy of the writer
+	pw.Close()
 	if err != nil {
-		log.Error("start failed", err)
-		out <- err.Error()
-		out <- ""
-		close(out)
-		return 1
+		<-scanDone
+		return fail(err)
 	}
 
+	// kill the command if it is cancelled or too noisy
+	cancelled := false
+	done := make(chan struct{})
+	watchDone := make(chan struct{})
+	go func() {
+		defer close(watchDone)
+		select {
+		case <-cancel:
+			cancelled = true
+			sb.kill(eCmd.Process)
+		case <-overflow:
+			sb.kill(eCmd.Process)
+		case <-done:
+		}
+	}()
+
 	log.Debug("Exec waiting")
 	err = eCmd.Wait()
+	close(done)
+	<-watchDone
 
-	// close the writer pipe
-	e := pw.Close()
-	if e != nil {
-		panic("not sure how this particular close could error" + err.Error())
-	}
+	// anything the command left running in its group goes with it
+	sb.cleanUp(eCmd.Process.Pid)
 
 	// wait to be sure scanner is fully complete
 	<-scanDone
+	if cancelled {
+		out <- "cancelled"
+	}
 	close(out)
 
 	log.Debug("exec cmd complete")
@@ -109,7 +164,7 @@ func Run(log logger, out chan string, env []string, wd, cmd string, args ...stri
 		log.Error("Command failed:", err)
 		exitCode := 1
 		if msg, ok := err.(*exec.ExitError); ok {
-			if status, ok := msg.Sys().(syscall.WaitStatus); ok {
+			if status, ok := msg.Sys().(syscall.WaitStatus); ok && status.Exited() {
 				exitCode = status.ExitStatus()
 				log.Info("exit status: ", exitCode)
 			}
@@ -121,3 +176,12 @@ func Run(log logger, out chan string, env []string, wd, cmd string, args ...stri
 	log.Info("Executing command succeeded")
 	return 0
 }
+
+// envNames returns only the names of the env vars, their values may be secret
+func envNames(env []string) []string {
+	names := make([]string, len(env))
+	for i, e := range env {
+		names[i] = strings.SplitN(e, "=", 2)[0]
+	}
+	return names
+}
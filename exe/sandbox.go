@@ -0,0 +1,57 @@
+package exe
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Sandbox are the guardrails put around a command run directly on the host, and around
+// every process the command starts.
+type Sandbox struct {
+	// Group runs the command in its own session and process group so every process it
+	// starts is killed when it ends or is cancelled, not only the command itself.
+	Group bool
+
+	// the resource limits of each process, 0 is unlimited, they are only supported on linux
+	CPUSeconds  uint64 // RLIMIT_CPU
+	MemoryBytes uint64 // RLIMIT_AS
+	OpenFiles   uint64 // RLIMIT_NOFILE
+
+	// MaxOutput is the bytes of output after which the command is killed, 0 is unlimited
+	MaxOutput int64
+
+	// UID and GID are the user and group to run as when not 0, changing them needs root
+	UID uint32
+	GID uint32
+
+	// CleanEnv starts the command with only the EnvAllow vars from the floe environment
+	// rather than all of it
+	CleanEnv bool
+	EnvAllow []string
+}
+
+func (s Sandbox) limited() bool {
+	return s.CPUSeconds > 0 || s.MemoryBytes > 0 || s.OpenFiles > 0
+}
+
+// environ returns the environment the command starts from before its own vars are added
+func (s Sandbox) environ() []string {
+	if !s.CleanEnv {
+		return os.Environ()
+	}
+	env := []string{}
+	for _, name := range s.EnvAllow {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// setup applies the sandbox to the command before it is started
+func (s Sandbox) setup(c *exec.Cmd) error {
+	if err := s.canLimit(); err != nil {
+		return err
+	}
+	return s.setProcAttr(c)
+}
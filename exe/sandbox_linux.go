@@ -0,0 +1,48 @@
+package exe
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+func (s Sandbox) canLimit() error {
+	if !s.limited() {
+		return nil
+	}
+	if _, err := exec.LookPath("prlimit"); err != nil {
+		return errors.New("resource limits need prlimit from util-linux")
+	}
+	return nil
+}
+
+// wrap returns the command that runs cmd under prlimit, which sets the resource limits then execs
+// cmd, so the command has them from its first instruction and every process it starts inherits them.
+func (s Sandbox) wrap(cmd string, args []string) (string, []string) {
+	if !s.limited() {
+		return cmd, args
+	}
+	// prlimit looks cmd up on the path of the command env, which may not have the floe path
+	if filepath.Base(cmd) == cmd {
+		if p, err := exec.LookPath(cmd); err == nil {
+			cmd = p
+		}
+	}
+	a := []string{}
+	limits := []struct {
+		flag string
+		max  uint64
+	}{
+		{"--cpu=", s.CPUSeconds},
+		{"--as=", s.MemoryBytes},
+		{"--nofile=", s.OpenFiles},
+	}
+	for _, l := range limits {
+		if l.max > 0 {
+			a = append(a, l.flag+strconv.FormatUint(l.max, 10))
+		}
+	}
+	a = append(a, "--", cmd)
+	return "prlimit", append(a, args...)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package exe
+
+import "errors"
+
+func (s Sandbox) canLimit() error {
+	if s.limited() {
+		return errors.New("resource limits are only supported on linux")
+	}
+	return nil
+}
+
+func (s Sandbox) wrap(cmd string, args []string) (string, []string) {
+	return cmd, args
+}
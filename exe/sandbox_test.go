@@ -0,0 +1,126 @@
+package exe
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runSandboxed(t *testing.T, sb Sandbox, cancel <-chan struct{}, env []string, cmd string, args ...string) ([]string, int) {
+	var output []string
+	out := make(chan string)
+	rangeDone := make(chan bool)
+	go func() {
+		for o := range out {
+			output = append(output, o)
+		}
+		rangeDone <- true
+	}()
+	status := RunSandboxed(&tLog{t: t}, out, sb, cancel, env, "", cmd, args...)
+	<-rangeDone
+	// drop the echoed command and blank line
+	return output[2:], status
+}
+
+func TestRunSandboxed(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("no process groups on windows")
+	}
+
+	fixtures := []struct {
+		name   string
+		sb     Sandbox
+		env    []string
+		cmd    string
+		linux  bool          // limits are only on linux
+		within time.Duration // the command must end within this
+		status int
+		out    []string // the exact output, if given
+		maxOut int      // the most lines of output
+	}{
+		{
+			name:   "clean env",
+			sb:     Sandbox{CleanEnv: true, EnvAllow: []string{"PATH", "NOT_IN_THE_ENV"}},
+			env:    []string{"TASK=1"},
+			cmd:    `env | grep -v '^_=\|^PWD=\|^SHLVL=' | cut -d= -f1 | sort`,
+			within: 10 * time.Second,
+			out:    []string{"PATH", "TASK"},
+		},
+		{
+			name:   "group killed when the command ends",
+			sb:     Sandbox{Group: true},
+			cmd:    `sleep 30 & echo started`,
+			within: 10 * time.Second,
+			out:    []string{"started"},
+		},
+		{
+			name:   "output limit",
+			sb:     Sandbox{Group: true, MaxOutput: 1000},
+			cmd:    `yes`,
+			within: 10 * time.Second,
+			status: 1,
+			maxOut: 600,
+		},
+		{
+			name:   "open files limit",
+			sb:     Sandbox{OpenFiles: 16},
+			cmd:    `ulimit -n`, // from the start, not once it has been running a while
+			linux:  true,
+			within: 10 * time.Second,
+			out:    []string{"16"},
+		},
+		{
+			name:   "all limits",
+			sb:     Sandbox{CPUSeconds: 60, MemoryBytes: 1 << 30, OpenFiles: 32},
+			cmd:    `ulimit -t; ulimit -v; ulimit -n`,
+			linux:  true,
+			within: 10 * time.Second,
+			out:    []string{"60", "1048576", "32"},
+		},
+	}
+
+	for _, f := range fixtures {
+		if f.linux && runtime.GOOS != "linux" {
+			continue
+		}
+		start := time.Now()
+		out, status := runSandboxed(t, f.sb, nil, f.env, "sh", "-c", f.cmd)
+		if d := time.Since(start); d > f.within {
+			t.Errorf("%s took %v", f.name, d)
+		}
+		if status != f.status {
+			t.Errorf("%s got status %d, wanted %d", f.name, status, f.status)
+		}
+		if f.out != nil && strings.Join(out, ",") != strings.Join(f.out, ",") {
+			t.Errorf("%s got output %v, wanted %v", f.name, out, f.out)
+		}
+		if f.maxOut > 0 && len(out) > f.maxOut {
+			t.Errorf("%s got %d lines of output", f.name, len(out))
+		}
+	}
+}
+
+func TestRunSandboxedCancel(t *testing.T) {
+	t.Parallel()
+
+	cancel := make(chan struct{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		close(cancel)
+	}()
+
+	start := time.Now()
+	out, status := runSandboxed(t, Sandbox{}, cancel, nil, "sleep", "30")
+	if d := time.Since(start); d > 10*time.Second {
+		t.Error("cancel did not kill the command", d)
+	}
+	if status == 0 {
+		t.Error("a cancelled command should fail")
+	}
+	if len(out) != 1 || out[0] != "cancelled" {
+		t.Error("bad output", out)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+// +build !windows
+
+package exe
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func (s Sandbox) setProcAttr(c *exec.Cmd) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: s.Group}
+	if s.UID == 0 && s.GID == 0 {
+		return nil
+	}
+	cred := &syscall.Credential{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+	if s.UID != 0 {
+		cred.Uid = s.UID
+	}
+	if s.GID != 0 {
+		cred.Gid = s.GID
+	}
+	c.SysProcAttr.Credential = cred
+	return nil
+}
+
+// kill kills the process and if it leads a group all the other processes in it
+func (s Sandbox) kill(p *os.Process) {
+	if s.Group {
+		syscall.Kill(-p.Pid, syscall.SIGKILL)
+		return
+	}
+	p.Kill()
+}
+
+// cleanUp kills any processes left in the group once the command has ended
+func (s Sandbox) cleanUp(pid int) {
+	if s.Group {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,20 @@
+package exe
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func (s Sandbox) setProcAttr(c *exec.Cmd) error {
+	if s.Group || s.UID != 0 || s.GID != 0 {
+		return errors.New("process groups and users are not supported on windows")
+	}
+	return nil
+}
+
+func (s Sandbox) kill(p *os.Process) {
+	p.Kill()
+}
+
+func (s Sandbox) cleanUp(pid int) {}
@@ -0,0 +1,149 @@
+package hub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/floeit/floe/config"
+)
+
+// Discoverer finds the base addresses of the hosts in the cluster e.g. http://10.0.0.5:8080
+type Discoverer interface {
+	Hosts() ([]string, error)
+}
+
+// newDiscoverer returns the discoverer configured in c, and how often it should be refreshed.
+// A zero period means the host list will never change.
+func newDiscoverer(c config.Discovery, static []string) (Discoverer, time.Duration, error) {
+	period := time.Duration(c.Period) * time.Second
+	if period == 0 {
+		period = 10 * time.Second
+	}
+	scheme := c.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	switch c.Type {
+	case "", "static":
+		return staticHosts(static), 0, nil
+	case "dns":
+		if c.Name == "" {
+			return nil, 0, fmt.Errorf("dns discovery needs a name")
+		}
+		return &dnsHosts{
+			name:       c.Name,
+			port:       c.Port,
+			scheme:     scheme,
+			lookupSRV:  net.LookupSRV,
+			lookupHost: net.LookupHost,
+		}, period, nil
+	case "file":
+		if c.File == "" {
+			return nil, 0, fmt.Errorf("file discovery needs a file")
+		}
+		return &fileHosts{path: c.File}, period, nil
+	}
+	return nil, 0, fmt.Errorf("unknown discovery type: %s", c.Type)
+}
+
+// staticHosts is the fixed list of hosts from the config
+type staticHosts []string
+
+func (s staticHosts) Hosts() ([]string, error) {
+	return s, nil
+}
+
+// dnsHosts finds hosts from SRV or A records
+type dnsHosts struct {
+	name   string
+	port   int
+	scheme string
+
+	lookupSRV  func(service, proto, name string) (string, []*net.SRV, error)
+	lookupHost func(host string) ([]string, error)
+}
+
+func (d *dnsHosts) Hosts() ([]string, error) {
+	var hosts []string
+	if strings.HasPrefix(d.name, "_") {
+		_, srvs, err := d.lookupSRV("", "", d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range srvs {
+			target := strings.TrimSuffix(s.Target, ".")
+			hosts = append(hosts, d.addr(target, int(s.Port)))
+		}
+	} else {
+		addrs, err := d.lookupHost(d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			hosts = append(hosts, d.addr(a, d.port))
+		}
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+func (d *dnsHosts) addr(host string, port int) string {
+	if port == 0 {
+		return d.scheme + "://" + host
+	}
+	return d.scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// fileHosts reads hosts from a file, one per line. Blank lines and lines starting with # are
+// ignored. The file is only re-read when its modification time or size changes.
+type fileHosts struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	size    int64
+	hosts   []string
+}
+
+func (f *fileHosts) Hosts() ([]string, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size && f.hosts != nil {
+		return f.hosts, nil
+	}
+
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		hosts = append(hosts, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	f.modTime = fi.ModTime()
+	f.size = fi.Size()
+	f.hosts = hosts
+	return hosts, nil
+}
@@ -0,0 +1,133 @@
+package hub
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/client"
+	"github.com/floeit/floe/config"
+)
+
+func TestDNSHosts(t *testing.T) {
+	t.Parallel()
+
+	d := &dnsHosts{
+		port:   8080,
+		scheme: "http",
+		lookupSRV: func(service, proto, name string) (string, []*net.SRV, error) {
+			return "", []*net.SRV{
+				{Target: "h2.floe.local.", Port: 9090},
+				{Target: "h1.floe.local.", Port: 9090},
+			}, nil
+		},
+		lookupHost: func(host string) ([]string, error) {
+			if host != "floe.local" {
+				return nil, errors.New("no such host")
+			}
+			return []string{"10.0.0.2", "10.0.0.1"}, nil
+		},
+	}
+
+	d.name = "_floe._tcp.floe.local"
+	hosts, err := d.Hosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"http://h1.floe.local:9090", "http://h2.floe.local:9090"}
+	if !reflect.DeepEqual(hosts, exp) {
+		t.Errorf("srv hosts wrong, expected %v got %v", exp, hosts)
+	}
+
+	d.name = "floe.local"
+	hosts, err = d.Hosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"}
+	if !reflect.DeepEqual(hosts, exp) {
+		t.Errorf("a hosts wrong, expected %v got %v", exp, hosts)
+	}
+
+	d.name = "nope.local"
+	if _, err = d.Hosts(); err == nil {
+		t.Error("lookup failure should error")
+	}
+}
+
+func TestFileHosts(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "hosts")
+
+	d, _, err := newDiscoverer(config.Discovery{Type: "file", File: p}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Hosts(); err == nil {
+		t.Error("missing file should error")
+	}
+
+	ioutil.WriteFile(p, []byte("# cluster\nhttp://h1:8080\n\n  http://h2:8080  \n"), 0644)
+	hosts, err := d.Hosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"http://h1:8080", "http://h2:8080"}
+	if !reflect.DeepEqual(hosts, exp) {
+		t.Errorf("file hosts wrong, expected %v got %v", exp, hosts)
+	}
+
+	// make sure the change is seen even if the mod time resolution is coarse
+	ioutil.WriteFile(p, []byte("http://h3:8080\n"), 0644)
+	os.Chtimes(p, time.Now(), time.Now().Add(time.Second))
+	hosts, _ = d.Hosts()
+	if !reflect.DeepEqual(hosts, []string{"http://h3:8080"}) {
+		t.Errorf("file hosts not reloaded, got %v", hosts)
+	}
+}
+
+func TestSyncHosts(t *testing.T) {
+	t.Parallel()
+
+	h := &Hub{
+		hostID: "h1",
+		hosts:  map[string]*client.FloeHost{},
+	}
+	// use unroutable addresses the pingers will never reach
+	added, removed := h.syncHosts([]string{"http://127.0.0.1:1", "http://127.0.0.1:2"})
+	if len(added) != 2 || len(removed) != 0 {
+		t.Errorf("expected 2 added got %v and %v", added, removed)
+	}
+	added, removed = h.syncHosts([]string{"http://127.0.0.1:2", "http://127.0.0.1:3"})
+	if !reflect.DeepEqual(added, []string{"http://127.0.0.1:3"}) {
+		t.Errorf("wrong hosts added %v", added)
+	}
+	if !reflect.DeepEqual(removed, []string{"http://127.0.0.1:1"}) {
+		t.Errorf("wrong hosts removed %v", removed)
+	}
+	if len(h.hostList()) != 2 {
+		t.Errorf("expected 2 hosts got %d", len(h.hostList()))
+	}
+	for _, host := range h.hostList() {
+		host.Stop()
+	}
+
+	// a host with our own id is not a peer
+	if !h.isSelf(client.HostConfig{HostID: "h1"}) {
+		t.Error("h1 should be self")
+	}
+	if len(h.Peers()) != 0 {
+		t.Error("unreached hosts should not be peers")
+	}
+}
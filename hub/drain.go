@@ -0,0 +1,101 @@
+package hub
+
+import (
+	"sync"
+	"time"
+
+	"github.com/floeit/floe/config"
+	nt "github.com/floeit/floe/config/nodetype"
+	"github.com/floeit/floe/log"
+)
+
+// drainCheck is how often the drain checks if the pends and runs are gone
+const drainCheck = time.Second
+
+// drain is the state of the host being drained before it shuts down
+type drain struct {
+	sync.Mutex
+	started bool
+	done    chan struct{} // closed when the drain is complete
+}
+
+// Draining returns true once the host has started draining
+func (h *Hub) Draining() bool {
+	h.drain.Lock()
+	defer h.drain.Unlock()
+	return h.drain.started
+}
+
+// Drained returns a channel that is closed once the host has drained
+func (h *Hub) Drained() <-chan struct{} {
+	h.drain.Lock()
+	defer h.drain.Unlock()
+	return h.drain.doneChan()
+}
+
+// doneChan returns the done channel making it if needed, the lock must be held
+func (d *drain) doneChan() chan struct{} {
+	if d.done == nil {
+		d.done = make(chan struct{})
+	}
+	return d.done
+}
+
+// Drain stops this host accepting new runs, so that its peers are given its pending runs, and
+// waits up to the drain grace period for its active runs to end. Any runs still active are
+// then ended as bad and the run lists are saved. It returns once the host is drained, and
+// can be called any number of times.
+func (h *Hub) Drain() {
+	h.drain.Lock()
+	done := h.drain.doneChan()
+	started := h.drain.started
+	h.drain.started = true
+	h.drain.Unlock()
+	if started {
+		<-done
+		return
+	}
+
+	grace := time.Duration(h.Config().Common.DrainGrace) * time.Second
+	log.Infof("draining, waiting up to %s for pending runs to be taken and active runs to end", grace)
+
+	// the pends are offered to the peers as usual but this host refuses them, so wait for
+	// them to go while there is anyone to take them
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		pends := len(h.runs.allPends())
+		if pends > 0 && !h.anyPeerOnline() {
+			pends = 0 // they are kept in the store for later
+		}
+		if pends == 0 && len(h.runs.activeRefs()) == 0 {
+			break
+		}
+		time.Sleep(drainCheck)
+	}
+
+	for _, ref := range h.runs.activeRefs() {
+		_, run := h.runs.findActiveRun(ref)
+		if run == nil {
+			continue
+		}
+		log.Infof("<%s> - drain - ending run still active after %s", ref, grace)
+		h.endRun(run, config.NodeRef{}, nt.Opts{"drained-by": h.hostID}, false, "")
+	}
+
+	if err := h.runs.save(); err != nil {
+		log.Error("could not save the runs after draining", err)
+	}
+	log.Info("drained")
+	close(done)
+}
+
+// anyPeerOnline returns true if any other host is ready to take runs
+func (h *Hub) anyPeerOnline() bool {
+	for _, host := range h.hostList() {
+		c := host.GetConfig()
+		if !h.isSelf(c) && c.Online {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/store"
+)
+
+func TestDrain(t *testing.T) {
+	t.Parallel()
+
+	h := &Hub{
+		hostID: "h1",
+		queue:  &event.Queue{},
+		runs:   newRunStore(store.NewMemStore()),
+	}
+	h.config.Common.DrainGrace = 1
+	flow := &config.Flow{ID: "flow", Ver: 1}
+
+	// a run that is still active after the grace period
+	ref, err := h.runs.addToPending(flow, "h1", config.NodeRef{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pend, _ := h.runs.findPend("flow", ref.Run.String())
+	h.runs.removePend(pend)
+	if err := h.runs.activate(&pend, "h1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a pend with no peers to take it is left pending
+	ref2, _ := h.runs.addToPending(flow, "h1", config.NodeRef{}, nil)
+
+	h.Drain()
+
+	select {
+	case <-h.Drained():
+	default:
+		t.Error("drained should be closed")
+	}
+	if !h.Draining() {
+		t.Error("host should be draining")
+	}
+	if c := h.checkDrain(); c.OK {
+		t.Error("a draining host should not be ready")
+	}
+
+	run := h.FindRun("flow", ref.Run.String())
+	if run == nil || !run.Ended || run.Good {
+		t.Errorf("run should have been ended bad %+v", run)
+	}
+	if len(h.runs.allPends()) != 1 {
+		t.Error("pend should have been kept")
+	}
+
+	// no more runs are taken
+	pend2, _ := h.runs.findPend("flow", ref2.Run.String())
+	ok, err := h.ExecutePending(pend2)
+	if ok || err != nil {
+		t.Error("draining host should not execute a pend", ok, err)
+	}
+
+	// draining again returns straight away
+	h.Drain()
+}
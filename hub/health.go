@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	healthKey     = "health"         // the store key saved to check the store is writable
+	storeCheckAge = time.Second * 10 // how long a store check is reused, as every save may cost
+	timerStall    = time.Second * 5  // the timer loop is stuck if it has not run for this long
+)
+
+// Check is the outcome of one of the readiness checks
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Readiness is the outcome of all the readiness checks, the host is only ready to accept new
+// runs if they are all ok
+type Readiness struct {
+	Ready  bool
+	Checks []Check
+}
+
+// storeCheck keeps the last outcome of saving to the store
+type storeCheck struct {
+	sync.Mutex
+	at  time.Time
+	err error
+}
+
+// Ready checks the subsystems the host needs to execute runs
+func (h *Hub) Ready() Readiness {
+	r := Readiness{
+		Ready: true,
+		Checks: []Check{
+			h.checkStore(),
+			h.checkWorkspace(),
+			h.checkTimers(),
+			h.checkPeers(),
+			h.checkDrain(),
+		},
+	}
+	for _, c := range r.Checks {
+		if !c.OK {
+			r.Ready = false
+		}
+	}
+	return r
+}
+
+func (h *Hub) checkStore() Check {
+	h.storeCheck.Lock()
+	defer h.storeCheck.Unlock()
+	if time.Since(h.storeCheck.at) > storeCheckAge {
+		h.storeCheck.err = h.runs.store.Save(healthKey, time.Now().UTC())
+		h.storeCheck.at = time.Now()
+	}
+	c := Check{Name: "store", OK: h.storeCheck.err == nil, Detail: "writable"}
+	if h.storeCheck.err != nil {
+		c.Detail = h.storeCheck.err.Error()
+	}
+	return c
+}
+
+func (h *Hub) checkWorkspace() Check {
+	c := Check{Name: "workspace"}
+	f, err := ioutil.TempFile(h.Config().Common.WorkspaceRoot, ".ready")
+	if err != nil {
+		c.Detail = "not writable: " + err.Error()
+		return c
+	}
+	f.Close()
+	os.Remove(f.Name())
+
+	c.OK = true
+	free := h.FreeDisk()
+	c.Detail = fmt.Sprintf("%d MB free", free>>20)
+	if min := h.Config().Common.Health.MinFreeMB; min > 0 && free < uint64(min)<<20 {
+		c.OK = false
+		c.Detail += fmt.Sprintf(", needs %d MB", min)
+	}
+	return c
+}
+
+func (h *Hub) checkTimers() Check {
+	c := Check{Name: "timers", OK: true}
+	if h.timers == nil {
+		return c
+	}
+	since := time.Since(h.timers.alive())
+	c.OK = since < timerStall
+	c.Detail = fmt.Sprintf("last ran %s ago", since.Round(time.Millisecond))
+	return c
+}
+
+// checkPeers reports how many of the other hosts can be reached, but this host can still run
+// things if none of them can.
+func (h *Hub) checkPeers() Check {
+	peers, reachable := 0, 0
+	for _, host := range h.hostList() {
+		c := host.GetConfig()
+		if h.isSelf(c) {
+			continue
+		}
+		peers++
+		if c.Reachable {
+			reachable++
+		}
+	}
+	return Check{Name: "peers", OK: true, Detail: fmt.Sprintf("%d of %d reachable", reachable, peers)}
+}
+
+func (h *Hub) checkDrain() Check {
+	if h.Draining() {
+		return Check{Name: "drain", Detail: "draining"}
+	}
+	return Check{Name: "drain", OK: true, Detail: "accepting runs"}
+}
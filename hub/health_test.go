@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/store"
+)
+
+func TestReady(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "floe-ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	h := &Hub{
+		runs:   newRunStore(store.NewMemStore()),
+		timers: &timers{lastTick: time.Now().UnixNano()},
+	}
+	h.config.Common.WorkspaceRoot = root
+
+	fixtures := []struct {
+		name    string
+		change  func()
+		ready   bool
+		failing string
+	}{
+		{name: "all good", change: func() {}, ready: true},
+		{
+			name:    "out of disk",
+			change:  func() { h.config.Common.Health.MinFreeMB = 1 << 40 },
+			failing: "workspace",
+		},
+		{
+			name: "workspace not writable",
+			change: func() {
+				h.config.Common.Health.MinFreeMB = 0
+				h.config.Common.WorkspaceRoot = filepath.Join(root, "missing")
+			},
+			failing: "workspace",
+		},
+		{
+			name: "timer loop stuck",
+			change: func() {
+				h.config.Common.WorkspaceRoot = root
+				h.timers.lastTick = time.Now().Add(-time.Minute).UnixNano()
+			},
+			failing: "timers",
+		},
+	}
+	for _, f := range fixtures {
+		f.change()
+		r := h.Ready()
+		if r.Ready != f.ready {
+			t.Errorf("%s: got ready %v", f.name, r.Ready)
+		}
+		for _, c := range r.Checks {
+			if !c.OK && c.Name != f.failing {
+				t.Errorf("%s: check %s failed: %s", f.name, c.Name, c.Detail)
+			}
+			if c.OK && c.Name == f.failing {
+				t.Errorf("%s: check %s should have failed", f.name, c.Name)
+			}
+		}
+	}
+
+	// the store check saved to the store
+	var at time.Time
+	if err := h.runs.store.Load(healthKey, &at); err != nil || at.IsZero() {
+		t.Error("store check did not save", err)
+	}
+}
@@ -0,0 +1,136 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/floeit/floe/artifact"
+	"github.com/floeit/floe/client"
+	nt "github.com/floeit/floe/config/nodetype"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/log"
+)
+
+// artifactNode is implemented by nodes that can keep files from their workspace
+type artifactNode interface {
+	ArtifactPatterns() []string
+}
+
+// keepArtifacts copies the files matching the artifact patterns of the node from the workspace into
+// the artifact store, noting what it kept in the node log.
+func (h *Hub) keepArtifacts(run *Run, node exeNode, ws *nt.Workspace, logKey string) ([]artifact.File, error) {
+	an, ok := node.(artifactNode)
+	if !ok || ws == nil {
+		return nil, nil
+	}
+	patterns := an.ArtifactPatterns()
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	note := func(msg string) {
+		if _, err := h.logs.Append(logKey, msg); err != nil {
+			log.Errorf("<%s> - exec node (%s) - could not append log: %v", run.Ref, node.NodeRef(), err)
+		}
+	}
+
+	files, unmatched, err := h.artifacts.Collect(run.Ref.FlowRef.ID, run.Ref.Run.String(), node.NodeRef().ID, ws.BasePath, patterns)
+	if err != nil {
+		log.Errorf("<%s> - exec node (%s) - could not keep artifacts: %v", run.Ref, node.NodeRef(), err)
+		note("could not keep artifacts: " + err.Error())
+		return nil, err
+	}
+	for _, p := range unmatched {
+		note("no artifacts matched: " + p)
+	}
+	var size int64
+	for _, f := range files {
+		size += f.Size
+	}
+	note(fmt.Sprintf("kept %d artifacts, %d bytes", len(files), size))
+	return files, nil
+}
+
+// OpenArtifact opens the named artifact kept from the node of the run, from this host only.
+func (h *Hub) OpenArtifact(flowID, runID, nodeID, name string) (io.ReadCloser, error) {
+	f, err := h.artifacts.Open(flowID, runID, nodeID, name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// AllClientOpenArtifact opens the named artifact from this host, or whichever host has it
+func (h *Hub) AllClientOpenArtifact(flowID, runID, nodeID, name string) (io.ReadCloser, error) {
+	rc, err := h.OpenArtifact(flowID, runID, nodeID, name)
+	if err != artifact.ErrNotFound {
+		return rc, err
+	}
+	for _, host := range h.hostList() {
+		if h.isSelf(host.GetConfig()) {
+			continue
+		}
+		rc, err := host.OpenArtifact(flowID, runID, nodeID, name)
+		if err == artifact.ErrNotFound {
+			continue
+		}
+		return rc, err
+	}
+	return nil, artifact.ErrNotFound
+}
+
+// runArtifacts is the source of the artifacts for the nodes of a run
+type runArtifacts struct {
+	h   *Hub
+	ref event.RunRef
+}
+
+// Find returns the artifacts kept by the node in this run, or in the latest good run of another flow
+func (a runArtifacts) Find(flowID, nodeID string) (string, []artifact.File, error) {
+	if flowID == "" {
+		runID := a.ref.Run.String()
+		run := a.h.FindRun(a.ref.FlowRef.ID, runID)
+		if run == nil {
+			return "", nil, fmt.Errorf("run %s not found", runID)
+		}
+		run.RLock()
+		files := run.ExecNodes[nodeID].Artifacts
+		run.RUnlock()
+		if len(files) == 0 {
+			return "", nil, fmt.Errorf("node %s kept no artifacts in this run", nodeID)
+		}
+		return runID, files, nil
+	}
+
+	// find the latest good run of the flow on any host
+	var latest *client.RunSummary
+	sums := a.h.AllClientRuns(flowID)
+	for i, s := range sums.Archive {
+		if !s.Ended || !s.Good {
+			continue
+		}
+		if latest == nil || s.EndTime.After(latest.EndTime) {
+			latest = &sums.Archive[i]
+		}
+	}
+	if latest == nil {
+		return "", nil, fmt.Errorf("flow %s has no good runs", flowID)
+	}
+	runID := latest.Ref.Run.String()
+	run := a.h.AllClientFindRun(flowID, runID)
+	if run == nil {
+		return "", nil, fmt.Errorf("run %s of flow %s not found", runID, flowID)
+	}
+	files := run.ExecNodes[nodeID].Artifacts
+	if len(files) == 0 {
+		return "", nil, fmt.Errorf("node %s kept no artifacts in run %s of flow %s", nodeID, runID, flowID)
+	}
+	return runID, files, nil
+}
+
+// Open opens the named artifact from whichever host has it
+func (a runArtifacts) Open(flowID, runID, nodeID, name string) (io.ReadCloser, error) {
+	if flowID == "" {
+		flowID = a.ref.FlowRef.ID
+	}
+	return a.h.AllClientOpenArtifact(flowID, runID, nodeID, name)
+}
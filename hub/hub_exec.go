@@ -4,10 +4,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/floeit/floe/artifact"
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/runlog"
+	"github.com/floeit/floe/secret"
 )
 
 // ExecutePending executes a pending on this host if this host has no conflicts.
@@ -15,21 +18,38 @@ import (
 // been called via the server API as a request for this host to accept the run.
 // The boolean returned represents whether the flow was considered dealt with,
 // meaning an attempt to start executing it occurred.
+//
+// ExecutePending is idempotent on the pend RunRef, a pend that has already been activated on
+// this host is considered dealt with, so a retried request can not start the same run twice.
 func (h *Hub) ExecutePending(pend Pend) (bool, error) {
 	log.Debugf("<%s> - exec - attempt to execute pending from:<%s>", pend, pend.TriggeredNode)
 
+	// serialise the check and activation so concurrent duplicates see each other
+	h.execMu.Lock()
+	defer h.execMu.Unlock()
+
+	if h.runs.hasRun(pend.Ref) {
+		log.Debugf("<%s> - exec - already activated on this host", pend)
+		return true, nil
+	}
+
+	// a draining host takes no new runs
+	if h.Draining() {
+		log.Debugf("<%s> - exec - host is draining", pend)
+		return false, nil
+	}
+
 	// use the flow definition as used when the pending run was created
 	flow := pend.Flow
 
 	// confirm no currently executing flows have a resource flag conflicts
 	active := h.runs.activeFlows()
+	if h.maxRuns > 0 && len(active) >= h.maxRuns {
+		log.Debugf("<%s> - exec - host at capacity with %d active runs", pend, len(active))
+		return false, nil
+	}
 	log.Debugf("<%s> - exec - checking active conflicts with %d active runs", pend, len(active))
-	for _, aRef := range active {
-		fl := h.config.Flow(aRef)
-		if fl == nil {
-			log.Error("Strange that we have an active flow without a matching config", aRef)
-			continue
-		}
+	for _, fl := range h.runs.activeConfigs() {
 		if anyTags(fl.ResourceTags, flow.ResourceTags) {
 			log.Debugf("<%s> - exec - found resource tag conflict on tags: %v with already active tags: %v",
 				pend, flow.ResourceTags, fl.ResourceTags)
@@ -102,7 +122,7 @@ func (h *Hub) dispatchToActive(e event.Event) {
 	}
 
 	// for all active flows find ones that match
-	_, r := h.runs.findActiveRun(e.RunRef.Run)
+	_, r := h.runs.findActiveRun(e.RunRef)
 	if r == nil {
 		// no matching active run - throw the events away
 		log.Debugf("<%s> - dispatch - event '%s' received, but run not active (ignoring event)", e.RunRef, e.Tag)
@@ -115,12 +135,8 @@ func (h *Hub) dispatchToActive(e event.Event) {
 		// in which case the event SourceNode is the source that requested the data input, so
 		// is therefore also the target in this case.
 
-		// the flow is specified, as is the target node
-		flow := h.config.Flow(e.RunRef.FlowRef)
-		if flow == nil {
-			log.Errorf("<%s> - dispatch - no flow for inbound data event flow: %s", e.RunRef, e.RunRef.FlowRef)
-			return
-		}
+		// the flow is specified, as is the target node, from the config the run started with
+		flow := r.Flow
 		// strip off the inbound prefix
 		e.Tag = e.Tag[len(inboundPrefix)+1:]
 		node := flow.Node(e.SourceNode.ID)
@@ -130,7 +146,7 @@ func (h *Hub) dispatchToActive(e event.Event) {
 		}
 
 		// tell the node we have some more data
-		h.setFormData(r, node, e.Opts)
+		h.setFormData(r, node, e.Opts, e.Actor)
 		return
 	}
 
@@ -147,12 +163,12 @@ func (h *Hub) dispatchToActive(e event.Event) {
 			// All good statuses should really make it to a next node, e.g. a merge node,
 			// so log the that this one has not.
 			log.Debugf("<%s> - dispatch - nothing listening to good event '%s' - prematurely end", e.RunRef, e.Tag)
-			h.endRun(r, e.SourceNode, e.Opts, true)
+			h.endRun(r, e.SourceNode, e.Opts, true, "")
 		} else {
 			// bad events un routed can implicitly trigger the end of a run,
 			// with the run marked bad
 			log.Debugf("<%s> - dispatch - nothing listening to bad event '%s' (ending flow as bad)", e.RunRef, e.Tag)
-			h.endRun(r, e.SourceNode, e.Opts, false)
+			h.endRun(r, e.SourceNode, e.Opts, false, "")
 		}
 		return
 	}
@@ -164,12 +180,12 @@ func (h *Hub) dispatchToActive(e event.Event) {
 		case config.NcTask:
 			switch nt.NType(n.TypeOfNode()) {
 			case nt.NtEnd: // special task type end the run
-				h.endRun(r, n.NodeRef(), e.Opts, e.Good)
+				h.endRun(r, n.NodeRef(), e.Opts, e.Good, e.Actor)
 				return
 			case nt.NtData: // initial event triggering a data node (not targeted at specific node)
-				h.setFormData(r, n, e.Opts)
+				h.setFormData(r, n, e.Opts, e.Actor)
 			default:
-				ws := h.prepareForExec(r.Ref, &e, r.Flow.ReuseSpace, r.Flow.Env)
+				ws := h.prepareForExec(r.Ref, &e, r.Flow.ReuseSpace, r.Flow.Env, r.Flow.Image)
 				// asynchronous execute
 				go h.executeNode(r, n, e, ws)
 			}
@@ -179,7 +195,7 @@ func (h *Hub) dispatchToActive(e event.Event) {
 	}
 }
 
-func (h *Hub) prepareForExec(runRef event.RunRef, e *event.Event, singleWs bool, flowEnv []string) *nt.Workspace {
+func (h *Hub) prepareForExec(runRef event.RunRef, e *event.Event, singleWs bool, flowEnv []string, flowImage string) *nt.Workspace {
 	// setup the workspace config
 	ws, err := h.getWorkspace(runRef, singleWs)
 	if err != nil {
@@ -188,8 +204,15 @@ func (h *Hub) prepareForExec(runRef event.RunRef, e *event.Event, singleWs bool,
 	}
 
 	// inject any top level config opts
-	if h.config.Common.GitKey != "" {
-		e.Opts["key-file"] = h.config.Common.GitKey
+	if key := h.Config().Common.GitKey; key != "" {
+		e.Opts["key-file"] = key
+	}
+	// the flow image is the default, any image in the node config overrides it
+	if flowImage != "" {
+		if e.Opts == nil {
+			e.Opts = nt.Opts{}
+		}
+		e.Opts["image"] = flowImage
 	}
 
 	// any event env with the flow level env
@@ -198,17 +221,35 @@ func (h *Hub) prepareForExec(runRef event.RunRef, e *event.Event, singleWs bool,
 	return ws
 }
 
-// env vars from opts are added to the end of env passed in
+// env vars from opts are added to the end of env passed in. Only the config can reference secrets
+// so any env vars from the event that do are dropped.
 func mergeEnvOpts(opts nt.Opts, env []string) {
 	if opts == nil {
 		return
 	}
 	if ev, ok := opts["env"]; ok {
-		e, eok := ev.([]string)
-		if !eok {
-			return
+		// env from yaml or go is a []string, but from a json push or data event it is a []interface{}
+		var e []string
+		switch ee := ev.(type) {
+		case []string:
+			e = ee
+		case []interface{}:
+			for _, v := range ee {
+				s, sok := v.(string)
+				if !sok {
+					log.Warning("dropping event env var that is not a string", v)
+					continue
+				}
+				e = append(e, s)
+			}
+		}
+		for _, v := range e {
+			if secret.HasRef(v) {
+				log.Warning("dropping event env var referencing a secret", v)
+				continue
+			}
+			env = append(env, v)
 		}
-		env = append(env, e...)
 	}
 	opts["env"] = env
 }
@@ -227,22 +268,40 @@ func (h *Hub) executeNode(run *Run, node exeNode, e event.Event, ws *nt.Workspac
 	nodeID := node.NodeRef().ID
 	log.Debugf("<%s> - exec node - event tag: %s, node: %s", runRef, e.Tag, nodeID)
 
-	// capture and emit all the node updates
+	// the resolver that expands the secrets for this node also masks them
+	var secrets *secret.Resolver
+	if ws != nil {
+		secrets = ws.Secrets
+		// any commands still running when the run ends, or is cancelled, are killed
+		ws.Cancel = run.stopped()
+	}
+
+	// the output goes to the log store, the run only references it
+	logKey := runlog.Key(runRef.FlowRef.ID, runRef.Run.String(), nodeID)
+	defer h.logs.Close(logKey)
+
+	// capture and emit all the node updates, with any secrets masked
 	updates := make(chan string)
+	updatesDone := make(chan struct{})
 	go func() {
+		defer close(updatesDone)
 		for update := range updates {
+			update = secrets.Mask(update)
+			// log it first so the offset in the event can be matched against the stored log
+			offset, err := h.logs.Append(logKey, update)
+			if err != nil {
+				log.Errorf("<%s> - exec node (%s) - could not append log: %v", runRef, node.NodeRef(), err)
+			}
 			h.queue.Publish(event.Event{
 				RunRef:     runRef,
 				SourceNode: node.NodeRef(),
 				Tag:        tagNodeUpdate,
 				Opts: nt.Opts{
 					"update": update,
+					"offset": offset,
 				},
 				Good: true,
 			})
-
-			// explicitly update any exec nodes with the ongoing execute
-			h.runs.updateExecNode(run, nodeID, zt, zt, false, update)
 		}
 	}()
 
@@ -254,13 +313,17 @@ func (h *Hub) executeNode(run *Run, node exeNode, e event.Event, ws *nt.Workspac
 	})
 
 	// set the start time for the node
-	h.runs.updateExecNode(run, nodeID, time.Now(), zt, false, "")
+	start := time.Now()
+	h.runs.updateExecNode(run, nodeID, start, zt, false, logKey, nil)
 
 	status, outOpts, err := node.Execute(ws, e.Opts, updates)
 	close(updates)
+	// make sure all the output is logged before the node ends
+	<-updatesDone
 
 	if err != nil {
-		log.Errorf("<%s> - exec node (%s) - execute produced error: %v", runRef, node.NodeRef(), err)
+		msg := secrets.Mask(err.Error())
+		log.Errorf("<%s> - exec node (%s) - execute produced error: %s", runRef, node.NodeRef(), msg)
 		// publish the fact an internal node error happened
 		h.publishIfActive(event.Event{
 			RunRef:     runRef,
@@ -269,7 +332,11 @@ func (h *Hub) executeNode(run *Run, node exeNode, e event.Event, ws *nt.Workspac
 			Opts:       outOpts,
 			Good:       false,
 		})
-		h.runs.updateExecNode(run, nodeID, zt, time.Now(), false, err.Error())
+		if _, err := h.logs.Append(logKey, msg); err != nil {
+			log.Errorf("<%s> - exec node (%s) - could not append log: %v", runRef, node.NodeRef(), err)
+		}
+		h.runs.updateExecNode(run, nodeID, zt, time.Now(), false, "", nil)
+		nodeSeconds.Since(start, runRef.FlowRef.ID, nodeID, "error")
 		return
 	}
 
@@ -282,10 +349,19 @@ func (h *Hub) executeNode(run *Run, node exeNode, e event.Event, ws *nt.Workspac
 
 	// construct the event tag
 	tagbit, good := node.Status(status)
+
+	// keep the artifacts of a good node, it is not good if they can not be kept
+	var arts []artifact.File
+	if good {
+		if arts, err = h.keepArtifacts(run, node, ws, logKey); err != nil {
+			tagbit, good = config.SubTagBad, false
+		}
+	}
 	ne.Tag = node.GetTag(tagbit)
 	ne.Good = good
 
-	h.runs.updateExecNode(run, nodeID, zt, time.Now(), good, "")
+	h.runs.updateExecNode(run, nodeID, zt, time.Now(), good, "", arts)
+	nodeSeconds.Since(start, runRef.FlowRef.ID, nodeID, result(good))
 
 	// and publish it
 	h.publishIfActive(ne)
@@ -295,7 +371,8 @@ func (h *Hub) executeNode(run *Run, node exeNode, e event.Event, ws *nt.Workspac
 // emits a system event which no other node should be listening for so will effectively
 // pause the run, until later when any inbound data triggers the event for this data node.
 // Ultimately either explicitly marking the node good or bad, and issuing the appropriate event.
-func (h *Hub) setFormData(run *Run, node exeNode, opts nt.Opts) {
+// user is whoever supplied the data, and is recorded as the approver if the node completes.
+func (h *Hub) setFormData(run *Run, node exeNode, opts nt.Opts, user string) {
 	// keep the filled in form values separate from the config opts
 	// only use map[string]string opts
 	vals := nt.Opts{}
@@ -315,12 +392,13 @@ func (h *Hub) setFormData(run *Run, node exeNode, opts nt.Opts) {
 	}
 
 	// add the form fields to the flow. if good or bad then we have enough data for a decision
-	h.runs.updateDataNode(run, node.NodeRef().ID, outOpts, status == 2)
+	h.runs.updateDataNode(run, node.NodeRef().ID, outOpts, status == 2, user)
 
 	ev := event.Event{
 		RunRef:     run.Ref,
 		SourceNode: node.NodeRef(),
 		Opts:       outOpts,
+		Actor:      user,
 	}
 	switch status {
 	case 0: // form data accepted and marking the node good
@@ -369,14 +447,17 @@ func (h *Hub) mergeEvent(run *Run, node mergeNode, e event.Event) {
 	}
 }
 
-// endRun marks and saves this run as being complete
-func (h *Hub) endRun(run *Run, source config.NodeRef, opts nt.Opts, good bool) {
+// endRun marks and saves this run as being complete, actor is set if a user ended the run
+func (h *Hub) endRun(run *Run, source config.NodeRef, opts nt.Opts, good bool, actor string) {
 	log.Debugf("<%s> - END RUN (good:%v)", run.Ref, good)
 	didEndIt := h.runs.end(run, good)
 	// if this end call was not the one that actually ended it then dont publish the end event
 	if !didEndIt {
 		return
 	}
+	run.RLock()
+	runSeconds.Observe(run.EndTime.Sub(run.StartTime).Seconds(), run.Ref.FlowRef.ID, result(good))
+	run.RUnlock()
 	// publish specific end run event - so other observers know specifically that this flow finished
 	e := event.Event{
 		RunRef:     run.Ref,
@@ -384,13 +465,30 @@ func (h *Hub) endRun(run *Run, source config.NodeRef, opts nt.Opts, good bool) {
 		Tag:        tagEndFlow,
 		Opts:       opts,
 		Good:       good,
+		Actor:      actor,
 	}
 	h.queue.Publish(e)
 }
 
+// CancelRun cancels the run if it is pending or active on this host, an active run is ended as bad.
+// It returns false if the run is not pending or active here.
+func (h *Hub) CancelRun(flowID, runID, user string) (bool, error) {
+	if p, ok := h.runs.findPend(flowID, runID); ok {
+		log.Debugf("<%s> - cancel pending run by: %s", p.Ref, user)
+		return true, h.removePend(p)
+	}
+	run := h.runs.findActive(flowID, runID)
+	if run == nil {
+		return false, nil
+	}
+	log.Debugf("<%s> - cancel active run by: %s", run.Ref, user)
+	h.endRun(run, config.NodeRef{}, nt.Opts{"cancelled-by": user}, false, user)
+	return true, nil
+}
+
 // publishIfActive publishes the event if the run is still active
 func (h *Hub) publishIfActive(e event.Event) {
-	_, r := h.runs.findActiveRun(e.RunRef.Run)
+	_, r := h.runs.findActiveRun(e.RunRef)
 	if r == nil {
 		return
 	}
@@ -0,0 +1,83 @@
+package hub
+
+import (
+	"io"
+
+	"github.com/floeit/floe/runlog"
+)
+
+// NodeLog returns a page of up to limit lines of the output of the node from the byte offset,
+// from this host only.
+func (h *Hub) NodeLog(flowID, runID, nodeID string, offset int64, limit int) (runlog.Page, error) {
+	return h.logs.Read(runlog.Key(flowID, runID, nodeID), offset, limit)
+}
+
+// NodeLogTail returns up to the last n lines of the output of the node, from this host only.
+func (h *Hub) NodeLogTail(flowID, runID, nodeID string, n int) (runlog.Page, error) {
+	return h.logs.Tail(runlog.Key(flowID, runID, nodeID), n)
+}
+
+// OpenNodeLog opens the whole output of the node for reading, from this host only.
+func (h *Hub) OpenNodeLog(flowID, runID, nodeID string) (io.ReadCloser, error) {
+	return h.logs.Open(runlog.Key(flowID, runID, nodeID))
+}
+
+// AllClientNodeLog returns the page of the node output from this host, or whichever host has it
+func (h *Hub) AllClientNodeLog(flowID, runID, nodeID string, offset int64, limit int) (runlog.Page, error) {
+	pg, err := h.NodeLog(flowID, runID, nodeID, offset, limit)
+	if err != runlog.ErrNotFound {
+		return pg, err
+	}
+	for _, host := range h.hostList() {
+		if h.isSelf(host.GetConfig()) {
+			continue
+		}
+		pg, err := host.NodeLog(flowID, runID, nodeID, offset, limit)
+		if err == runlog.ErrNotFound {
+			continue
+		}
+		return pg, err
+	}
+	return runlog.Page{}, runlog.ErrNotFound
+}
+
+// AllClientOpenNodeLog opens the whole node output from this host, or whichever host has it
+func (h *Hub) AllClientOpenNodeLog(flowID, runID, nodeID string) (io.ReadCloser, error) {
+	rc, err := h.OpenNodeLog(flowID, runID, nodeID)
+	if err != runlog.ErrNotFound {
+		return rc, err
+	}
+	for _, host := range h.hostList() {
+		if h.isSelf(host.GetConfig()) {
+			continue
+		}
+		rc, err := host.OpenNodeLog(flowID, runID, nodeID)
+		if err == runlog.ErrNotFound {
+			continue
+		}
+		return rc, err
+	}
+	return nil, runlog.ErrNotFound
+}
+
+// LogTails returns up to the last n lines of the output of each exec node of the run on this host
+func (h *Hub) LogTails(run *Run, n int) map[string]runlog.Page {
+	run.RLock()
+	keys := map[string]string{}
+	for id, ex := range run.ExecNodes {
+		if ex.Log != "" {
+			keys[id] = ex.Log
+		}
+	}
+	run.RUnlock()
+
+	tails := map[string]runlog.Page{}
+	for id, k := range keys {
+		pg, err := h.logs.Tail(k, n)
+		if err != nil {
+			continue
+		}
+		tails[id] = pg
+	}
+	return tails
+}
@@ -2,6 +2,7 @@ package hub
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -16,10 +17,19 @@ import (
 // be serviced on this host i.e. pending runs - it uses the hub client to ask
 // other nodes in the cluster if they can take a pending run.
 
+const (
+	// leaseTime is how long a pend is reserved for the host it was offered to, it must be
+	// longer than any single p2p request can take.
+	leaseTime = time.Second * 60
+	// orphanAge is how long a host must be unreachable before its pends are adopted by a peer.
+	orphanAge = time.Second * 60
+)
+
 // serviceLists attempts to dispatch pending flows
 // TODO and times outs any active flows that are past their deadline
 func (h *Hub) serviceLists() {
 	for range time.Tick(time.Second * 5) {
+		h.adoptOrphans()
 		err := h.distributeAllPending()
 		if err != nil {
 			log.Error(err)
@@ -29,10 +39,11 @@ func (h *Hub) serviceLists() {
 
 // distributeAllPending loops through all pending runs assessing whether they can be run then distributes them.
 func (h *Hub) distributeAllPending() error {
+	hosts := h.hostList()
 	for _, p := range h.runs.allPends() {
 		log.Debugf("<%s> - pending - attempt dispatch", p)
 
-		if len(h.hosts) == 0 {
+		if len(hosts) == 0 {
 			log.Debugf("<%s> - pending - no hosts configured running job locally", p)
 			ok, err := h.ExecutePending(p)
 			if err != nil {
@@ -49,9 +60,40 @@ func (h *Hub) distributeAllPending() error {
 			continue
 		}
 
+		now := time.Now()
+
+		// while the lease is live we only ever retry the host we offered the pend to
+		if p.Lease.live(now) {
+			host := hostByID(hosts, p.Lease.HostID)
+			if host == nil {
+				log.Debugf("<%s> - pending - leased host %s unknown, waiting for lease to expire", p, p.Lease.HostID)
+				continue
+			}
+			h.offer(host, p)
+			continue
+		}
+
+		// a pend with an expired lease, or one that we are not sure about may already
+		// be held by another host, so check before offering it anywhere
+		if p.Lease.HostID != "" || p.unconfirmed {
+			held, sure := h.heldElsewhere(hosts, p)
+			if held {
+				log.Debugf("<%s> - pending - already held by another host, dropping", p)
+				if err := h.removePend(p); err != nil {
+					log.Error("could not save pending removal", err)
+				}
+				continue
+			}
+			if !sure {
+				log.Debugf("<%s> - pending - can not yet confirm no other host holds it", p)
+				continue
+			}
+			h.runs.confirm(p.Ref)
+		}
+
 		// Find candidate hosts that have a superset of the tags for the pending flow
 		candidates := []*client.FloeHost{}
-		for _, host := range h.hosts {
+		for _, host := range hosts {
 			cfg := host.GetConfig()
 			if cfg.HostID == "" {
 				continue // we have not communicated with the other host yet
@@ -63,18 +105,18 @@ func (h *Hub) distributeAllPending() error {
 			}
 		}
 
-		log.Debugf("<%s> - pending - found %d candidate hosts", p, len(candidates))
+		// drop any offline or full hosts and order the rest least loaded first
+		candidates = client.ByLoad(candidates)
+
+		log.Debugf("<%s> - pending - found %d available candidate hosts", p, len(candidates))
 
-		// attempt to send it to any of the candidates
+		// attempt to send it to the least loaded candidate, falling back to the next
+		// only if a host definitely rejects it e.g. due to a resource conflict
 		launched := false
 		for _, host := range candidates {
-			if host.AttemptExecute(p) {
-				log.Debugf("<%s> - pending - executed on <%s>", p, host.GetConfig().HostID)
-				// remove from our pending list
-				if err := h.removePend(p); err != nil {
-					log.Error("could not save pending removal", err)
-				}
-				launched = true
+			accepted, unknown := h.offer(host, p)
+			if accepted || unknown {
+				launched = accepted
 				break
 			}
 		}
@@ -88,6 +130,180 @@ func (h *Hub) distributeAllPending() error {
 	return nil
 }
 
+// offer leases the pend to the host and asks it to execute it. The pend is removed from
+// our pending list once the host acknowledges it. If the host definitely refuses the
+// lease is released, if the outcome is unknown the lease is kept so that any retry goes to
+// the same host, which will not start the run twice.
+func (h *Hub) offer(host *client.FloeHost, p Pend) (accepted, unknown bool) {
+	cfg := host.GetConfig()
+
+	// running on this host needs no lease as the execution is not remote
+	if h.isSelf(cfg) {
+		ok, err := h.ExecutePending(p)
+		if err != nil {
+			log.Errorf("<%s> - pending - local execute failed: %v", p, err)
+			return false, false
+		}
+		if ok {
+			if err := h.removePend(p); err != nil {
+				log.Error("could not save pending removal", err)
+			}
+		}
+		return ok, false
+	}
+
+	if p.Lease.HostID != cfg.HostID || !p.Lease.live(time.Now()) {
+		p.Lease = Lease{
+			HostID:  cfg.HostID,
+			Expires: time.Now().Add(leaseTime),
+		}
+		// the lease must be persisted before the offer or a restart could lose it
+		if err := h.runs.setLease(p.Ref, p.Lease); err != nil {
+			log.Errorf("<%s> - pending - could not save lease: %v", p, err)
+			return false, false
+		}
+	}
+
+	ok, err := host.AttemptExecute(p)
+	if err != nil {
+		log.Errorf("<%s> - pending - offer to <%s> had unknown outcome: %v", p, cfg.HostID, err)
+		return false, true
+	}
+	if !ok {
+		// a definite refusal so the pend can go elsewhere
+		if err := h.runs.setLease(p.Ref, Lease{}); err != nil {
+			log.Errorf("<%s> - pending - could not save lease release: %v", p, err)
+		}
+		return false, false
+	}
+
+	log.Debugf("<%s> - pending - executed on <%s>", p, cfg.HostID)
+	// remove from our pending list
+	if err := h.removePend(p); err != nil {
+		log.Error("could not save pending removal", err)
+	}
+	return true, false
+}
+
+// hostByID returns the host in hosts with the given id or nil
+func hostByID(hosts []*client.FloeHost, id string) *client.FloeHost {
+	for _, host := range hosts {
+		if host.GetConfig().HostID == id {
+			return host
+		}
+	}
+	return nil
+}
+
+// heldElsewhere asks all other hosts if they hold the pend in any of their run lists.
+// sure is false if not all hosts could be asked, unless those that could not have not been
+// heard from for long enough that they are presumed dead.
+func (h *Hub) heldElsewhere(hosts []*client.FloeHost, p Pend) (held, sure bool) {
+	sure = true
+	for _, host := range hosts {
+		cfg := host.GetConfig()
+		if h.isSelf(cfg) {
+			continue
+		}
+		if cfg.HostID == "" || !cfg.Reachable {
+			if time.Since(h.lastSeen(cfg)) <= orphanAge {
+				sure = false
+			}
+			continue
+		}
+		if host.FindRun(p.Ref.FlowRef.ID, p.Ref.Run.String()) != nil {
+			return true, true
+		}
+	}
+	return false, sure
+}
+
+// lastSeen returns when this host last heard from the peer, by pinging it or mirroring its
+// pends, or when this host started if it never has.
+func (h *Hub) lastSeen(cfg client.HostConfig) time.Time {
+	seen := cfg.LastSeen
+	h.peerMu.Lock()
+	if m, ok := h.peerPends[cfg.HostID]; ok && cfg.HostID != "" && m.seen.After(seen) {
+		seen = m.seen
+	}
+	h.peerMu.Unlock()
+	if seen.IsZero() {
+		seen = h.started
+	}
+	return seen
+}
+
+// peerMirror is the last known pending list of a peer
+type peerMirror struct {
+	pends []Pend
+	seen  time.Time // when the peer last answered
+}
+
+// adoptOrphans mirrors the pending lists of all reachable peers, and adopts the pends of any
+// peer that has been unreachable for longer than orphanAge, whether or not it is still in the
+// host list. Only the host with the lowest id of those that can reach each other adopts them.
+// A host that is alive but cut off by a network partition is taken for dead, and each side of
+// the partition may adopt the same pends, so a run can then start once on each side.
+func (h *Hub) adoptOrphans() {
+	hosts := h.hostList()
+
+	h.peerMu.Lock()
+	defer h.peerMu.Unlock()
+
+	// mirror the pends of everyone we can reach
+	online := map[string]bool{h.hostID: true}
+	for _, host := range hosts {
+		cfg := host.GetConfig()
+		if cfg.HostID == "" || h.isSelf(cfg) || !cfg.Reachable {
+			continue
+		}
+		online[cfg.HostID] = true
+		pends := []Pend{}
+		if host.GetPends(&pends) {
+			h.peerPends[cfg.HostID] = peerMirror{pends: pends, seen: time.Now()}
+		}
+	}
+
+	// and adopt those of anyone that has been gone too long, even if discovery has dropped them
+	if !isAdopter(h.hostID, online) {
+		return
+	}
+	for id, m := range h.peerPends {
+		if online[id] || time.Since(m.seen) < orphanAge {
+			continue
+		}
+		for _, p := range m.pends {
+			added, err := h.runs.adopt(p)
+			if err != nil {
+				log.Errorf("<%s> - pending - could not save adopted pend: %v", p, err)
+				continue
+			}
+			if added {
+				log.Infof("<%s> - pending - adopted from unreachable host %s", p, id)
+				h.queue.Publish(event.Event{
+					RunRef: p.Ref,
+					Tag:    tagStateChange,
+					Opts: nt.Opts{
+						"action": "add-pend",
+					},
+					Good: true,
+				})
+			}
+		}
+		delete(h.peerPends, id)
+	}
+}
+
+// isAdopter returns true if hostID is the lowest of all the online host ids
+func isAdopter(hostID string, online map[string]bool) bool {
+	for id := range online {
+		if id < hostID {
+			return false
+		}
+	}
+	return true
+}
+
 // pendFlowFromTrigger uses the subscription fired event e to put any flows on the pending queue
 // for any matching triggers.
 func (h *Hub) pendFlowFromTrigger(e event.Event) error {
@@ -99,7 +315,8 @@ func (h *Hub) pendFlowFromTrigger(e event.Event) error {
 	log.Debugf("attempt to trigger type:<%s> (specified flow: %v)", triggerType, e.RunRef.FlowRef)
 
 	// find any Flows with subs matching this event
-	foundFlows := h.config.FindFlowsByTriggers(triggerType, e.RunRef.FlowRef, e.Opts)
+	conf := h.Config()
+	foundFlows := conf.FindFlowsByTriggers(triggerType, e.RunRef.FlowRef, e.Opts)
 	if len(foundFlows) == 0 {
 		log.Debugf("no matching flow for type:'%s' (specified flow: %v)", triggerType, e.RunRef.FlowRef)
 		return nil
@@ -110,7 +327,7 @@ func (h *Hub) pendFlowFromTrigger(e event.Event) error {
 		// make sure the flow has loaded in any references
 		if ff.FlowFile != "" {
 			log.Debugf("<%s> - getting flow from file '%s'", ff.Ref, ff.FlowFile)
-			err := ff.Load(h.cachePath)
+			err := ff.Load(filepath.Join(h.cachePath, flowFileDir))
 			if err != nil {
 				log.Errorf("<%s> - could not load in the flow from FlowFile: '%s'", ff.Ref, ff.FlowFile)
 				continue
@@ -121,7 +338,7 @@ func (h *Hub) pendFlowFromTrigger(e event.Event) error {
 		opts := nt.MergeOpts(ff.Matched.Opts, e.Opts)
 
 		// add the flow to the pending list making note of the node and opts that triggered it
-		ref, err := h.addToPending(ff.Flow, h.hostID, ff.Matched.Ref, opts)
+		ref, err := h.addToPending(ff.Flow, h.hostID, ff.Matched.Ref, opts, e.Actor)
 		if err != nil {
 			return err
 		}
@@ -130,8 +347,9 @@ func (h *Hub) pendFlowFromTrigger(e event.Event) error {
 	return nil
 }
 
-// addToPending adds a flow to the list of pending runs and publishes appropriate system state change event.
-func (h *Hub) addToPending(flow *config.Flow, hostID string, trig config.NodeRef, opts nt.Opts) (event.RunRef, error) {
+// addToPending adds a flow to the list of pending runs and publishes appropriate system state change event
+// attributed to the actor that triggered it.
+func (h *Hub) addToPending(flow *config.Flow, hostID string, trig config.NodeRef, opts nt.Opts, actor string) (event.RunRef, error) {
 	ref, err := h.runs.addToPending(flow, hostID, trig, opts)
 	if err != nil {
 		return ref, err
@@ -143,7 +361,8 @@ func (h *Hub) addToPending(flow *config.Flow, hostID string, trig config.NodeRef
 		Opts: nt.Opts{
 			"action": "add-pend",
 		},
-		Good: true,
+		Good:  true,
+		Actor: actor,
 	})
 
 	return ref, nil
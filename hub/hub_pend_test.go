@@ -0,0 +1,289 @@
+package hub
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/client"
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/store"
+)
+
+// fakePeer is a minimal p2p api for a host
+type fakePeer struct {
+	sync.Mutex
+	id     string
+	active int
+	codes  []int // the sequence of codes to respond to exec requests with
+	execs  int
+	cutOff bool // partitioned, so not answering
+}
+
+func (f *fakePeer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	var pl interface{}
+	code := http.StatusOK
+	if f.cutOff {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/p2p/config":
+		pl = struct{ Config client.HostConfig }{client.HostConfig{HostID: f.id, Online: true, ActiveRuns: f.active}}
+	case "/p2p/flows/exec":
+		code = f.codes[f.execs]
+		f.execs++
+	default:
+		code = http.StatusNotFound
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct{ Payload interface{} }{pl})
+}
+
+func (f *fakePeer) execCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.execs
+}
+
+func waitForHosts(t *testing.T, h *Hub) {
+	for i := 0; i < 100; i++ {
+		ready := true
+		for _, host := range h.hostList() {
+			if host.GetConfig().HostID == "" {
+				ready = false
+			}
+		}
+		if ready {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Fatal("hosts never responded")
+}
+
+func TestLeasedDispatch(t *testing.T) {
+	t.Parallel()
+
+	// p1 is less loaded so gets offered the pend first, but the outcome is unknown
+	p1 := &fakePeer{id: "p1", codes: []int{http.StatusInternalServerError, http.StatusOK}}
+	p2 := &fakePeer{id: "p2", active: 5, codes: []int{http.StatusOK}}
+	s1 := httptest.NewServer(p1)
+	defer s1.Close()
+	s2 := httptest.NewServer(p2)
+	defer s2.Close()
+
+	h := &Hub{
+		hostID:    "h1",
+		queue:     &event.Queue{},
+		runs:      newRunStore(store.NewMemStore()),
+		hosts:     map[string]*client.FloeHost{},
+		peerPends: map[string]peerMirror{},
+		started:   time.Now(),
+	}
+	h.syncHosts([]string{s1.URL, s2.URL})
+	defer func() {
+		for _, host := range h.hostList() {
+			host.Stop()
+		}
+	}()
+	waitForHosts(t, h)
+
+	ref, err := h.runs.addToPending(&config.Flow{ID: "flow", Ver: 1}, "h1", config.NodeRef{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.distributeAllPending()
+	pends := h.runs.allPends()
+	if len(pends) != 1 {
+		t.Fatal("pend should still be pending after an unknown outcome")
+	}
+	if pends[0].Lease.HostID != "p1" || !pends[0].Lease.live(time.Now()) {
+		t.Errorf("pend should be leased to p1, got %+v", pends[0].Lease)
+	}
+	if p2.execCount() != 0 {
+		t.Error("p2 should not have been offered the pend while p1 may have it")
+	}
+
+	// the retry must go to the leased host only
+	h.distributeAllPending()
+	if p1.execCount() != 2 || p2.execCount() != 0 {
+		t.Errorf("retry went to the wrong host p1:%d p2:%d", p1.execCount(), p2.execCount())
+	}
+	if len(h.runs.allPends()) != 0 {
+		t.Error("acknowledged pend should be removed")
+	}
+	if h.runs.hasRun(ref) {
+		t.Error("pend should not have run locally")
+	}
+}
+
+func TestHeldElsewherePartitioned(t *testing.T) {
+	t.Parallel()
+
+	live := &fakePeer{id: "p1"}
+	s1 := httptest.NewServer(live)
+	defer s1.Close()
+	s2 := httptest.NewServer(http.NotFoundHandler()) // never answers as a host
+	defer s2.Close()
+
+	h := &Hub{
+		hostID:    "h1",
+		queue:     &event.Queue{},
+		runs:      newRunStore(store.NewMemStore()),
+		hosts:     map[string]*client.FloeHost{},
+		peerPends: map[string]peerMirror{},
+		started:   time.Now().Add(-2 * orphanAge), // long enough that a silent host is dead
+	}
+	h.syncHosts([]string{s1.URL})
+	defer func() {
+		for _, host := range h.hostList() {
+			host.Stop()
+		}
+	}()
+	waitForHosts(t, h)
+
+	// the live peer is cut off, and the next ping finds it unreachable
+	live.Lock()
+	live.cutOff = true
+	live.Unlock()
+	deadline := time.Now().Add(15 * time.Second)
+	for h.hostList()[0].GetConfig().Reachable {
+		if time.Now().After(deadline) {
+			t.Fatal("peer never became unreachable")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	p := Pend{Ref: event.RunRef{FlowRef: config.FlowRef{ID: "flow", Ver: 1}, Run: event.HostedIDRef{HostID: "h1", ID: 1}}}
+	if held, sure := h.heldElsewhere(h.hostList(), p); held || sure {
+		t.Errorf("a peer seen moments ago may hold it, held %v sure %v", held, sure)
+	}
+
+	// a host never heard from since this one started long ago is presumed dead
+	never := client.New(s2.URL, client.Creds{})
+	defer never.Stop()
+	if _, sure := h.heldElsewhere([]*client.FloeHost{never}, p); !sure {
+		t.Error("a host not heard from for so long should be presumed dead")
+	}
+	h.started = time.Now()
+	if _, sure := h.heldElsewhere([]*client.FloeHost{never}, p); sure {
+		t.Error("a host not heard from since a recent start may still hold it")
+	}
+}
+
+func TestExecutePendingIdempotent(t *testing.T) {
+	t.Parallel()
+
+	h := &Hub{
+		hostID: "h1",
+		queue:  &event.Queue{},
+		runs:   newRunStore(store.NewMemStore()),
+	}
+	dir, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	h.config.Common.WorkspaceRoot = dir
+	pend := Pend{
+		Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: "flow", Ver: 1},
+			Run:     event.HostedIDRef{HostID: "h2", ID: 3},
+		},
+		Flow: &config.Flow{ID: "flow", Ver: 1},
+	}
+	for i := 0; i < 3; i++ {
+		ok, err := h.ExecutePending(pend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Error("duplicate should be considered dealt with")
+		}
+	}
+	if n := h.ActiveRuns(); n != 1 {
+		t.Errorf("expected one active run got %d", n)
+	}
+}
+
+func TestAdoptUnconfirmed(t *testing.T) {
+	t.Parallel()
+
+	rs := newRunStore(store.NewMemStore())
+	p := Pend{
+		Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: "flow", Ver: 1},
+			Run:     event.HostedIDRef{HostID: "h2", ID: 3},
+		},
+	}
+	added, err := rs.adopt(p)
+	if err != nil || !added {
+		t.Fatal("adopt failed", err)
+	}
+	added, _ = rs.adopt(p)
+	if added {
+		t.Error("adopting twice should not add a second pend")
+	}
+	pends := rs.allPends()
+	if len(pends) != 1 || !pends[0].unconfirmed {
+		t.Error("adopted pend should be unconfirmed")
+	}
+	rs.confirm(p.Ref)
+	if rs.allPends()[0].unconfirmed {
+		t.Error("pend should be confirmed")
+	}
+}
+
+func TestIsAdopter(t *testing.T) {
+	online := map[string]bool{"h2": true, "h3": true}
+	if !isAdopter("h2", online) {
+		t.Error("h2 is lowest so should adopt")
+	}
+	if isAdopter("h3", online) {
+		t.Error("h3 is not lowest so should not adopt")
+	}
+}
+
+func TestAdoptOrphansOfDroppedHost(t *testing.T) {
+	t.Parallel()
+
+	h := &Hub{
+		hostID:    "h1",
+		queue:     &event.Queue{},
+		runs:      newRunStore(store.NewMemStore()),
+		hosts:     map[string]*client.FloeHost{},
+		peerPends: map[string]peerMirror{},
+	}
+	pend := func(id string, run int64) Pend {
+		return Pend{Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: id, Ver: 1},
+			Run:     event.HostedIDRef{HostID: "gone", ID: run},
+		}}
+	}
+	// neither peer is in the host list any more, as discovery dropped them
+	h.peerPends["gone"] = peerMirror{pends: []Pend{pend("flow", 1)}, seen: time.Now().Add(-2 * orphanAge)}
+	h.peerPends["blip"] = peerMirror{pends: []Pend{pend("other", 2)}, seen: time.Now()}
+
+	h.adoptOrphans()
+
+	pends := h.runs.allPends()
+	if len(pends) != 1 || pends[0].Ref.FlowRef.ID != "flow" {
+		t.Errorf("only the pends of the long gone host should be adopted, got %+v", pends)
+	}
+	if _, ok := h.peerPends["gone"]; ok {
+		t.Error("adopted mirror should be removed")
+	}
+	if _, ok := h.peerPends["blip"]; !ok {
+		t.Error("recently seen mirror should be kept")
+	}
+}
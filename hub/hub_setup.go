@@ -3,15 +3,21 @@ package hub
 import (
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/floeit/floe/artifact"
+	"github.com/floeit/floe/cache"
 	"github.com/floeit/floe/client"
 	"github.com/floeit/floe/config"
 	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/exe"
 	"github.com/floeit/floe/log"
 	"github.com/floeit/floe/path"
+	"github.com/floeit/floe/runlog"
+	"github.com/floeit/floe/secret"
 	"github.com/floeit/floe/store"
 )
 
@@ -48,10 +54,43 @@ type mergeNode interface {
 type Hub struct {
 	sync.RWMutex
 
+	execMu  sync.Mutex // serialises executing pends
+	started time.Time  // when the hub was created
+
 	cachePath string        // local file system directory to cache working files
 	hostID    string        // the id fo this host
+	reloadMu  sync.Mutex    // serialises reloads so the timers are synced in the order the configs were swapped
+	confMu    sync.RWMutex  // guards config which is swapped on a reload
 	config    config.Config // the config rules
 	queue     *event.Queue  // the event q to route all events
+	store     store.Store   // the store the hub state is persisted in
+
+	// secrets provides the values of secrets referenced in exec env vars
+	secrets secret.Provider
+
+	// logs keeps the output of the exec nodes
+	logs *runlog.Store
+
+	// artifacts keeps the files the exec nodes asked to keep from their workspace
+	artifacts *artifact.Store
+
+	// caches keeps the directories saved by cache-save nodes for later runs on this host
+	caches *cache.Store
+
+	// cleanups counts what the janitor has removed
+	cleanups cleanups
+
+	// storeCheck is the last readiness check of the store
+	storeCheck storeCheck
+
+	// drain is set when the host is draining before it shuts down
+	drain drain
+
+	// containers runs the exec commands that have an image
+	containers exe.Runtime
+
+	// streams keeps the recent events of each run for the run event streams
+	streams *runStreams
 
 	// any registered timers
 	timers *timers
@@ -59,8 +98,18 @@ type Hub struct {
 	// tags
 	tags []string // the tags that
 
-	// hosts lists all the hosts
-	hosts []*client.FloeHost
+	// maxRuns is the maximum number of runs this host will execute concurrently, 0 is unlimited
+	maxRuns int
+
+	// hosts are all the known hosts keyed by their address, they may be added
+	// and removed as the cluster membership changes
+	creds client.Creds
+	hosts map[string]*client.FloeHost
+
+	// peerPends mirrors the pending lists of the other hosts by host id, so that they
+	// can be adopted if that host dies
+	peerMu    sync.Mutex
+	peerPends map[string]peerMirror
 
 	// runs contains list of runs ongoing or the archive
 	// this is the only ongoing changing state the hub manages
@@ -68,8 +117,9 @@ type Hub struct {
 	runs *RunStore
 }
 
-// New creates a new hub with the given config
-func New(host, tags, adminTok string, c *config.Config, storage store.Store, q *event.Queue) *Hub {
+// New creates a new hub with the given config. maxRuns limits the number of concurrently
+// active runs on this host, 0 means no limit.
+func New(host, tags string, creds client.Creds, maxRuns int, c *config.Config, storage store.Store, q *event.Queue) *Hub {
 	c.Defaults()
 	// create all tags
 	l := strings.Split(tags, ",")
@@ -83,34 +133,76 @@ func New(host, tags, adminTok string, c *config.Config, storage store.Store, q *
 	if err != nil {
 		log.Fatal("can not set base path", err)
 	}
-	c.Common.StoreRoot, err = path.Expand(c.Common.StoreRoot)
-	if err != nil {
-		log.Fatal("can not set store path", err)
+	// the local caches are kept in the store root, unless that is a bucket
+	localRoot := c.Common.WorkspaceRoot
+	if c.Common.StoreType != "s3" {
+		c.Common.StoreRoot, err = path.Expand(c.Common.StoreRoot)
+		if err != nil {
+			log.Fatal("can not set store path", err)
+		}
+		localRoot = c.Common.StoreRoot
 	}
 
 	h := &Hub{
 		hostID:    host,
 		tags:      tagList,
-		cachePath: filepath.Join(c.Common.StoreRoot, "fetch_cache"),
+		maxRuns:   maxRuns,
+		creds:     creds,
+		hosts:     map[string]*client.FloeHost{},
+		started:   time.Now(),
+		peerPends: map[string]peerMirror{},
+		cachePath: filepath.Join(localRoot, "fetch_cache"),
 		config:    *c,
 		queue:     q,
+		store:     storage,
 		runs:      newRunStore(storage),
+		streams:   newRunStreams(),
 	}
 	// make sure the cache exists
-	err = os.MkdirAll(h.cachePath, 0700)
+	err = os.MkdirAll(filepath.Join(h.cachePath, flowFileDir), 0700)
 	if err != nil {
 		log.Fatal("can not create the cache path", err)
 	}
 
+	h.logs, err = runlog.New(filepath.Join(localRoot, "logs"))
+	if err != nil {
+		log.Fatal("can not create the log path", err)
+	}
+
+	h.artifacts, err = artifact.New(filepath.Join(localRoot, "artifacts"))
+	if err != nil {
+		log.Fatal("can not create the artifact path", err)
+	}
+
+	var maxCache int64 // unlimited if not positive
+	if c.Common.Cache.MaxMB > 0 {
+		maxCache = int64(c.Common.Cache.MaxMB) << 20
+	}
+	h.caches, err = cache.New(filepath.Join(localRoot, "cache"), maxCache)
+	if err != nil {
+		log.Fatal("can not create the dependency cache path", err)
+	}
+
+	h.containers = exe.NewCLI(c.Common.ContainerCLI)
+
+	h.secrets, err = secretProvider(c.Common.Secrets)
+	if err != nil {
+		log.Fatal("can not set up secrets", err)
+	}
+
 	h.timers = newTimers(q)
+	h.collectMetrics()
 	// setup hosts
-	h.setupHosts(adminTok)
+	h.setupHosts()
 	// set up any timed triggers
-	h.launchTimedTriggers(storage)
+	h.timers.sync(timedTriggers(h.config, storage))
 	// hub subscribes to its own queue
 	h.queue.Register(h)
+	h.queue.Register(h.streams)
 	// start checking the pending queue
 	go h.serviceLists()
+	// and removing old workspaces
+	go h.janitor()
 
 	return h
 }
@@ -118,6 +210,10 @@ func New(host, tags, adminTok string, c *config.Config, storage store.Store, q *
 // Notify is called whenever an event is sent to the hub, satisfying event.Observer.
 // This is the central dispatch of the two main event types adopted and un-adopted.
 func (h *Hub) Notify(e event.Event) {
+	// system events not in a run, like a config change, are only for the other observers
+	if e.IsSystem() && !e.RunRef.Adopted() {
+		return
+	}
 	// if the event has not been previously adopted in any pending run then it is a trigger event
 	if !e.RunRef.Adopted() {
 		err := h.pendFlowFromTrigger(e)
@@ -130,6 +226,11 @@ func (h *Hub) Notify(e event.Event) {
 	h.dispatchToActive(e)
 }
 
+// Store returns the store the hub persists its state in
+func (h *Hub) Store() store.Store {
+	return h.store
+}
+
 // HostID returns the id for this host
 func (h *Hub) HostID() string {
 	return h.hostID
@@ -140,10 +241,29 @@ func (h *Hub) Tags() []string {
 	return h.tags
 }
 
+// MaxRuns returns the maximum number of concurrent runs this host accepts, 0 is unlimited
+func (h *Hub) MaxRuns() int {
+	return h.maxRuns
+}
+
+// ActiveRuns returns the number of runs currently active on this host
+func (h *Hub) ActiveRuns() int {
+	return len(h.runs.activeFlows())
+}
+
+// FreeDisk returns the bytes available in the workspace root
+func (h *Hub) FreeDisk() uint64 {
+	free, err := path.FreeSpace(h.Config().Common.WorkspaceRoot)
+	if err != nil {
+		log.Debug("could not get free disk space", err)
+	}
+	return free
+}
+
 // AllClientRuns queries all hosts for their summaries for the given run ID
 func (h *Hub) AllClientRuns(flowID string) client.RunSummaries {
 	s := client.RunSummaries{}
-	for _, host := range h.hosts {
+	for _, host := range h.hostList() {
 		summaries := host.GetRuns(flowID)
 		s.Append(summaries)
 	}
@@ -152,7 +272,7 @@ func (h *Hub) AllClientRuns(flowID string) client.RunSummaries {
 
 // AllClientFindRun queries all hosts for the specified run
 func (h *Hub) AllClientFindRun(flowID, runID string) *client.Run {
-	for _, host := range h.hosts {
+	for _, host := range h.hostList() {
 		run := host.FindRun(flowID, runID)
 		if run != nil {
 			return run
@@ -161,20 +281,50 @@ func (h *Hub) AllClientFindRun(flowID, runID string) *client.Run {
 	return nil
 }
 
+// AllClientCancelRun asks all hosts to cancel the run, returning true if any of them had it
+func (h *Hub) AllClientCancelRun(flowID, runID, user string) bool {
+	for _, host := range h.hostList() {
+		ok, err := host.CancelRun(flowID, runID, user)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
 // AllHosts returns all the hosts
 func (h *Hub) AllHosts() map[string]client.HostConfig {
-	h.Lock()
-	defer h.Unlock()
 	r := map[string]client.HostConfig{}
-	for _, host := range h.hosts {
+	for _, host := range h.hostList() {
 		c := host.GetConfig()
+		if c.HostID == "" {
+			continue // not heard from it yet
+		}
 		r[c.HostID] = c
 	}
 	return r
 }
 
-// Config returns the config for this hub
+// HostAddrs returns the addresses of all the known hosts, as configured or discovered
+func (h *Hub) HostAddrs() []string {
+	h.RLock()
+	defer h.RUnlock()
+	addrs := make([]string, 0, len(h.hosts))
+	for a := range h.hosts {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Config returns the current config for this hub
 func (h *Hub) Config() config.Config {
+	h.confMu.RLock()
+	defer h.confMu.RUnlock()
 	return h.config
 }
 
@@ -183,6 +333,11 @@ func (h *Hub) AllRuns(id string) (pending Runs, active Runs, archive Runs) {
 	return h.runs.allRuns(id)
 }
 
+// Pends returns a copy of the pending runs on this host.
+func (h *Hub) Pends() []Pend {
+	return h.runs.allPends()
+}
+
 // FindRun returns an individual run as given by the flow and run.
 func (h *Hub) FindRun(flowID, runID string) *Run {
 	return h.runs.find(flowID, runID)
@@ -193,33 +348,122 @@ func (h *Hub) Queue() *event.Queue {
 	return h.queue
 }
 
-func (h *Hub) setupHosts(adminTok string) {
+// setupHosts adds the initial set of hosts and if the discovery mechanism can change the
+// hosts keeps them up to date in the background.
+func (h *Hub) setupHosts() {
+	c := h.Config().Common
+	d, period, err := newDiscoverer(c.Discovery, c.Hosts)
+	if err != nil {
+		log.Error("host discovery config problem - using static hosts only:", err)
+		d, period = staticHosts(c.Hosts), 0
+	}
+	h.discover(d)
+	if period == 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(period) {
+			h.discover(d)
+		}
+	}()
+}
+
+// discover asks d for the current hosts and syncs them with the hub hosts,
+// any statically configured hosts are always included.
+func (h *Hub) discover(d Discoverer) {
+	addrs, err := d.Hosts()
+	if err != nil {
+		log.Error("host discovery failed:", err)
+		return
+	}
+	h.syncHosts(append(addrs, h.Config().Common.Hosts...))
+}
+
+// syncHosts adds any host in addrs that is not already known, and removes any known host
+// not in addrs, starting and stopping the host clients as needed.
+func (h *Hub) syncHosts(addrs []string) (added, removed []string) {
 	h.Lock()
 	defer h.Unlock()
-	// TODO - consider host discovery via various mechanisms
-	// e.g. etcd, dns, env vars or direct k8s api
-	for _, hostAddr := range h.config.Common.Hosts {
-		log.Debug("connecting to host", hostAddr)
-		addr := hostAddr + h.config.Common.BaseURL
-		h.hosts = append(h.hosts, client.New(addr, adminTok))
+	want := map[string]bool{}
+	for _, a := range addrs {
+		want[a] = true
+		if _, ok := h.hosts[a]; ok {
+			continue
+		}
+		log.Debug("connecting to host", a)
+		h.hosts[a] = client.New(a+h.Config().Common.BaseURL, h.creds)
+		added = append(added, a)
+	}
+	for a, host := range h.hosts {
+		if want[a] {
+			continue
+		}
+		log.Debug("removing host", a)
+		host.Stop()
+		delete(h.hosts, a)
+		removed = append(removed, a)
+	}
+	return added, removed
+}
+
+// hostList returns the current hosts in a stable order
+func (h *Hub) hostList() []*client.FloeHost {
+	h.RLock()
+	defer h.RUnlock()
+	addrs := make([]string, 0, len(h.hosts))
+	for a := range h.hosts {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	hosts := make([]*client.FloeHost, len(addrs))
+	for i, a := range addrs {
+		hosts[i] = h.hosts[a]
+	}
+	return hosts
+}
+
+// isSelf returns true if the host config is this host
+func (h *Hub) isSelf(c client.HostConfig) bool {
+	return c.HostID == h.hostID
+}
+
+// Peers returns the configs of all the other hosts in the cluster, excluding this host
+func (h *Hub) Peers() []client.HostConfig {
+	peers := []client.HostConfig{}
+	for _, host := range h.hostList() {
+		c := host.GetConfig()
+		if c.HostID == "" || h.isSelf(c) {
+			continue
+		}
+		peers = append(peers, c)
 	}
+	return peers
 }
 
-func (h *Hub) launchTimedTriggers(storage store.Store) {
-	for _, f := range h.config.Flows {
+// timedTriggers returns the timers for the timer and poll-git triggers of the flows in c
+func timedTriggers(c config.Config, storage store.Store) []*timer {
+	tims := []*timer{}
+	for _, f := range c.Flows {
 		for _, t := range f.Triggers {
 			ref := config.FlowRef{ID: f.ID, Ver: f.Ver}
+			var tim *timer
 			switch t.Type {
 			case "timer":
-				h.timers.register(ref, t.ID, t.Opts, startFlowTrigger)
+				tim = newTimer(ref, t.ID, t.Opts, startFlowTrigger)
 			case "poll-git":
-				rp := newRepoPoller(storage, t.ID, h.config.Common.GitKey, t.Opts)
+				rp := newRepoPoller(storage, t.ID, c.Common.GitKey, t.Opts)
 				if rp == nil {
 					log.Errorf("<%s> - could not set up repo poller for trigger: %s", ref, t.ID)
 					continue
 				}
-				h.timers.register(ref, t.ID, t.Opts, rp.timer)
+				tim = newTimer(ref, t.ID, t.Opts, rp.timer)
+				tim.gitKey = c.Common.GitKey
+			default:
+				continue
 			}
+			tim.typ = t.Type
+			tims = append(tims, tim)
 		}
 	}
+	return tims
 }
@@ -0,0 +1,202 @@
+package hub
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/log"
+)
+
+const (
+	streamBacklog = 5000             // the most recent events kept for each run so a stream can resume
+	streamKeep    = 10 * time.Minute // how long the events of a run are kept after its last event
+	streamQueue   = 256              // events queued for a subscriber before it is dropped as too slow
+)
+
+// RunSub is a subscription to the events of one run on this host. C is closed after the end event
+// of the run, or if the subscriber falls too far behind, in which case it can subscribe again
+// after the last event it saw.
+type RunSub struct {
+	C   <-chan event.Event
+	c   chan event.Event
+	key string
+	s   *runStreams
+}
+
+// Close ends the subscription
+func (r *RunSub) Close() {
+	r.s.unsubscribe(r)
+}
+
+// runStream is the recent events of a run and its subscribers
+type runStream struct {
+	events []event.Event // in id order
+	subs   map[*RunSub]bool
+	last   time.Time // when the last event arrived
+	ended  bool      // the end event has arrived
+}
+
+// runStreams keeps the recent events of each run that has events on this host, it observes the
+// queue so sees every event.
+type runStreams struct {
+	sync.Mutex
+	runs   map[string]*runStream
+	purged time.Time
+}
+
+func newRunStreams() *runStreams {
+	return &runStreams{
+		runs: map[string]*runStream{},
+	}
+}
+
+func streamKey(flowID, runID string) string {
+	return flowID + "/" + runID
+}
+
+func (s *runStreams) get(key string) *runStream {
+	rs, ok := s.runs[key]
+	if !ok {
+		rs = &runStream{
+			subs: map[*RunSub]bool{},
+			last: time.Now(),
+		}
+		s.runs[key] = rs
+	}
+	return rs
+}
+
+// Notify satisfies event.Observer, it records the run events and passes them on to the subscribers.
+func (s *runStreams) Notify(e event.Event) {
+	if !e.RunRef.Adopted() {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	s.purge(now)
+
+	rs := s.get(streamKey(e.RunRef.FlowRef.ID, e.RunRef.Run.String()))
+	rs.last = now
+
+	// observers are notified concurrently so events can arrive slightly out of order
+	i := len(rs.events)
+	for i > 0 && rs.events[i-1].ID > e.ID {
+		i--
+	}
+	rs.events = append(rs.events, event.Event{})
+	copy(rs.events[i+1:], rs.events[i:])
+	rs.events[i] = e
+	if len(rs.events) > streamBacklog {
+		rs.events = rs.events[len(rs.events)-streamBacklog:]
+	}
+
+	for sub := range rs.subs {
+		select {
+		case sub.c <- e:
+		default:
+			log.Warning("dropping a slow run stream subscriber for", e.RunRef)
+			close(sub.c)
+			delete(rs.subs, sub)
+			continue
+		}
+		if e.Tag == tagEndFlow {
+			close(sub.c)
+			delete(rs.subs, sub)
+		}
+	}
+	if e.Tag == tagEndFlow {
+		rs.ended = true
+	}
+}
+
+// purge forgets the runs that have had no events and no subscribers for a while
+func (s *runStreams) purge(now time.Time) {
+	if now.Sub(s.purged) < time.Minute {
+		return
+	}
+	s.purged = now
+	for k, rs := range s.runs {
+		if len(rs.subs) == 0 && now.Sub(rs.last) > streamKeep {
+			delete(s.runs, k)
+		}
+	}
+}
+
+// subscribe returns the recorded events after the event id and a subscription to the later events
+func (s *runStreams) subscribe(flowID, runID string, after int64) ([]event.Event, *RunSub) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := streamKey(flowID, runID)
+	rs := s.get(key)
+	c := make(chan event.Event, streamQueue)
+	sub := &RunSub{C: c, c: c, key: key, s: s}
+	if rs.ended {
+		close(c)
+	} else {
+		rs.subs[sub] = true
+	}
+
+	i := sort.Search(len(rs.events), func(i int) bool {
+		return rs.events[i].ID > after
+	})
+	past := make([]event.Event, len(rs.events)-i)
+	copy(past, rs.events[i:])
+	return past, sub
+}
+
+func (s *runStreams) unsubscribe(sub *RunSub) {
+	s.Lock()
+	defer s.Unlock()
+	rs, ok := s.runs[sub.key]
+	if !ok || !rs.subs[sub] {
+		return
+	}
+	close(sub.c)
+	delete(rs.subs, sub)
+	rs.last = time.Now()
+}
+
+// SubscribeRun returns the recent events of the run on this host with an id after the given id,
+// and a subscription to its later events. Close the subscription when done with it.
+func (h *Hub) SubscribeRun(flowID, runID string, after int64) ([]event.Event, *RunSub) {
+	return h.streams.subscribe(flowID, runID, after)
+}
+
+// LogNodes returns the ids of the exec nodes of the run that have output, in id order
+func (h *Hub) LogNodes(run *Run) []string {
+	run.RLock()
+	defer run.RUnlock()
+	ids := []string{}
+	for id, ex := range run.ExecNodes {
+		if ex.Log != "" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// AllClientOpenRunStream opens the event stream of the run from whichever other host is
+// executing it, it returns nil if no other host is. The query is passed on to that host.
+func (h *Hub) AllClientOpenRunStream(flowID, runID, lastEventID, query string) io.ReadCloser {
+	for _, host := range h.hostList() {
+		if h.isSelf(host.GetConfig()) {
+			continue
+		}
+		rc, err := host.OpenRunStream(flowID, runID, lastEventID, query)
+		if err != nil {
+			log.Error("could not open run stream", err)
+			continue
+		}
+		if rc != nil {
+			return rc
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package hub
+
+import (
+	"testing"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+)
+
+func streamEvent(run int64, id int64, tag string) event.Event {
+	return event.Event{
+		RunRef: event.RunRef{
+			FlowRef: config.FlowRef{ID: "build"},
+			Run:     event.HostedIDRef{HostID: "h1", ID: run},
+		},
+		Tag: tag,
+		ID:  id,
+	}
+}
+
+func ids(evs []event.Event) []int64 {
+	l := []int64{}
+	for _, e := range evs {
+		l = append(l, e.ID)
+	}
+	return l
+}
+
+func TestRunStreams(t *testing.T) {
+	t.Parallel()
+
+	s := newRunStreams()
+	// events arrive out of order and for other runs
+	for _, e := range []event.Event{
+		streamEvent(1, 1, tagNodeStart),
+		streamEvent(1, 3, tagNodeUpdate),
+		streamEvent(2, 4, tagNodeUpdate),
+		streamEvent(1, 2, tagNodeUpdate),
+		streamEvent(0, 5, "trigger"), // not adopted
+	} {
+		s.Notify(e)
+	}
+
+	past, sub := s.subscribe("build", "h1-1", 1)
+	if got := ids(past); len(got) != 2 || got[0] != 2 || got[1] != 3 {
+		t.Error("bad resumed events", got)
+	}
+	past, all := s.subscribe("build", "h1-1", 0)
+	if len(past) != 3 {
+		t.Error("bad full events", ids(past))
+	}
+	all.Close()
+	all.Close() // closing twice is harmless
+
+	s.Notify(streamEvent(2, 6, tagNodeUpdate))
+	s.Notify(streamEvent(1, 7, tagNodeUpdate))
+	s.Notify(streamEvent(1, 8, tagEndFlow))
+	var got []event.Event
+	for e := range sub.C {
+		got = append(got, e)
+	}
+	if l := ids(got); len(l) != 2 || l[0] != 7 || l[1] != 8 {
+		t.Error("bad subscribed events", l)
+	}
+	sub.Close()
+
+	// subscribing to an ended run gets its events and is closed straight away
+	past, sub = s.subscribe("build", "h1-1", 7)
+	if l := ids(past); len(l) != 1 || l[0] != 8 {
+		t.Error("bad ended events", l)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Error("ended run subscription should be closed")
+	}
+}
+
+func TestRunStreamsSlow(t *testing.T) {
+	t.Parallel()
+
+	s := newRunStreams()
+	_, sub := s.subscribe("build", "h1-1", 0)
+	for i := 1; i <= streamQueue+1; i++ {
+		s.Notify(streamEvent(1, int64(i), tagNodeUpdate))
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != streamQueue {
+		t.Error("slow subscriber should be dropped once its queue is full", n)
+	}
+	// it can resume from the backlog
+	past, sub := s.subscribe("build", "h1-1", int64(n))
+	if l := ids(past); len(l) != 1 || l[0] != int64(streamQueue+1) {
+		t.Error("bad resume", l)
+	}
+	sub.Close()
+}
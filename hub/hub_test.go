@@ -1,23 +1,31 @@
 package hub
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"sync"
 
+	"github.com/floeit/floe/artifact"
+	"github.com/floeit/floe/client"
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/runlog"
+	"github.com/floeit/floe/secret"
 	"github.com/floeit/floe/store"
 )
 
 type task struct {
+	id   string
 	exec func(ws *nt.Workspace, updates chan string)
 }
 
 func (t *task) NodeRef() config.NodeRef {
-	return config.NodeRef{}
+	return config.NodeRef{ID: t.id}
 }
 
 func (t *task) Status(status int) (string, bool) {
@@ -35,11 +43,24 @@ func (t *task) Execute(ws *nt.Workspace, opts nt.Opts, updates chan string) (int
 	return 0, nil, nil
 }
 
+func testLogs(t *testing.T) *runlog.Store {
+	dir, err := ioutil.TempDir("", "floe-logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := runlog.New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
 func TestExecuteNode(t *testing.T) {
 	s := store.NewMemStore()
 	h := Hub{
 		queue: &event.Queue{},
 		runs:  newRunStore(s),
+		logs:  testLogs(t),
 	}
 	h.config.Common.WorkspaceRoot = "/foo/bar"
 	runRef := event.RunRef{
@@ -65,13 +86,131 @@ func TestExecuteNode(t *testing.T) {
 	run := newRun(&Pend{
 		Ref: runRef,
 	})
-	ws := h.prepareForExec(run.Ref, &e, false, nil)
+	ws := h.prepareForExec(run.Ref, &e, false, nil, "")
 	h.executeNode(run, node, e, ws)
 	if !didExec {
 		t.Error("did not execute executor")
 	}
 }
 
+func TestExecuteNodeMasksSecrets(t *testing.T) {
+	t.Parallel()
+
+	h := Hub{
+		queue:   &event.Queue{},
+		runs:    newRunStore(store.NewMemStore()),
+		secrets: secret.Env{"TOK": "s3cret"},
+		logs:    testLogs(t),
+	}
+	node := &task{
+		id: "build",
+		exec: func(ws *nt.Workspace, updates chan string) {
+			v, err := ws.Secrets.Expand("{{secret.TOK}}")
+			if err != nil {
+				t.Error(err)
+			}
+			updates <- "the token is " + v
+		},
+	}
+	e := event.Event{}
+	run := newRun(&Pend{
+		Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: "testflow"},
+			Run:     event.HostedIDRef{HostID: "h1", ID: 5},
+		},
+	})
+	ws := h.prepareForExec(run.Ref, &e, false, nil, "")
+	h.executeNode(run, node, e, ws)
+
+	// the output is in the log store referenced by the run, not in the run
+	k := run.ExecNodes["build"].Log
+	if k == "" {
+		t.Fatal("no log referenced")
+	}
+	pg, err := h.logs.Read(k, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pg.Lines) != 1 || pg.Lines[0] != "the token is "+secret.Mask {
+		t.Error("secret not masked", pg.Lines)
+	}
+}
+
+type artTask struct {
+	task
+	patterns []string
+}
+
+func (t *artTask) ArtifactPatterns() []string {
+	return t.patterns
+}
+
+func TestExecuteNodeKeepsArtifacts(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "floe-arts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	arts, err := artifact.New(filepath.Join(root, "artifacts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Hub{
+		queue:     &event.Queue{},
+		runs:      newRunStore(store.NewMemStore()),
+		logs:      testLogs(t),
+		artifacts: arts,
+	}
+	h.config.Common.WorkspaceRoot = filepath.Join(root, "ws")
+	node := &artTask{
+		task: task{
+			id: "build",
+			exec: func(ws *nt.Workspace, updates chan string) {
+				os.MkdirAll(filepath.Join(ws.BasePath, "out"), 0700)
+				ioutil.WriteFile(filepath.Join(ws.BasePath, "out", "app"), []byte("binary"), 0600)
+			},
+		},
+		patterns: []string{"out/*", "*.tgz"},
+	}
+	e := event.Event{}
+	run := newRun(&Pend{
+		Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: "testflow"},
+			Run:     event.HostedIDRef{HostID: "h1", ID: 5},
+		},
+	})
+	ws := h.prepareForExec(run.Ref, &e, false, nil, "")
+	h.executeNode(run, node, e, ws)
+
+	ex := run.ExecNodes["build"]
+	if !ex.Good {
+		t.Error("node should be good")
+	}
+	if len(ex.Artifacts) != 1 || ex.Artifacts[0].Name != "out/app" || ex.Artifacts[0].Size != 6 {
+		t.Fatal("wrong artifacts", ex.Artifacts)
+	}
+	rc, err := h.OpenArtifact("testflow", "h1-5", "build", "out/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "binary" {
+		t.Error("wrong artifact content", string(b))
+	}
+
+	// the unmatched pattern is noted in the node output
+	pg, err := h.logs.Read(ex.Log, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pg.Lines) != 2 || pg.Lines[0] != "no artifacts matched: *.tgz" {
+		t.Error("wrong output", pg.Lines)
+	}
+}
+
 var in = []byte(`
 common:
     base-url: "/build/api"
@@ -145,7 +284,7 @@ func TestHubEvents(t *testing.T) {
 	q := &event.Queue{}
 
 	// make a new hub
-	New("h1", "master", "admintok", c, s, q)
+	New("h1", "master", client.Creds{Token: "admintok"}, 0, c, s, q)
 
 	to := &testObs{
 		ch: make(chan event.Event, 2),
@@ -315,7 +454,7 @@ func TestHubData(t *testing.T) {
 	q.Register(to)
 
 	// make a new hub
-	New("h2", "master", "admintok", c, s, q)
+	New("h2", "master", client.Creds{Token: "admintok"}, 0, c, s, q)
 
 	// start the flow
 	// add an external event whose opts dont match those needed by git-merge so will error
@@ -392,6 +531,26 @@ func TestMergeEnvOpts(t *testing.T) {
 		t.Error("expand failed", env[1])
 	}
 
+	// env vars from events can not reference secrets
+	o = nt.Opts{
+		"env": []string{"STEAL={{secret.TOK}}", "OK=1"},
+	}
+	mergeEnvOpts(o, []string{"TOK={{secret.TOK}}"})
+	env = o["env"].([]string)
+	if len(env) != 2 || env[0] != "TOK={{secret.TOK}}" || env[1] != "OK=1" {
+		t.Error("event secret reference not dropped", env)
+	}
+
+	// env from a json event arrives as []interface{} and is merged and filtered the same way
+	o = nt.Opts{
+		"env": []interface{}{"X={{secret.prod_key}}", "OK=1", 7},
+	}
+	mergeEnvOpts(o, []string{"DOOF=oops"})
+	env = o["env"].([]string)
+	if len(env) != 2 || env[0] != "DOOF=oops" || env[1] != "OK=1" {
+		t.Error("json event env not merged", env)
+	}
+
 	o = nt.Opts{}
 	mergeEnvOpts(o, []string{"DOOF=oops"})
 	env = o["env"].([]string)
@@ -399,3 +558,125 @@ func TestMergeEnvOpts(t *testing.T) {
 		t.Error("expand failed", env[0])
 	}
 }
+
+func TestCancelRun(t *testing.T) {
+	t.Parallel()
+
+	h := &Hub{
+		hostID: "h1",
+		queue:  &event.Queue{},
+		runs:   newRunStore(store.NewMemStore()),
+	}
+	flow := &config.Flow{ID: "flow", Ver: 1}
+
+	// a pending run is removed from the pending list
+	ref, err := h.runs.addToPending(flow, "h1", config.NodeRef{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := h.CancelRun("flow", ref.Run.String(), "bob")
+	if err != nil || !ok {
+		t.Fatal("pending run should have been cancelled", err)
+	}
+	if len(h.runs.allPends()) != 0 {
+		t.Error("pend should have been removed")
+	}
+
+	// an active run is ended as bad
+	ref, _ = h.runs.addToPending(flow, "h1", config.NodeRef{}, nil)
+	pend, _ := h.runs.findPend("flow", ref.Run.String())
+	h.runs.removePend(pend)
+	if err := h.runs.activate(&pend, "h1"); err != nil {
+		t.Fatal(err)
+	}
+	stopped := h.runs.findActive("flow", ref.Run.String()).stopped()
+	ok, _ = h.CancelRun("flow", ref.Run.String(), "bob")
+	if !ok {
+		t.Fatal("active run should have been cancelled")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("running tasks should have been stopped")
+	}
+	run := h.FindRun("flow", ref.Run.String())
+	if run == nil || !run.Ended || run.Good {
+		t.Errorf("run should have ended bad %+v", run)
+	}
+
+	// nothing to cancel
+	ok, _ = h.CancelRun("flow", ref.Run.String(), "bob")
+	if ok {
+		t.Error("ended run can not be cancelled")
+	}
+}
+
+func TestDataApprovedBy(t *testing.T) {
+	t.Parallel()
+
+	run := newRun(&Pend{})
+	run.updateDataNode("approve", nt.Opts{}, true, "alice")
+	if run.DataNodes["approve"].ApprovedBy != "" {
+		t.Error("incomplete data node should not be approved")
+	}
+	run.updateDataNode("approve", nt.Opts{}, false, "bob")
+	if run.DataNodes["approve"].ApprovedBy != "bob" {
+		t.Errorf("data node should be approved by bob got %s", run.DataNodes["approve"].ApprovedBy)
+	}
+}
+
+func TestDataOtherFlowRun(t *testing.T) {
+	t.Parallel()
+
+	c, err := config.ParseYAML([]byte(`
+flows:
+    - id: b
+      ver: 1
+      tasks:
+        - name: approve
+          type: data
+          opts:
+            form:
+              title: Approve
+              fields:
+                - id: ok
+                  prompt: OK
+                  type: string
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Hub{
+		hostID: "h1",
+		queue:  &event.Queue{},
+		runs:   newRunStore(store.NewMemStore()),
+	}
+	ref, _ := h.runs.addToPending(c.Flows[0], "h1", config.NodeRef{}, nil)
+	pend, _ := h.runs.findPend("b", ref.Run.String())
+	h.runs.removePend(pend)
+	if err := h.runs.activate(&pend, "h1"); err != nil {
+		t.Fatal(err)
+	}
+	data := func(flowID string) event.Event {
+		return event.Event{
+			RunRef:     event.RunRef{FlowRef: config.FlowRef{ID: flowID, Ver: 1}, Run: ref.Run},
+			Tag:        "inbound.data",
+			SourceNode: config.NodeRef{Class: "task", ID: "approve"},
+			Opts:       nt.Opts{"ok": "yes"},
+			Actor:      "mallory",
+		}
+	}
+
+	// an approver of flow a claims the run of flow b is in flow a
+	h.dispatchToActive(data("a"))
+	run := h.FindRun("b", ref.Run.String())
+	if _, ok := run.DataNodes["approve"]; ok {
+		t.Fatal("data for another flow should not reach the run")
+	}
+
+	h.dispatchToActive(data("b"))
+	run = h.FindRun("b", ref.Run.String())
+	if _, ok := run.DataNodes["approve"]; !ok {
+		t.Error("data for the run flow should reach the run")
+	}
+}
@@ -0,0 +1,303 @@
+package hub
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/path"
+)
+
+// cacheGrace is how recently a cached download must not have been written to be removed, so that
+// downloads in progress, or just finished and about to be linked, are left alone.
+const cacheGrace = 10 * time.Minute
+
+// flowFileDir is the directory in the fetch cache the flow files are downloaded to, so the janitor
+// does not take them for fetched downloads.
+const flowFileDir = "flows"
+
+// CleanupStats counts what the janitor has removed since the host started
+type CleanupStats struct {
+	Sweeps      int64 // how many clean ups have run
+	Workspaces  int64 // run workspaces removed
+	CachedFiles int64 // cached downloads removed
+	Reclaimed   int64 // bytes freed
+	LastSweep   time.Time
+}
+
+type cleanups struct {
+	sync.Mutex
+	stats CleanupStats
+}
+
+func (c *cleanups) add(ws, files, bytes int64, at time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.stats.Sweeps++
+	c.stats.Workspaces += ws
+	c.stats.CachedFiles += files
+	c.stats.Reclaimed += bytes
+	c.stats.LastSweep = at
+}
+
+// CleanupStats returns what the janitor has removed from this host
+func (h *Hub) CleanupStats() CleanupStats {
+	h.cleanups.Lock()
+	defer h.cleanups.Unlock()
+	return h.cleanups.stats
+}
+
+// diskItem is a run workspace or a cached download
+type diskItem struct {
+	path string
+	size int64
+	mod  time.Time
+	flow string // the flow and run of a workspace, empty for a cached download
+	run  string
+
+	changed time.Time // when a cached download was last written to, even if its time was set back
+}
+
+// janitor periodically removes the workspaces and cached downloads the retention config says to,
+// the config is read each time round as it may have been reloaded
+func (h *Hub) janitor() {
+	for {
+		r := h.Config().Common.Retention
+		if r.KeepRuns > 0 || r.MaxAgeHours > 0 || r.MinFreeMB > 0 {
+			h.cleanUp(time.Now())
+		}
+		time.Sleep(time.Duration(r.Period) * time.Minute)
+	}
+}
+
+// cleanUp removes the run workspaces beyond the most recent to keep for each flow, and the run
+// workspaces and cached downloads older than the max age. Then if free disk is still too low the
+// oldest of the remaining are removed. The workspaces of active runs, and cached downloads written
+// to within the grace period, are never removed.
+func (h *Hub) cleanUp(now time.Time) {
+	r := h.Config().Common.Retention
+	spaces := h.runWorkspaces() // newest first
+	cached := cachedFiles(h.cachePath)
+	active := h.activeWorkspaces()
+
+	doomed := map[string]diskItem{}
+	var freedSpaces, freedCache int64
+	doom := func(it diskItem) {
+		if _, ok := doomed[it.path]; ok {
+			return
+		}
+		if it.flow != "" && active[it.flow+"/"+it.run] {
+			return
+		}
+		if it.flow == "" && now.Sub(it.changed) < cacheGrace {
+			return
+		}
+		doomed[it.path] = it
+		if it.flow != "" {
+			freedSpaces += it.size
+		} else {
+			freedCache += it.size
+		}
+	}
+
+	if r.KeepRuns > 0 {
+		seen := map[string]int{}
+		for _, ws := range spaces {
+			seen[ws.flow]++
+			if seen[ws.flow] > r.KeepRuns {
+				doom(ws)
+			}
+		}
+	}
+
+	if r.MaxAgeHours > 0 {
+		cutoff := now.Add(-time.Duration(r.MaxAgeHours) * time.Hour)
+		for _, it := range append(spaces, cached...) {
+			if it.mod.Before(cutoff) {
+				doom(it)
+			}
+		}
+	}
+
+	if r.MinFreeMB > 0 {
+		// the cache may be on another disk to the workspaces, then removing workspaces does not
+		// free space for it, nor do its downloads free space for them
+		min := int64(r.MinFreeMB) << 20
+		wsRoot := h.Config().Common.WorkspaceRoot
+		same := path.SameDisk(wsRoot, h.cachePath)
+		wsFree, err := path.FreeSpace(wsRoot)
+		cacheFree := wsFree
+		if err == nil && !same {
+			cacheFree, err = path.FreeSpace(h.cachePath)
+		}
+		if err != nil {
+			log.Error("janitor - could not get free disk space", err)
+		} else {
+			wsShort := func() bool {
+				freed := freedSpaces
+				if same {
+					freed += freedCache
+				}
+				return min-int64(wsFree)-freed > 0
+			}
+			cacheShort := func() bool {
+				freed := freedCache
+				if same {
+					freed += freedSpaces
+				}
+				return min-int64(cacheFree)-freed > 0
+			}
+			// the oldest workspaces go first, then the oldest cached downloads
+			for i := len(spaces) - 1; i >= 0 && wsShort(); i-- {
+				doom(spaces[i])
+			}
+			for _, it := range cached {
+				if !cacheShort() {
+					break
+				}
+				doom(it)
+			}
+			if wsShort() || cacheShort() {
+				log.Warning("janitor - free disk is below min-free-mb after removing all it can")
+			}
+		}
+	}
+
+	nWS, nFiles, bytes := h.remove(doomed)
+	h.cleanups.add(nWS, nFiles, bytes, now)
+	if nWS+nFiles > 0 {
+		log.Infof("janitor - removed %d workspaces and %d cached downloads, reclaimed %d bytes", nWS, nFiles, bytes)
+	}
+}
+
+// remove deletes the workspaces and files. The workspaces are first moved aside while no run can
+// be activated, and only if their run has not since become active.
+func (h *Hub) remove(doomed map[string]diskItem) (nWS, nFiles, bytes int64) {
+	trash := filepath.Join(h.Config().Common.WorkspaceRoot, "trash")
+	if err := os.MkdirAll(trash, 0700); err != nil {
+		log.Error("janitor - can not create the trash", err)
+		return
+	}
+
+	h.execMu.Lock()
+	active := h.activeWorkspaces()
+	for p, it := range doomed {
+		if it.flow == "" || active[it.flow+"/"+it.run] {
+			continue
+		}
+		dst := filepath.Join(trash, fmt.Sprintf("%s-%s-%d", it.flow, it.run, time.Now().UnixNano()))
+		if err := os.Rename(p, dst); err != nil {
+			log.Error("janitor - can not move the workspace", p, err)
+			continue
+		}
+		nWS++
+		bytes += it.size
+	}
+	h.execMu.Unlock()
+
+	for p, it := range doomed {
+		if it.flow != "" {
+			continue
+		}
+		if err := os.Remove(p); err != nil {
+			log.Error("janitor - can not remove the cached download", p, err)
+			continue
+		}
+		nFiles++
+		bytes += it.size
+	}
+
+	// this also removes anything left by an earlier failed clean up
+	if err := os.RemoveAll(trash); err != nil {
+		log.Error("janitor - can not empty the trash", err)
+	}
+	return nWS, nFiles, bytes
+}
+
+// activeWorkspaces returns the flow/run of all the active runs
+func (h *Hub) activeWorkspaces() map[string]bool {
+	active := map[string]bool{}
+	for _, ref := range h.runs.activeRefs() {
+		active[ref.FlowRef.ID+"/"+ref.Run.String()] = true
+	}
+	return active
+}
+
+// runWorkspaces returns the workspaces of individual runs newest first, the single workspaces of
+// flows that reuse their space are not included.
+func (h *Hub) runWorkspaces() []diskItem {
+	root := filepath.Join(h.Config().Common.WorkspaceRoot, "spaces")
+	flows, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	items := []diskItem{}
+	for _, f := range flows {
+		if !f.IsDir() {
+			continue
+		}
+		runs, err := ioutil.ReadDir(filepath.Join(root, f.Name(), "ws"))
+		if err != nil {
+			continue
+		}
+		for _, r := range runs {
+			if !r.IsDir() || r.Name() == "single" {
+				continue
+			}
+			p := filepath.Join(root, f.Name(), "ws", r.Name())
+			items = append(items, diskItem{
+				path: p,
+				size: dirSize(p),
+				mod:  r.ModTime(),
+				flow: f.Name(),
+				run:  r.Name(),
+			})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].mod.After(items[j].mod)
+	})
+	return items
+}
+
+// cachedFiles returns the fetched downloads in the fetch cache oldest first. They are the files at
+// its top level, the directories below it hold the artifact and flow file caches.
+func cachedFiles(root string) []diskItem {
+	fis, err := ioutil.ReadDir(root)
+	if err != nil {
+		return nil
+	}
+	items := []diskItem{}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		items = append(items, diskItem{
+			path:    filepath.Join(root, fi.Name()),
+			size:    fi.Size(),
+			mod:     fi.ModTime(),
+			changed: path.ChangeTime(fi),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].mod.Before(items[j].mod)
+	})
+	return items
+}
+
+// dirSize returns the total size of the files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,87 @@
+package hub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/store"
+)
+
+func TestCleanUp(t *testing.T) {
+	t.Parallel()
+
+	root, err := ioutil.TempDir("", "floe-janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	now := time.Now()
+	mk := func(p string, age time.Duration) {
+		p = filepath.Join(root, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte("0123456789"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		for ; p != root; p = filepath.Dir(p) {
+			os.Chtimes(p, now.Add(-age), now.Add(-age))
+		}
+	}
+	mk("spaces/build/ws/h1-1/out", 5*time.Hour)
+	mk("spaces/build/ws/h1-2/out", 4*time.Hour)
+	mk("spaces/build/ws/h1-3/out", 3*time.Hour)
+	mk("spaces/build/ws/h1-4/out", time.Hour)
+	mk("spaces/build/ws/single/out", 50*time.Hour)
+	mk("spaces/deploy/ws/h1-5/out", 49*time.Hour) // the only one but too old
+	mk("spaces/deploy/ws/h1-6/out", 60*time.Hour) // too old but active
+	mk("fetch_cache/old.tgz", 49*time.Hour)
+	mk("fetch_cache/new.tgz", time.Hour)
+	mk("fetch_cache/artifacts/0123", 49*time.Hour) // not downloads so never removed
+	mk("fetch_cache/flows/flow.yml", 49*time.Hour)
+
+	h := Hub{
+		runs:      newRunStore(store.NewMemStore()),
+		cachePath: filepath.Join(root, "fetch_cache"),
+	}
+	h.config.Common.WorkspaceRoot = root
+	h.config.Common.Retention = config.RetentionConfig{KeepRuns: 2, MaxAgeHours: 48}
+	h.runs.active = append(h.runs.active, newRun(&Pend{
+		Ref: event.RunRef{
+			FlowRef: config.FlowRef{ID: "deploy"},
+			Run:     event.HostedIDRef{HostID: "h1", ID: 6},
+		},
+	}))
+
+	// the old download was only just written, with its time set back, so is kept for now
+	h.cleanUp(now)
+	if _, err := os.Stat(filepath.Join(root, "fetch_cache/old.tgz")); err != nil && runtime.GOOS == "linux" {
+		t.Error("should have kept the download in its grace period", err)
+	}
+
+	h.cleanUp(now.Add(cacheGrace + time.Minute))
+
+	for _, p := range []string{"spaces/build/ws/h1-1", "spaces/build/ws/h1-2", "spaces/deploy/ws/h1-5", "fetch_cache/old.tgz", "trash"} {
+		if _, err := os.Stat(filepath.Join(root, p)); !os.IsNotExist(err) {
+			t.Error("should have removed", p)
+		}
+	}
+	for _, p := range []string{"spaces/build/ws/h1-3", "spaces/build/ws/h1-4", "spaces/build/ws/single", "spaces/deploy/ws/h1-6",
+		"fetch_cache/new.tgz", "fetch_cache/artifacts/0123", "fetch_cache/flows/flow.yml"} {
+		if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+			t.Error("should have kept", p, err)
+		}
+	}
+
+	st := h.CleanupStats()
+	if st.Sweeps != 2 || st.Workspaces != 3 || st.CachedFiles != 1 || st.Reclaimed != 40 {
+		t.Errorf("wrong stats %+v", st)
+	}
+}
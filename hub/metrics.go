@@ -0,0 +1,73 @@
+package hub
+
+import (
+	"time"
+
+	"github.com/floeit/floe/metrics"
+)
+
+var (
+	runSeconds = metrics.NewHistogram("floe_run_duration_seconds",
+		"How long runs executed on this host took, by flow and result.", metrics.LongBuckets, "flow", "result")
+	nodeSeconds = metrics.NewHistogram("floe_node_duration_seconds",
+		"How long task nodes executed on this host took, by flow, node and result.", metrics.LongBuckets, "flow", "node", "result")
+	triggerFires = metrics.NewCounter("floe_trigger_fires_total",
+		"Times the timer and poll triggers fired, by trigger type.", "type")
+	triggerFailures = metrics.NewCounter("floe_trigger_failures_total",
+		"Times the timer and poll triggers failed, by trigger type.", "type")
+)
+
+// result is the label value of a good or bad run or node
+func result(good bool) string {
+	if good {
+		return "good"
+	}
+	return "bad"
+}
+
+// collectMetrics registers the metrics got from the state of the hub when they are scraped
+func (h *Hub) collectMetrics() {
+	metrics.Default.CollectGauges("floe_pending_runs", "Runs waiting to be dispatched.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(h.runs.allPends()))}}
+	})
+	metrics.Default.CollectGauges("floe_pending_oldest_seconds",
+		"How long the oldest pending run has been waiting.", func() []metrics.Sample {
+			var age float64
+			now := time.Now()
+			for _, p := range h.runs.allPends() {
+				if p.Added.IsZero() {
+					continue
+				}
+				if a := now.Sub(p.Added).Seconds(); a > age {
+					age = a
+				}
+			}
+			return []metrics.Sample{{Value: age}}
+		})
+	metrics.Default.CollectGauges("floe_active_runs", "Runs executing on this host, by flow.", func() []metrics.Sample {
+		flows := map[string]float64{}
+		for _, ref := range h.runs.activeFlows() {
+			flows[ref.ID]++
+		}
+		samples := []metrics.Sample{}
+		for id, n := range flows {
+			samples = append(samples, metrics.Sample{Labels: []string{id}, Value: n})
+		}
+		return samples
+	}, "flow")
+
+	// what the janitor has removed
+	cleanup := func(v func(s CleanupStats) int64) func() []metrics.Sample {
+		return func() []metrics.Sample {
+			return []metrics.Sample{{Value: float64(v(h.CleanupStats()))}}
+		}
+	}
+	metrics.Default.CollectCounters("floe_cleanup_sweeps_total", "Clean ups the janitor has run.",
+		cleanup(func(s CleanupStats) int64 { return s.Sweeps }))
+	metrics.Default.CollectCounters("floe_cleanup_workspaces_removed_total", "Run workspaces the janitor removed.",
+		cleanup(func(s CleanupStats) int64 { return s.Workspaces }))
+	metrics.Default.CollectCounters("floe_cleanup_cached_files_removed_total", "Cached downloads the janitor removed.",
+		cleanup(func(s CleanupStats) int64 { return s.CachedFiles }))
+	metrics.Default.CollectCounters("floe_cleanup_reclaimed_bytes_total", "Bytes freed by the janitor.",
+		cleanup(func(s CleanupStats) int64 { return s.Reclaimed }))
+}
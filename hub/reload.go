@@ -0,0 +1,83 @@
+package hub
+
+import (
+	"reflect"
+
+	"github.com/floeit/floe/config"
+	nt "github.com/floeit/floe/config/nodetype"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/log"
+)
+
+// tagConfigChanged is issued when the config has been reloaded
+const tagConfigChanged = "sys.config.changed"
+
+// Reload swaps in the flows of the already parsed and validated config c, and re-registers the
+// timers and pollers of any triggers that were added, removed or changed. Pending and active runs
+// keep the flow config they were created with. Only the git-key, sandbox, retention, health and
+// drain-grace common settings are reloaded, the others need a restart. The actor is the user
+// that asked for the reload, if any. It returns the ids of the flows that were added, removed
+// or changed.
+func (h *Hub) Reload(c *config.Config, actor string) (added, removed, changed []string) {
+	c.Defaults()
+
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	h.confMu.Lock()
+	next := *c
+	next.Common = h.config.Common
+	next.Common.GitKey = c.Common.GitKey
+	next.Common.Sandbox = c.Common.Sandbox
+	next.Common.Retention = c.Common.Retention
+	next.Common.Health = c.Common.Health
+	next.Common.DrainGrace = c.Common.DrainGrace
+
+	added, removed, changed = diffFlows(h.config.Flows, next.Flows)
+	h.config = next
+	h.confMu.Unlock()
+
+	// synced once the config is unlocked, nothing waiting on the timers can then hold up the hub
+	timers := h.timers.sync(timedTriggers(next, h.store))
+
+	log.Infof("config reloaded - flows added: %v, removed: %v, changed: %v - triggers re-registered: %v",
+		added, removed, changed, timers)
+
+	h.queue.Publish(event.Event{
+		Tag: tagConfigChanged,
+		Opts: nt.Opts{
+			"added":   added,
+			"removed": removed,
+			"changed": changed,
+		},
+		Actor: actor,
+	})
+	return added, removed, changed
+}
+
+// diffFlows returns the refs of the flows in next that are not in prev, those in prev not in next
+// and those in both that are different.
+func diffFlows(prev, next []*config.Flow) (added, removed, changed []string) {
+	old := map[config.FlowRef]*config.Flow{}
+	for _, f := range prev {
+		old[config.FlowRef{ID: f.ID, Ver: f.Ver}] = f
+	}
+	for _, f := range next {
+		ref := config.FlowRef{ID: f.ID, Ver: f.Ver}
+		o, ok := old[ref]
+		delete(old, ref)
+		switch {
+		case !ok:
+			added = append(added, ref.String())
+		case !reflect.DeepEqual(o, f):
+			changed = append(changed, ref.String())
+		}
+	}
+	for _, f := range prev {
+		ref := config.FlowRef{ID: f.ID, Ver: f.Ver}
+		if _, ok := old[ref]; ok {
+			removed = append(removed, ref.String())
+		}
+	}
+	return added, removed, changed
+}
@@ -0,0 +1,163 @@
+package hub
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/store"
+)
+
+const reloadFlows = `
+flows:
+    - id: same
+      ver: 1
+      triggers:
+        - name: tick
+          type: timer
+          opts:
+            period: 60
+    - id: changed
+      ver: 1
+      triggers:
+        - name: tick
+          type: timer
+          opts:
+            period: %s
+    - id: %s
+      ver: 1
+      triggers:
+        - name: tick
+          type: timer
+          opts:
+            period: 60
+`
+
+func parseReload(t *testing.T, period, id string) *config.Config {
+	c, err := config.ParseYAML([]byte(fmt.Sprintf(reloadFlows, period, id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	q := &event.Queue{}
+	s := store.NewMemStore()
+	h := &Hub{
+		hostID: "h1",
+		queue:  q,
+		store:  s,
+		runs:   newRunStore(s),
+		timers: newTimers(q),
+	}
+	h.config = *parseReload(t, "60", "removed")
+	h.timers.sync(timedTriggers(h.config, s))
+
+	ch := make(chan event.Event, 1)
+	q.Register(obs(func(e event.Event) {
+		if e.Tag == tagConfigChanged {
+			ch <- e
+		}
+	}))
+
+	// a pend keeps the flow it was created with
+	old := h.config.Flow(config.FlowRef{ID: "changed", Ver: 1})
+	ref, err := h.runs.addToPending(old, "h1", config.NodeRef{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sameTimer := h.timers.list["same-1-tick"]
+
+	added, removed, changed := h.Reload(parseReload(t, "30", "added"), "bob")
+
+	if !reflect.DeepEqual(added, []string{"added-1"}) ||
+		!reflect.DeepEqual(removed, []string{"removed-1"}) ||
+		!reflect.DeepEqual(changed, []string{"changed-1"}) {
+		t.Errorf("bad diff added: %v removed: %v changed: %v", added, removed, changed)
+	}
+	if conf := h.Config(); conf.Flow(config.FlowRef{ID: "added", Ver: 1}) == nil {
+		t.Error("added flow should be in the config")
+	}
+
+	// the timers follow the flows, leaving the unchanged ones alone
+	keys := []string{}
+	for k := range h.timers.list {
+		keys = append(keys, k)
+	}
+	if len(keys) != 3 || h.timers.list["added-1-tick"] == nil || h.timers.list["removed-1-tick"] != nil {
+		t.Errorf("bad timers %v", keys)
+	}
+	if h.timers.list["same-1-tick"] != sameTimer {
+		t.Error("unchanged timer should have been kept")
+	}
+	if p := h.timers.list["changed-1-tick"].period; p != 30 {
+		t.Error("changed timer should have been replaced, period is", p)
+	}
+
+	pend, _ := h.runs.findPend("changed", ref.Run.String())
+	if pend.Flow.Triggers[0].Opts["period"] != 60 {
+		t.Error("pend should have kept its flow")
+	}
+
+	select {
+	case e := <-ch:
+		if e.Actor != "bob" {
+			t.Error("bad actor", e.Actor)
+		}
+	case <-time.After(time.Second):
+		t.Error("no config changed event")
+	}
+}
+
+func TestReloadSlowTrigger(t *testing.T) {
+	t.Parallel()
+
+	q := &event.Queue{}
+	s := store.NewMemStore()
+	h := &Hub{
+		hostID: "h1",
+		queue:  q,
+		store:  s,
+		runs:   newRunStore(s),
+		timers: newTimers(q),
+	}
+	h.config = *parseReload(t, "60", "removed")
+
+	// a trigger that is running, like a git poll waiting on its server, holds up nothing
+	running := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	h.timers.register(config.FlowRef{ID: "slow", Ver: 1}, "poll", nil, func(*event.Queue, *timer) {
+		close(running)
+		<-release
+	})
+	h.timers.mu.Lock()
+	h.timers.list["slow-1-poll"].next = time.Now()
+	h.timers.mu.Unlock()
+	select {
+	case <-running:
+	case <-time.After(3 * time.Second):
+		t.Fatal("trigger not run")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.Reload(parseReload(t, "30", "added"), "")
+		h.Config()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reload blocked by a running trigger")
+	}
+	if !h.checkTimers().OK {
+		t.Error("a running trigger should not stall the timers")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/floeit/floe/artifact"
 	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
@@ -23,6 +24,25 @@ type Pend struct {
 	Flow          *config.Flow   // Flow config as the pend was created
 	TriggeredNode config.NodeRef // which node in the flow that triggered the creation
 	Opts          nt.Opts        // the options that were relevant when the pend was created
+	Lease         Lease          // the host this pend is being handed to, if any
+	Added         time.Time      // when the pend was created
+
+	// unconfirmed is set on pends loaded from the store or adopted from a dead host, some other
+	// host may already hold or have executed it so it must be checked before being dispatched.
+	unconfirmed bool
+}
+
+// Lease records that a pend has been offered to a host. Until the lease expires the pend
+// is only ever offered to that same host, so retrying after an unknown outcome can not
+// start the run on two hosts.
+type Lease struct {
+	HostID  string
+	Expires time.Time
+}
+
+// live returns true if the lease is held and has not expired
+func (l Lease) live(now time.Time) bool {
+	return l.HostID != "" && now.Before(l.Expires)
 }
 
 func (t Pend) String() string {
@@ -42,18 +62,20 @@ type merge struct {
 }
 
 type data struct {
-	Enabled bool      // Enabled is true if the enabling event has occurred
-	Started time.Time // when it became enabled for data
-	Stopped time.Time // when data was fully entered
-	Opts    nt.Opts   // opts from the data event
+	Enabled    bool      // Enabled is true if the enabling event has occurred
+	Started    time.Time // when it became enabled for data
+	Stopped    time.Time // when data was fully entered
+	Opts       nt.Opts   // opts from the data event
+	ApprovedBy string    // the user that supplied the data that completed the node
 }
 
 type exec struct {
-	Started time.Time
-	Stopped time.Time
-	Good    bool     // only valid when Status="finished"
-	Opts    nt.Opts  // opts from the exec event
-	Logs    []string // any output of the node
+	Started   time.Time
+	Stopped   time.Time
+	Good      bool            // only valid when Status="finished"
+	Opts      nt.Opts         // opts from the exec event
+	Log       string          // the key of the output of the node in the log store
+	Artifacts []artifact.File // the files kept from the workspace when the node succeeded
 }
 
 // Run is a specific invocation of a flow
@@ -69,6 +91,8 @@ type Run struct {
 	MergeNodes map[string]merge // the states of the merge nodes by node id
 	DataNodes  map[string]data  // the sates of any data nodes
 	ExecNodes  map[string]exec  // the sates of any exec nodes
+
+	stop chan struct{} // closed when the run ends
 }
 
 func newRun(pend *Pend) *Run {
@@ -110,8 +134,8 @@ func (r *Run) updateMergeNode(nodeID, tag, typ string, waits int, opts nt.Opts)
 	return m.Waits, fired, nt.MergeOpts(m.Opts, nil) // merge copies the opts to avoid mutations
 }
 
-// updateExecNode adds the output line to the log lines for the nod in this run
-func (r *Run) updateExecNode(nodeID string, start, end time.Time, good bool, line string) {
+// updateExecNode sets the start or end of the node, the key of its log and its artifacts in this run
+func (r *Run) updateExecNode(nodeID string, start, end time.Time, good bool, logKey string, arts []artifact.File) {
 	r.Lock()
 	defer r.Unlock()
 	m, ok := r.ExecNodes[nodeID]
@@ -127,14 +151,18 @@ func (r *Run) updateExecNode(nodeID string, start, end time.Time, good bool, lin
 		m.Good = good
 	}
 
-	if line != "" {
-		m.Logs = append(m.Logs, line)
+	if logKey != "" {
+		m.Log = logKey
+	}
+	if arts != nil {
+		m.Artifacts = arts
 	}
 	r.ExecNodes[nodeID] = m
 }
 
-// updateDataNode adds the opts form description
-func (r *Run) updateDataNode(nodeID string, opts nt.Opts, enabled bool) {
+// updateDataNode adds the opts form description, if the node is complete the user
+// that supplied the data is recorded.
+func (r *Run) updateDataNode(nodeID string, opts nt.Opts, enabled bool, user string) {
 	r.Lock()
 	defer r.Unlock()
 	m, ok := r.DataNodes[nodeID]
@@ -144,18 +172,40 @@ func (r *Run) updateDataNode(nodeID string, opts nt.Opts, enabled bool) {
 	m.Opts = opts
 	if !enabled {
 		m.Stopped = time.Now()
+		m.ApprovedBy = user
 	}
 	m.Enabled = enabled
 	m.Started = time.Now() // TODO move this to the hub - when we can handle data input in the run
 	r.DataNodes[nodeID] = m
 }
 
+// stopped returns a channel that is closed when the run ends, for stopping its running tasks
+func (r *Run) stopped() <-chan struct{} {
+	r.Lock()
+	defer r.Unlock()
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+		if r.Ended {
+			close(r.stop)
+		}
+	}
+	return r.stop
+}
+
 func (r *Run) end(good bool) {
 	r.Lock()
 	defer r.Unlock()
 	r.EndTime = time.Now()
 	r.Ended = true
 	r.Good = good
+	if r.stop == nil {
+		r.stop = make(chan struct{})
+	}
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
 	// mark all data nodes disabled
 	for k, n := range r.DataNodes {
 		n.Enabled = false
@@ -227,6 +277,10 @@ func newRunStore(store store.Store) *RunStore {
 	if err := r.pending.Load(pendingKey, r.store); err != nil {
 		log.Error("can not load pending list", err)
 	}
+	// we may have been down long enough for our pends to be adopted elsewhere
+	for _, p := range r.pending.Pends {
+		p.unconfirmed = true
+	}
 	if err := r.active.Load(activeKey, r.store); err != nil {
 		log.Error("can not load active list", err)
 	}
@@ -237,18 +291,38 @@ func newRunStore(store store.Store) *RunStore {
 	return r
 }
 
-// findActiveRun returns the run from the active list that matches the given ref
-func (r *RunStore) findActiveRun(ref event.HostedIDRef) (int, *Run) {
+// findActiveRun returns the run from the active list that matches the given ref, the flow
+// must match as well as the run so an event for one flow can never reach a run of another
+func (r *RunStore) findActiveRun(ref event.RunRef) (int, *Run) {
 	r.RLock()
 	defer r.RUnlock()
 	for i, run := range r.active {
-		if run.Ref.Run.Equals(ref) {
+		if run.Ref.FlowRef.ID == ref.FlowRef.ID && run.Ref.Run.Equals(ref.Run) {
 			return i, run
 		}
 	}
 	return -1, nil
 }
 
+// findActive returns the active run matching the flow and run id
+func (r *RunStore) findActive(flowID, runID string) *Run {
+	r.RLock()
+	defer r.RUnlock()
+	return r.active.find(flowID, runID)
+}
+
+// findPend returns a copy of the pend matching the flow and run id
+func (r *RunStore) findPend(flowID, runID string) (Pend, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	for _, p := range r.pending.Pends {
+		if p.Ref.FlowRef.ID == flowID && p.Ref.Run.String() == runID {
+			return *p, true
+		}
+	}
+	return Pend{}, false
+}
+
 func (r *RunStore) updateMergeNode(run *Run, nodeID, tag, typ string, waits int, opts nt.Opts) (map[string]bool, bool, nt.Opts) {
 	r.Lock()
 	defer r.Unlock()
@@ -260,19 +334,20 @@ func (r *RunStore) updateMergeNode(run *Run, nodeID, tag, typ string, waits int,
 	return waitsDone, fired, o
 }
 
-// TODO - consider buffering these writes if the updates come in fast
-func (r *RunStore) updateExecNode(run *Run, nodeID string, start, end time.Time, good bool, line string) {
+// updateExecNode saves the start or end of the node, the output lines go to the log store and the
+// artifact files to the artifact store, not the run.
+func (r *RunStore) updateExecNode(run *Run, nodeID string, start, end time.Time, good bool, logKey string, arts []artifact.File) {
 	r.Lock()
 	defer r.Unlock()
 
-	run.updateExecNode(nodeID, start, end, good, line)
+	run.updateExecNode(nodeID, start, end, good, logKey, arts)
 	if err := r.active.Save(activeKey, r.store); err != nil {
 		log.Error("could not save exe update", activeKey, err)
 	}
 }
 
-func (r *RunStore) updateDataNode(run *Run, nodeID string, opts nt.Opts, enabled bool) {
-	run.updateDataNode(nodeID, opts, enabled)
+func (r *RunStore) updateDataNode(run *Run, nodeID string, opts nt.Opts, enabled bool, user string) {
+	run.updateDataNode(nodeID, opts, enabled, user)
 	r.Lock()
 	defer r.Unlock()
 	if err := r.active.Save(activeKey, r.store); err != nil {
@@ -288,7 +363,7 @@ func (r *RunStore) end(run *Run, good bool) bool {
 	run.end(good)
 	r.Unlock()
 
-	i, run := r.findActiveRun(run.Ref.Run)
+	i, run := r.findActiveRun(run.Ref)
 	if run == nil {
 		return false
 	}
@@ -311,6 +386,19 @@ func (r *RunStore) end(run *Run, good bool) bool {
 	return true
 }
 
+// save persists all the run lists
+func (r *RunStore) save() error {
+	r.Lock()
+	defer r.Unlock()
+	if err := r.pending.Save(pendingKey, r.store); err != nil {
+		return err
+	}
+	if err := r.active.Save(activeKey, r.store); err != nil {
+		return err
+	}
+	return r.archive.Save(archiveKey, r.store)
+}
+
 // addToPending adds the active configs to pending list, and returns the run id
 func (r *RunStore) addToPending(flow *config.Flow, hostID string, trig config.NodeRef, opts nt.Opts) (event.RunRef, error) {
 	r.Lock()
@@ -328,12 +416,81 @@ func (r *RunStore) addToPending(flow *config.Flow, hostID string, trig config.No
 		Flow:          flow,
 		TriggeredNode: trig,
 		Opts:          opts,
+		Added:         time.Now(),
 	}
 	r.pending.Pends = append(r.pending.Pends, t)
 
 	return t.Ref, r.pending.Save(pendingKey, r.store)
 }
 
+// adopt adds the pend, created by another host, to our pending list unless it is already there.
+// Adopted pends are unconfirmed as the original host may have dispatched it before it died.
+func (r *RunStore) adopt(pend Pend) (bool, error) {
+	r.Lock()
+	defer r.Unlock()
+	for _, p := range r.pending.Pends {
+		if p.equal(pend) {
+			return false, nil
+		}
+	}
+	pend.unconfirmed = true
+	r.pending.Pends = append(r.pending.Pends, &pend)
+	return true, r.pending.Save(pendingKey, r.store)
+}
+
+// setLease sets the lease on the pending run, the lease is persisted so that it survives a restart.
+func (r *RunStore) setLease(ref event.RunRef, lease Lease) error {
+	r.Lock()
+	defer r.Unlock()
+	for _, p := range r.pending.Pends {
+		if p.Ref.Equal(ref) {
+			p.Lease = lease
+			return r.pending.Save(pendingKey, r.store)
+		}
+	}
+	return nil
+}
+
+// confirm marks the pend as known not to be held by any other host
+func (r *RunStore) confirm(ref event.RunRef) {
+	r.Lock()
+	defer r.Unlock()
+	for _, p := range r.pending.Pends {
+		if p.Ref.Equal(ref) {
+			p.unconfirmed = false
+		}
+	}
+}
+
+// hasRun returns true if the run is active or archived on this host
+func (r *RunStore) hasRun(ref event.RunRef) bool {
+	r.RLock()
+	defer r.RUnlock()
+	for _, runs := range []Runs{r.active, r.archive} {
+		for _, run := range runs {
+			if run.Ref.Equal(ref) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// activeConfigs returns the flow configs the currently executing runs were started with
+func (r *RunStore) activeConfigs() []*config.Flow {
+	r.RLock()
+	defer r.RUnlock()
+	res := []*config.Flow{}
+	for _, run := range r.active {
+		if run.Flow == nil {
+			log.Error("Strange that we have an active run without a flow config", run.Ref)
+			continue
+		}
+		res = append(res, run.Flow)
+	}
+	return res
+}
+
 // activeFlows returns all the flowrefs that match those currently executing
 func (r *RunStore) activeFlows() []config.FlowRef {
 	r.RLock()
@@ -345,6 +502,17 @@ func (r *RunStore) activeFlows() []config.FlowRef {
 	return res
 }
 
+// activeRefs returns the refs of all the runs currently executing
+func (r *RunStore) activeRefs() []event.RunRef {
+	r.RLock()
+	defer r.RUnlock()
+	res := make([]event.RunRef, len(r.active))
+	for i, run := range r.active {
+		res[i] = run.Ref
+	}
+	return res
+}
+
 // activate adds the active configs to the active list, saves it, and returns the run id
 func (r *RunStore) activate(pend *Pend, hostID string) error {
 	r.Lock()
@@ -4,10 +4,34 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/floeit/floe/config"
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/exe"
+	"github.com/floeit/floe/path"
+	"github.com/floeit/floe/secret"
 )
 
+// secretProvider returns the provider of secrets looking in the env first then any secrets file.
+// The secret env vars and master key are removed from the env so no command can inherit them.
+func secretProvider(c config.SecretsConfig) (secret.Provider, error) {
+	chain := secret.Chain{secret.TakeEnv(c.EnvPrefix)}
+	key := os.Getenv(c.KeyEnv)
+	os.Unsetenv(c.KeyEnv)
+	if c.File == "" {
+		return chain, nil
+	}
+	p, err := path.Expand(c.File)
+	if err != nil {
+		return nil, err
+	}
+	f, err := secret.OpenFile(p, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(chain, f), nil
+}
+
 // enforceWS make sure there is a matching file system location and returns the workspace object
 // shared will use the 'single' workspace
 func (h *Hub) enforceWS(runRef event.RunRef, single bool) (*nt.Workspace, error) {
@@ -20,12 +44,25 @@ func (h *Hub) enforceWS(runRef event.RunRef, single bool) (*nt.Workspace, error)
 		return nil, err
 	}
 	err = os.MkdirAll(ws.BasePath, 0700)
-	return ws, err
+	if err != nil {
+		return nil, err
+	}
+	// a task run as another user has to be able to get to the workspace, the exec node gives
+	// it the workspace itself
+	if ws.Sandbox.UID != 0 || ws.Sandbox.GID != 0 {
+		root := filepath.Clean(h.Config().Common.WorkspaceRoot)
+		for p := filepath.Dir(ws.BasePath); len(p) > len(root); p = filepath.Dir(p) {
+			if err := os.Chmod(p, 0711); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ws, nil
 }
 
 // getWorkspace returns the appropriate Workspace struct for this flow
 func (h *Hub) getWorkspace(runRef event.RunRef, single bool) (*nt.Workspace, error) {
-	path := filepath.Join(h.config.Common.WorkspaceRoot, "spaces", runRef.FlowRef.ID)
+	path := filepath.Join(h.Config().Common.WorkspaceRoot, "spaces", runRef.FlowRef.ID)
 	if single {
 		path = filepath.Join(path, "ws", "single")
 	} else {
@@ -35,5 +72,26 @@ func (h *Hub) getWorkspace(runRef event.RunRef, single bool) (*nt.Workspace, err
 	return &nt.Workspace{
 		BasePath:   path,
 		FetchCache: h.cachePath,
+		Secrets:    secret.NewResolver(h.secrets),
+		Artifacts:  runArtifacts{h: h, ref: runRef},
+		Cache:      h.caches,
+		Flow:       runRef.FlowRef.ID,
+		Containers: h.containers,
+		Sandbox:    sandbox(h.Config().Common.Sandbox),
 	}, nil
 }
+
+// sandbox returns the guardrails for the exec tasks from the config
+func sandbox(c config.SandboxConfig) exe.Sandbox {
+	return exe.Sandbox{
+		Group:       c.ProcessGroup,
+		CPUSeconds:  c.CPUSeconds,
+		MemoryBytes: c.MemoryMB << 20,
+		OpenFiles:   c.OpenFiles,
+		MaxOutput:   c.MaxOutputMB << 20,
+		UID:         c.UID,
+		GID:         c.GID,
+		CleanEnv:    c.CleanEnv,
+		EnvAllow:    c.EnvAllow,
+	}
+}
@@ -2,7 +2,10 @@ package hub
 
 import (
 	"path/filepath"
+	"reflect"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/floeit/floe/config"
@@ -18,56 +21,130 @@ type timerTrigger func(*event.Queue, *timer)
 type timer struct {
 	flow    config.FlowRef
 	nodeID  string
+	typ     string       // the trigger type
+	gitKey  string       // the git key a poller uses
 	period  int          // time between triggers in seconds
 	next    time.Time    // computed next time to run
 	trigger timerTrigger // the function to fire
 	opts    nt.Opts
+	running bool // the trigger is still running from the last time it was due
+}
+
+func newTimer(flow config.FlowRef, nodeID string, opts nt.Opts, trigger timerTrigger) *timer {
+	period, ok := opts["period"].(int)
+	if !ok {
+		period = 10
+	}
+	return &timer{
+		flow:    flow,
+		nodeID:  nodeID,
+		period:  period,
+		next:    time.Now().UTC().Add(time.Duration(period) * time.Second),
+		trigger: trigger,
+		opts:    opts,
+	}
+}
+
+func (tim *timer) key() string {
+	return tim.flow.String() + "-" + tim.nodeID
+}
+
+// same returns true if tim would trigger the same way as o
+func (tim *timer) same(o *timer) bool {
+	return tim.typ == o.typ && tim.gitKey == o.gitKey && reflect.DeepEqual(tim.opts, o.opts)
 }
 
 type timers struct {
 	mu   sync.RWMutex
 	list map[string]*timer
+
+	lastTick int64 // unix nanos of the last time round the loop, to show it is alive
+}
+
+// alive returns when the timer loop last checked the timers
+func (t *timers) alive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.lastTick))
 }
 
 func newTimers(q *event.Queue) *timers {
 	t := &timers{
-		list: map[string]*timer{},
+		list:     map[string]*timer{},
+		lastTick: time.Now().UnixNano(),
 	}
 
+	// the triggers run in their own goroutines so a slow one, such as a git poll, neither delays
+	// the others nor stops the loop showing it is alive
 	go func() {
 		for now := range time.Tick(time.Second) {
-			t.mu.RLock()
-			for name, tim := range t.list {
-				if !now.After(tim.next) {
-					continue
-				}
-				tim.next = now.Add(time.Duration(tim.period) * time.Second)
-				log.Debugf("<%s> - timer trigger", name)
-				tim.trigger(q, tim)
+			atomic.StoreInt64(&t.lastTick, now.UnixNano())
+			for _, tim := range t.due(now) {
+				go t.fire(q, tim)
 			}
-			t.mu.RUnlock()
 		}
 	}()
 	return t
 }
 
-func (t *timers) register(flow config.FlowRef, nodeID string, opts nt.Opts, trigger timerTrigger) {
-	period, ok := opts["period"].(int)
-	if !ok {
-		period = 10
-	}
+// due returns the timers due to trigger at now, and schedules their next trigger. A timer whose
+// trigger is still running from last time is skipped.
+func (t *timers) due(now time.Time) []*timer {
 	t.mu.Lock()
-	t.list[flow.String()+"-"+nodeID] = &timer{
-		flow:    flow,
-		nodeID:  nodeID,
-		period:  period,
-		next:    time.Now().UTC().Add(time.Duration(period) * time.Second),
-		trigger: trigger,
-		opts:    opts,
+	defer t.mu.Unlock()
+	var due []*timer
+	for _, tim := range t.list {
+		if tim.running || !now.After(tim.next) {
+			continue
+		}
+		tim.next = now.Add(time.Duration(tim.period) * time.Second)
+		tim.running = true
+		due = append(due, tim)
 	}
+	return due
+}
+
+func (t *timers) fire(q *event.Queue, tim *timer) {
+	defer func() {
+		t.mu.Lock()
+		tim.running = false
+		t.mu.Unlock()
+	}()
+	log.Debugf("<%s> - timer trigger", tim.key())
+	tim.trigger(q, tim)
+}
+
+func (t *timers) register(flow config.FlowRef, nodeID string, opts nt.Opts, trigger timerTrigger) {
+	tim := newTimer(flow, nodeID, opts, trigger)
+	t.mu.Lock()
+	t.list[tim.key()] = tim
 	t.mu.Unlock()
 }
 
+// sync replaces the registered timers with want, any timer that is the same as the one already
+// registered keeps its schedule. It returns the keys of the timers that were added, replaced or
+// removed.
+func (t *timers) sync(want []*timer) (changed []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keep := map[string]bool{}
+	for _, tim := range want {
+		key := tim.key()
+		keep[key] = true
+		if old, ok := t.list[key]; ok && old.same(tim) {
+			continue
+		}
+		t.list[key] = tim
+		changed = append(changed, key)
+	}
+	for key := range t.list {
+		if !keep[key] {
+			delete(t.list, key)
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
 func sendTriggerEvent(q *event.Queue, flowRef config.FlowRef, nodeID, typ string, opts nt.Opts) {
 	log.Debugf("<%s> - from %s trigger <%s> added to pending", flowRef, typ, nodeID)
 	q.Publish(event.Event{
@@ -84,6 +161,7 @@ func sendTriggerEvent(q *event.Queue, flowRef config.FlowRef, nodeID, typ string
 }
 
 func startFlowTrigger(q *event.Queue, tim *timer) {
+	triggerFires.Inc("timer")
 	sendTriggerEvent(q, tim.flow, tim.nodeID, "timer", tim.opts)
 }
 
@@ -120,18 +198,29 @@ func newRepoPoller(store store.Store, nodeID, gitKey string, opts nt.Opts) *repo
 }
 
 func (r *repoPoller) timer(q *event.Queue, tim *timer) {
+	triggerFires.Inc("poll-git")
+	failed := false
+	defer func() {
+		if failed {
+			triggerFailures.Inc("poll-git")
+		}
+	}()
+
 	prev, err := r.loadRefs(tim.flow.ID)
 	if err != nil {
+		failed = true
 		log.Errorf("<%s> - could not load previous refs: %s", tim.flow, err)
 	}
 
 	new, ok := git.Ls(log.Log{}, r.url, r.refs, r.exclude, r.gitKey)
 	if !ok {
+		failed = true
 		log.Errorf("<%s> - could not get new refs: %s", tim.flow, err)
 	}
 
 	err = r.saveRefs(tim.flow.ID, *new)
 	if err != nil {
+		failed = true
 		log.Errorf("<%s> - could not save refs: %s", tim.flow, err)
 	}
 
@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -47,6 +48,45 @@ func TestTimers(t *testing.T) {
 	}
 }
 
+func TestSlowTimer(t *testing.T) {
+	t.Parallel()
+
+	q := &event.Queue{}
+	got := make(chan bool, 10)
+	q.Register(obs(func(e event.Event) {
+		if e.Tag == "inbound.timer" {
+			got <- true
+		}
+	}))
+	ts := newTimers(q)
+
+	// a trigger that blocks, like a poll of a git server that does not answer
+	release := make(chan struct{})
+	var calls int32
+	ts.register(config.FlowRef{ID: "slow", Ver: 1}, "poll", nt.Opts{"period": 1}, func(*event.Queue, *timer) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+	})
+	ts.register(config.FlowRef{ID: "fast", Ver: 1}, "timer", nt.Opts{"period": 1}, startFlowTrigger)
+
+	// the other timer still fires, and the loop is still alive
+	for i := 0; i < 2; i++ {
+		select {
+		case <-time.After(time.Second * 3):
+			t.Fatal("blocked by the slow trigger")
+		case <-got:
+		}
+	}
+	if since := time.Since(ts.alive()); since > 1500*time.Millisecond {
+		t.Error("the loop looks stalled", since)
+	}
+	// the slow trigger is not started again while it is still running
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Error("slow trigger overlapped itself", n)
+	}
+	close(release)
+}
+
 func TestDiffRefs(t *testing.T) {
 	old := git.Hashes{
 		RepoURL: "foo",
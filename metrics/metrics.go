@@ -0,0 +1,305 @@
+// Package metrics keeps counters, gauges and histograms and writes them in the Prometheus text
+// exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the types of metric
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// DefBuckets are the histogram buckets in seconds for quick things like requests and saves
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// LongBuckets are the histogram buckets in seconds for slow things like runs and the tasks in them
+var LongBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+// Default is the registry the package level functions use
+var Default = NewRegistry()
+
+// Sample is a value of a collected metric with its label values
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Registry holds the metrics by name
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+type family struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	series  map[string]*series
+	collect func() []Sample // if set the samples are got from this when written
+}
+
+type series struct {
+	values []string
+	value  float64  // the counter or gauge value or the histogram sum
+	counts []uint64 // the histogram counts per bucket, not cumulative
+	count  uint64   // the histogram count
+}
+
+// Counter is a value per set of label values that only goes up
+type Counter struct{ f *family }
+
+// Gauge is a value per set of label values that can go up and down
+type Gauge struct{ f *family }
+
+// Histogram counts observations per set of label values in buckets
+type Histogram struct{ f *family }
+
+// Counter returns the named counter, registering it if needed
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, counterType, labels, nil)}
+}
+
+// Gauge returns the named gauge, registering it if needed
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, gaugeType, labels, nil)}
+}
+
+// Histogram returns the named histogram with the given upper bounds of its buckets, registering
+// it if needed
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, histogramType, labels, buckets)}
+}
+
+// CollectGauges registers a gauge whose samples are got from collect each time the metrics are
+// written, replacing any collector previously registered with the name.
+func (r *Registry) CollectGauges(name, help string, collect func() []Sample, labels ...string) {
+	f := r.register(name, help, gaugeType, labels, nil)
+	f.mu.Lock()
+	f.collect = collect
+	f.mu.Unlock()
+}
+
+// CollectCounters is the same as CollectGauges for values that only go up
+func (r *Registry) CollectCounters(name, help string, collect func() []Sample, labels ...string) {
+	f := r.register(name, help, counterType, labels, nil)
+	f.mu.Lock()
+	f.collect = collect
+	f.mu.Unlock()
+}
+
+func (r *Registry) register(name, help, typ string, labels []string, buckets []float64) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || len(f.labels) != len(labels) {
+			panic("metrics: " + name + " is already registered differently")
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// get returns the series for the label values, the family lock must be held
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has labels %v, got %d values", f.name, f.labels, len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.typ == histogramType {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which must not be negative, to the counter
+func (c *Counter) Add(v float64, values ...string) {
+	c.f.mu.Lock()
+	c.f.get(values).value += v
+	c.f.mu.Unlock()
+}
+
+// Set sets the gauge
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	g.f.get(values).value = v
+	g.f.mu.Unlock()
+}
+
+// Observe adds the observation to the histogram
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	s := h.f.get(values)
+	for i, b := range h.f.buckets {
+		if v <= b {
+			s.counts[i]++
+			break
+		}
+	}
+	s.count++
+	s.value += v
+	h.f.mu.Unlock()
+}
+
+// Since observes the seconds since start
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Write writes all the metrics in the Prometheus text format sorted by name
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	fams := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		fams = append(fams, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	bw := bufio.NewWriter(w)
+	for _, f := range fams {
+		f.write(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	collect := f.collect
+	var ss []*series
+	for _, s := range f.series {
+		c := *s
+		c.counts = append([]uint64(nil), s.counts...)
+		ss = append(ss, &c)
+	}
+	f.mu.Unlock()
+	// collect outside the lock as it may take other locks
+	if collect != nil {
+		ss = nil
+		for _, smp := range collect() {
+			ss = append(ss, &series{values: smp.Labels, value: smp.Value})
+		}
+	}
+	sort.Slice(ss, func(i, j int) bool {
+		return strings.Join(ss[i].values, "\xff") < strings.Join(ss[j].values, "\xff")
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, s := range ss {
+		if f.typ != histogramType {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labels(f.labels, s.values, ""), value(s.value))
+			continue
+		}
+		var cum uint64
+		for i, b := range f.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, value(b)), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labels(f.labels, s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labels(f.labels, s.values, ""), value(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labels(f.labels, s.values, ""), s.count)
+	}
+}
+
+// labels returns the {name="value",...} of the series with the le label of a bucket if given
+func labels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var p []string
+	for i, n := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		p = append(p, n+`="`+escape(v, true)+`"`)
+	}
+	if le != "" {
+		p = append(p, `le="`+le+`"`)
+	}
+	return "{" + strings.Join(p, ",") + "}"
+}
+
+func value(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(rw)
+	})
+}
+
+// NewCounter returns the named counter from the Default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.Counter(name, help, labels...)
+}
+
+// NewGauge returns the named gauge from the Default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.Gauge(name, help, labels...)
+}
+
+// NewHistogram returns the named histogram from the Default registry
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.Histogram(name, help, buckets, labels...)
+}
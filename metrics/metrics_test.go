@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	t.Parallel()
+
+	r := NewRegistry()
+	c := r.Counter("floe_events_total", "Events published.", "prefix")
+	c.Inc("sys")
+	c.Add(2, "task")
+	c.Inc("sys")
+	r.Gauge("floe_up", "Is up.").Set(1)
+	h := r.Histogram("floe_save_seconds", "Save latency.", []float64{0.1, 1}, "store")
+	h.Observe(0.05, "local")
+	h.Observe(0.5, "local")
+	h.Observe(5, "local")
+	r.CollectGauges("floe_active_runs", "Active runs.", func() []Sample {
+		return []Sample{{Labels: []string{`a "quoted" flow`}, Value: 2}}
+	}, "flow")
+
+	// registering again returns the same metric
+	r.Counter("floe_events_total", "Events published.", "prefix").Inc("sys")
+
+	b := &bytes.Buffer{}
+	if err := r.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	exp := `# HELP floe_active_runs Active runs.
+# TYPE floe_active_runs gauge
+floe_active_runs{flow="a \"quoted\" flow"} 2
+# HELP floe_events_total Events published.
+# TYPE floe_events_total counter
+floe_events_total{prefix="sys"} 3
+floe_events_total{prefix="task"} 2
+# HELP floe_save_seconds Save latency.
+# TYPE floe_save_seconds histogram
+floe_save_seconds_bucket{store="local",le="0.1"} 1
+floe_save_seconds_bucket{store="local",le="1"} 2
+floe_save_seconds_bucket{store="local",le="+Inf"} 3
+floe_save_seconds_sum{store="local"} 5.55
+floe_save_seconds_count{store="local"} 3
+# HELP floe_up Is up.
+# TYPE floe_up gauge
+floe_up 1
+`
+	if b.String() != exp {
+		t.Errorf("bad output\n%s", b.String())
+	}
+
+	// a different registration of the same name panics
+	defer func() {
+		if recover() == nil {
+			t.Error("re-registering as a gauge should panic")
+		}
+	}()
+	r.Gauge("floe_events_total", "Events published.", "prefix")
+}
@@ -0,0 +1,17 @@
+package path
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// ChangeTime returns when the file or its attributes last changed, so unlike the modification time
+// it is recent after the times have been set back e.g. by a download keeping the server time.
+func ChangeTime(fi os.FileInfo) time.Time {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fi.ModTime()
+	}
+	return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
+}
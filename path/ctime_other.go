@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package path
+
+import (
+	"os"
+	"time"
+)
+
+// ChangeTime returns the modification time, the change time is only used on linux.
+func ChangeTime(fi os.FileInfo) time.Time {
+	return fi.ModTime()
+}
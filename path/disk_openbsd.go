@@ -0,0 +1,13 @@
+package path
+
+import "syscall"
+
+// FreeSpace returns the number of bytes available to an unprivileged user on the
+// file system containing dir.
+func FreeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.F_bavail) * uint64(st.F_bsize), nil
+}
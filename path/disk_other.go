@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !openbsd && !windows
+// +build !linux,!darwin,!freebsd,!dragonfly,!openbsd,!windows
+
+package path
+
+import "errors"
+
+// FreeSpace is not supported on this platform.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space not supported on this platform")
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package path
+
+import "syscall"
+
+// SameDisk returns true if both directories are on the same file system.
+func SameDisk(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
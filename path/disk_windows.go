@@ -0,0 +1,19 @@
+package path
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// FreeSpace is not supported on windows.
+func FreeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space not supported on windows")
+}
+
+// SameDisk returns true if both directories are on the same volume.
+func SameDisk(a, b string) bool {
+	a, _ = filepath.Abs(a)
+	b, _ = filepath.Abs(b)
+	return strings.EqualFold(filepath.VolumeName(a), filepath.VolumeName(b))
+}
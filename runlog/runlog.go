@@ -0,0 +1,225 @@
+// Package runlog keeps the output of each node of each run in its own append only file, so that
+// output is never rewritten and can be paged through or downloaded whatever its size.
+package runlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when there is no log for a node
+var ErrNotFound = errors.New("log not found")
+
+// maxLine is the longest line returned in a page, longer lines are split
+const maxLine = 64 * 1024
+
+// Page is a set of whole lines from a log
+type Page struct {
+	Offset int64    // the byte offset of the first line
+	Next   int64    // the byte offset to read the next page from
+	Size   int64    // the size of the log when it was read
+	Lines  []string // the lines without their line endings
+}
+
+// Store keeps the logs in files under its root as flow/run/node.log
+type Store struct {
+	sync.Mutex
+	root string
+	open map[string]*appender // the logs being appended to
+}
+
+// appender is a log open for appending and its size
+type appender struct {
+	f    *os.File
+	size int64
+}
+
+// New returns a Store keeping its files below root
+func New(root string) (*Store, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{
+		root: root,
+		open: map[string]*appender{},
+	}, nil
+}
+
+// Key returns the key of the log for the node of the run, used to reference the log from the run
+func Key(flowID, runID, nodeID string) string {
+	return flowID + "/" + runID + "/" + nodeID
+}
+
+// path returns the file for the key, or an error if any part of the key could escape the root
+func (s *Store) path(key string) (string, error) {
+	p := strings.Split(key, "/")
+	if len(p) != 3 {
+		return "", fmt.Errorf("bad log key: %s", key)
+	}
+	for _, id := range p {
+		if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `\`) {
+			return "", fmt.Errorf("bad log key: %s", key)
+		}
+	}
+	return filepath.Join(s.root, p[0], p[1], p[2]+".log"), nil
+}
+
+// Append adds the text as one or more lines to the end of the log, returning the byte offset of
+// the first line it added.
+func (s *Store) Append(key, text string) (int64, error) {
+	s.Lock()
+	defer s.Unlock()
+	a, ok := s.open[key]
+	if !ok {
+		p, err := s.path(key)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			return 0, err
+		}
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return 0, err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return 0, err
+		}
+		a = &appender{f: f, size: st.Size()}
+		s.open[key] = a
+	}
+	offset := a.size
+	n, err := io.WriteString(a.f, strings.TrimSuffix(text, "\n")+"\n")
+	a.size += int64(n)
+	return offset, err
+}
+
+// Close closes the log file if it is open for appending, it can still be appended to later.
+func (s *Store) Close(key string) {
+	s.Lock()
+	defer s.Unlock()
+	if a, ok := s.open[key]; ok {
+		a.f.Close()
+		delete(s.open, key)
+	}
+}
+
+// Open opens the log for reading
+func (s *Store) Open(key string) (*os.File, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+// Read returns up to limit lines starting at the byte offset, which should be the start of a line
+// e.g. the Next of a previous page.
+func (s *Store) Read(key string, offset int64, limit int) (Page, error) {
+	f, err := s.Open(key)
+	if err != nil {
+		return Page{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Page{}, err
+	}
+	if offset < 0 || offset > st.Size() {
+		offset = st.Size()
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return Page{}, err
+	}
+	pg := Page{
+		Offset: offset,
+		Next:   offset,
+		Size:   st.Size(),
+		Lines:  []string{},
+	}
+	r := bufio.NewReaderSize(io.LimitReader(f, st.Size()-offset), maxLine)
+	for len(pg.Lines) < limit {
+		line, err := r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			// split an over long line
+			pg.Lines = append(pg.Lines, string(line))
+			pg.Next += int64(len(line))
+			continue
+		}
+		if err != nil {
+			// a partly written last line is left for the next read
+			break
+		}
+		pg.Lines = append(pg.Lines, strings.TrimSuffix(string(line), "\n"))
+		pg.Next += int64(len(line))
+	}
+	return pg, nil
+}
+
+// Tail returns up to the last n lines of the log
+func (s *Store) Tail(key string, n int) (Page, error) {
+	f, err := s.Open(key)
+	if err != nil {
+		return Page{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Page{}, err
+	}
+	// read back in blocks until enough line starts are found
+	const block = 16 * 1024
+	offset := st.Size()
+	for offset > 0 {
+		offset -= block
+		if offset < 0 {
+			offset = 0
+		}
+		pg, err := s.Read(key, offset, 1<<30)
+		if err != nil {
+			return Page{}, err
+		}
+		// the first line is partial unless the block starts just after a line end
+		partial := false
+		if offset > 0 {
+			b := make([]byte, 1)
+			if _, err := f.ReadAt(b, offset-1); err != nil {
+				return Page{}, err
+			}
+			partial = b[0] != '\n'
+		}
+		if partial && len(pg.Lines) > 0 {
+			pg.Offset += lineSize(pg.Lines[0])
+			pg.Lines = pg.Lines[1:]
+		}
+		if len(pg.Lines) >= n || offset == 0 {
+			for len(pg.Lines) > n {
+				pg.Offset += lineSize(pg.Lines[0])
+				pg.Lines = pg.Lines[1:]
+			}
+			return pg, nil
+		}
+	}
+	return Page{Lines: []string{}}, nil
+}
+
+// lineSize is the number of bytes a line of a page takes in the log, the parts of a split over
+// long line, bar the last, are a full buffer with no line end.
+func lineSize(line string) int64 {
+	if len(line) == maxLine {
+		return maxLine
+	}
+	return int64(len(line) + 1)
+}
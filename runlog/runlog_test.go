@@ -0,0 +1,217 @@
+package runlog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "floe-runlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() { os.RemoveAll(dir) }
+}
+
+func TestReadPages(t *testing.T) {
+	t.Parallel()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	k := Key("build", "h1-5", "test")
+	if _, err := s.Read(k, 0, 10); err != ErrNotFound {
+		t.Error("expected not found", err)
+	}
+	var offsets []int64
+	for i := 0; i < 25; i++ {
+		o, err := s.Append(k, fmt.Sprintf("line %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, o)
+	}
+	if offsets[0] != 0 || offsets[1] != int64(len("line 0\n")) {
+		t.Error("bad append offsets", offsets[:2])
+	}
+	s.Append(k, "in dir: /foo\n") // a trailing newline does not add an empty line
+	s.Close(k)
+
+	var all []string
+	offset := int64(0)
+	for {
+		pg, err := s.Read(k, offset, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pg.Offset != offset {
+			t.Errorf("page offset %d not %d", pg.Offset, offset)
+		}
+		if len(pg.Lines) == 0 {
+			if pg.Next != pg.Size {
+				t.Error("last page should reach the end", pg.Next, pg.Size)
+			}
+			break
+		}
+		all = append(all, pg.Lines...)
+		offset = pg.Next
+	}
+	if len(all) != 26 || all[0] != "line 0" || all[24] != "line 24" || all[25] != "in dir: /foo" {
+		t.Error("bad lines", len(all), all)
+	}
+
+	// appending after a close carries on the same log
+	if o, _ := s.Append(k, "more"); o != offset {
+		t.Errorf("reopened append offset %d not %d", o, offset)
+	}
+	pg, _ := s.Read(k, offset, 10)
+	if len(pg.Lines) != 1 || pg.Lines[0] != "more" {
+		t.Error("bad appended page", pg.Lines)
+	}
+	s.Close(k)
+}
+
+func TestTail(t *testing.T) {
+	t.Parallel()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	k := Key("build", "h1-5", "test")
+	long := strings.Repeat("x", 1000)
+	for i := 0; i < 100; i++ {
+		s.Append(k, fmt.Sprintf("%03d %s", i, long))
+	}
+	s.Close(k)
+
+	fix := []struct {
+		n     int
+		first string
+	}{
+		{n: 5, first: "095"},
+		{n: 40, first: "060"},
+		{n: 200, first: "000"},
+	}
+	for i, fx := range fix {
+		pg, err := s.Tail(k, fx.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exp := fx.n
+		if exp > 100 {
+			exp = 100
+		}
+		if len(pg.Lines) != exp || !strings.HasPrefix(pg.Lines[0], fx.first) {
+			t.Errorf("%d - bad tail of %d lines starting %.3s", i, len(pg.Lines), pg.Lines[0])
+			continue
+		}
+		// the offset is where the tail starts so reading from it gives the same lines
+		rp, _ := s.Read(k, pg.Offset, 1)
+		if rp.Lines[0] != pg.Lines[0] {
+			t.Errorf("%d - tail offset is not the start of its first line", i)
+		}
+	}
+}
+
+func TestTailBoundaries(t *testing.T) {
+	t.Parallel()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	kb := 1023 // with its line end each line is 1KB so the 16KB blocks start at line starts
+	over := maxLine + 100
+	fix := []struct {
+		lines []string
+		n     int
+		exp   []string
+	}{
+		{ // the block starts exactly at the start of a line
+			lines: numbered(20, kb),
+			n:     16,
+			exp:   numbered(20, kb)[4:],
+		},
+		{ // and the line before the block is still left out
+			lines: numbered(40, kb),
+			n:     17,
+			exp:   numbered(40, kb)[23:],
+		},
+		{ // the first part of a split over long line is dropped
+			lines: []string{"a", strings.Repeat("x", over), "b"},
+			n:     2,
+			exp:   []string{strings.Repeat("x", over-maxLine), "b"},
+		},
+		{ // the block starts in the second part of an over long line
+			lines: []string{"a", strings.Repeat("x", over+16*1024), "b"},
+			n:     1,
+			exp:   []string{"b"},
+		},
+	}
+	for i, fx := range fix {
+		k := Key("build", "h1-5", fmt.Sprintf("node%d", i))
+		for _, l := range fx.lines {
+			s.Append(k, l)
+		}
+		s.Close(k)
+
+		pg, err := s.Tail(k, fx.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pg.Lines) != len(fx.exp) {
+			t.Errorf("%d - got %d lines wanted %d", i, len(pg.Lines), len(fx.exp))
+			continue
+		}
+		for j := range fx.exp {
+			if pg.Lines[j] != fx.exp[j] {
+				t.Errorf("%d - line %d is %.10s wanted %.10s", i, j, pg.Lines[j], fx.exp[j])
+			}
+		}
+		// reading from the offset gives the same lines
+		rp, _ := s.Read(k, pg.Offset, len(fx.exp))
+		if strings.Join(rp.Lines, "\n") != strings.Join(pg.Lines, "\n") {
+			t.Errorf("%d - tail offset %d is not the start of its first line", i, pg.Offset)
+		}
+	}
+}
+
+// numbered returns n lines of the given length each starting with its number
+func numbered(n, length int) []string {
+	var l []string
+	for i := 0; i < n; i++ {
+		p := fmt.Sprintf("%03d ", i)
+		l = append(l, p+strings.Repeat("x", length-len(p)))
+	}
+	return l
+}
+
+func TestBadKeys(t *testing.T) {
+	t.Parallel()
+
+	s, cleanup := testStore(t)
+	defer cleanup()
+
+	for _, k := range []string{
+		Key("..", "..", "passwd"),
+		Key("flow", "run", ".."),
+		Key("flow", "", "node"),
+		"flow/run",
+		"flow/run/node/extra",
+	} {
+		if _, err := s.Append(k, "x"); err == nil {
+			t.Error("bad key appended", k)
+		}
+	}
+	// nothing escaped the root
+	if _, err := os.Stat(filepath.Join(s.root, "..", "passwd.log")); err == nil {
+		t.Error("wrote outside the root")
+	}
+}
@@ -0,0 +1,162 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// fileMagic starts every secrets file, so a wrong or corrupt file is easy to tell from a wrong key
+const fileMagic = "floe-secrets-1\n"
+
+// File provides secrets from a file encrypted with AES-256-GCM using a key derived from a master
+// key. The master key should be a long random string, it is never stored.
+type File struct {
+	sync.RWMutex
+	path   string
+	key    []byte
+	values map[string]string
+}
+
+// OpenFile reads the secrets file at path, a missing file has no secrets.
+func OpenFile(path, masterKey string) (*File, error) {
+	if masterKey == "" {
+		return nil, errors.New("no master key for the secrets file")
+	}
+	k := sha256.Sum256([]byte(masterKey))
+	f := &File{
+		path:   path,
+		key:    k[:],
+		values: map[string]string{},
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < len(fileMagic) || string(b[:len(fileMagic)]) != fileMagic {
+		return nil, errors.New("not a secrets file")
+	}
+	plain, err := f.open(b[len(fileMagic):])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(plain, &f.values); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Get satisfies Provider
+func (f *File) Get(name string) (string, error) {
+	f.RLock()
+	defer f.RUnlock()
+	v, ok := f.values[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// Names returns the sorted names of all the secrets in the file
+func (f *File) Names() []string {
+	f.RLock()
+	defer f.RUnlock()
+	names := make([]string, 0, len(f.values))
+	for n := range f.values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Set sets the secret value and rewrites the file
+func (f *File) Set(name, value string) error {
+	f.Lock()
+	defer f.Unlock()
+	f.values[name] = value
+	return f.save()
+}
+
+// Delete removes the secret and rewrites the file
+func (f *File) Delete(name string) error {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.values[name]; !ok {
+		return ErrNotFound
+	}
+	delete(f.values, name)
+	return f.save()
+}
+
+// save must be called with the lock held
+func (f *File) save() error {
+	plain, err := json.Marshal(f.values)
+	if err != nil {
+		return err
+	}
+	sealed, err := f.seal(plain)
+	if err != nil {
+		return err
+	}
+	// write to a temp file and rename so a failed write does not lose the existing secrets
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), ".secrets")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append([]byte(fileMagic), sealed...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+func (f *File) gcm() (cipher.AEAD, error) {
+	b, err := aes.NewCipher(f.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(b)
+}
+
+// seal returns the nonce followed by the encrypted plain text
+func (f *File) seal(plain []byte) ([]byte, error) {
+	g, err := f.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, g.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return g.Seal(nonce, nonce, plain, []byte(fileMagic)), nil
+}
+
+func (f *File) open(sealed []byte) ([]byte, error) {
+	g, err := f.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < g.NonceSize() {
+		return nil, errors.New("secrets file is truncated")
+	}
+	plain, err := g.Open(nil, sealed[:g.NonceSize()], sealed[g.NonceSize():], []byte(fileMagic))
+	if err != nil {
+		return nil, errors.New("can not decrypt the secrets file, is the master key right?")
+	}
+	return plain, nil
+}
@@ -0,0 +1,66 @@
+package secret
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "secrets")
+
+	if _, err := OpenFile(path, ""); err == nil {
+		t.Error("no master key should fail")
+	}
+
+	f, err := OpenFile(path, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("TOK", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("OTHER", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete("OTHER"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Delete("OTHER"); err != ErrNotFound {
+		t.Error("deleting a missing secret should be not found", err)
+	}
+
+	b, _ := ioutil.ReadFile(path)
+	if bytes.Contains(b, []byte("s3cret")) {
+		t.Error("secret stored in plain text")
+	}
+
+	f, err = OpenFile(path, "master")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := f.Get("TOK"); err != nil || v != "s3cret" {
+		t.Error("bad secret", v, err)
+	}
+	if n := f.Names(); len(n) != 1 || n[0] != "TOK" {
+		t.Error("bad names", n)
+	}
+
+	if _, err := OpenFile(path, "wrong"); err == nil {
+		t.Error("wrong master key should fail")
+	}
+	b[len(b)-1] ^= 1
+	ioutil.WriteFile(path, b, 0600)
+	if _, err := OpenFile(path, "master"); err == nil {
+		t.Error("tampered file should fail")
+	}
+}
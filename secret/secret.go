@@ -0,0 +1,138 @@
+// Package secret provides the values of secrets referenced in flow env vars as {{secret.NAME}},
+// and masks those values in any output.
+package secret
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Mask replaces any secret value found in output
+const Mask = "***"
+
+// ErrNotFound is returned by a Provider that has no secret with the name
+var ErrNotFound = errors.New("secret not found")
+
+// refRe matches a reference to a secret e.g. {{secret.NPM_TOKEN}}
+var refRe = regexp.MustCompile(`{{\s*secret\.([A-Za-z0-9_.-]+)\s*}}`)
+
+// Provider looks up secret values by name
+type Provider interface {
+	Get(name string) (string, error)
+}
+
+// HasRef returns true if s references any secret
+func HasRef(s string) bool {
+	return refRe.MatchString(s)
+}
+
+// Env provides secrets taken from environment variables, keyed by the name without the prefix.
+type Env map[string]string
+
+// TakeEnv removes every environment variable starting with prefix from this process, so that no
+// command inherits them, and returns them as an Env. With the prefix FLOE_SECRET_ the variable
+// FLOE_SECRET_NPM_TOKEN is the secret NPM_TOKEN.
+func TakeEnv(prefix string) Env {
+	e := Env{}
+	for _, kv := range os.Environ() {
+		p := strings.SplitN(kv, "=", 2)
+		if len(p) != 2 || !strings.HasPrefix(p[0], prefix) {
+			continue
+		}
+		e[strings.TrimPrefix(p[0], prefix)] = p[1]
+		os.Unsetenv(p[0])
+	}
+	return e
+}
+
+// Get satisfies Provider
+func (e Env) Get(name string) (string, error) {
+	v, ok := e[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+// Chain is a list of providers, the first provider that has a secret provides it.
+type Chain []Provider
+
+// Get satisfies Provider
+func (c Chain) Get(name string) (string, error) {
+	for _, p := range c {
+		v, err := p.Get(name)
+		if err == ErrNotFound {
+			continue
+		}
+		return v, err
+	}
+	return "", ErrNotFound
+}
+
+// Resolver expands secret references using a Provider remembering every value it expands so that
+// they can be masked in the output. Use a new Resolver for each node execution.
+type Resolver struct {
+	sync.Mutex
+	p      Provider
+	values []string
+}
+
+// NewResolver returns a Resolver that looks up secrets with p, p may be nil if there are no secrets.
+func NewResolver(p Provider) *Resolver {
+	return &Resolver{p: p}
+}
+
+// Expand replaces all the secret references in s with their values, a nil Resolver has no secrets.
+func (r *Resolver) Expand(s string) (string, error) {
+	var err error
+	out := refRe.ReplaceAllStringFunc(s, func(ref string) string {
+		name := refRe.FindStringSubmatch(ref)[1]
+		if r == nil || r.p == nil {
+			err = fmt.Errorf("secret %s: %v", name, ErrNotFound)
+			return ref
+		}
+		v, gerr := r.p.Get(name)
+		if gerr != nil {
+			err = fmt.Errorf("secret %s: %v", name, gerr)
+			return ref
+		}
+		r.remember(v)
+		return v
+	})
+	return out, err
+}
+
+func (r *Resolver) remember(v string) {
+	if v == "" {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, e := range r.values {
+		if e == v {
+			return
+		}
+	}
+	r.values = append(r.values, v)
+	// replace longer values first so a value that contains another is masked whole
+	for i := len(r.values) - 1; i > 0 && len(r.values[i]) > len(r.values[i-1]); i-- {
+		r.values[i], r.values[i-1] = r.values[i-1], r.values[i]
+	}
+}
+
+// Mask replaces any expanded secret value in s, a nil Resolver masks nothing.
+func (r *Resolver) Mask(s string) string {
+	if r == nil {
+		return s
+	}
+	r.Lock()
+	defer r.Unlock()
+	for _, v := range r.values {
+		s = strings.Replace(s, v, Mask, -1)
+	}
+	return s
+}
@@ -0,0 +1,56 @@
+package secret
+
+import (
+	"os"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	p := Chain{Env{"A": "alpha", "AB": "alphabet"}, Env{"A": "shadowed", "B": "beta"}}
+	fix := []struct {
+		in   string
+		out  string
+		fail bool
+	}{
+		{in: "X={{secret.A}}", out: "X=alpha"},
+		{in: "X={{ secret.B }}-{{secret.AB}}", out: "X=beta-alphabet"},
+		{in: "X=plain", out: "X=plain"},
+		{in: "X={{secret.C}}", fail: true},
+	}
+	r := NewResolver(p)
+	for i, fx := range fix {
+		out, err := r.Expand(fx.in)
+		if (err != nil) != fx.fail {
+			t.Errorf("%d - expected fail %v got %v", i, fx.fail, err)
+		}
+		if !fx.fail && out != fx.out {
+			t.Errorf("%d - expected %s got %s", i, fx.out, out)
+		}
+	}
+
+	// the longer value is masked whole, unexpanded values are not masked
+	if m := r.Mask("alphabet alpha beta shadowed"); m != "*** *** *** shadowed" {
+		t.Error("bad mask", m)
+	}
+
+	var nr *Resolver
+	if _, err := nr.Expand("{{secret.A}}"); err == nil {
+		t.Error("nil resolver should not expand a secret")
+	}
+	if m := nr.Mask("alpha"); m != "alpha" {
+		t.Error("nil resolver should not mask", m)
+	}
+}
+
+func TestTakeEnv(t *testing.T) {
+	os.Setenv("FLOE_TEST_SECRET_TOK", "val")
+	e := TakeEnv("FLOE_TEST_SECRET_")
+	if v, err := e.Get("TOK"); err != nil || v != "val" {
+		t.Error("secret not taken", v, err)
+	}
+	if _, ok := os.LookupEnv("FLOE_TEST_SECRET_TOK"); ok {
+		t.Error("secret left in the env")
+	}
+}
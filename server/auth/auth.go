@@ -0,0 +1,156 @@
+// Package auth provides the pluggable authenticators used to check user credentials.
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/floeit/floe/log"
+)
+
+// Credentials are the things a caller may present to prove who they are.
+// Only the fields relevant to an Authenticator are checked by it.
+type Credentials struct {
+	User     string
+	Password string
+	Token    string
+}
+
+// Authenticator checks credentials returning the authenticated user name. ok is false if the
+// credentials are not valid for this authenticator.
+type Authenticator interface {
+	Authenticate(c Credentials) (user string, ok bool)
+}
+
+// Chain is a list of authenticators tried in order, the first to accept the credentials wins.
+type Chain []Authenticator
+
+// Authenticate satisfies Authenticator
+func (ch Chain) Authenticate(c Credentials) (string, bool) {
+	for _, a := range ch {
+		if user, ok := a.Authenticate(c); ok {
+			return user, true
+		}
+	}
+	return "", false
+}
+
+// UserFile authenticates users against an htpasswd style file of user:bcrypt-hash lines.
+// The file is re-read whenever it changes, so users can be added without a restart.
+type UserFile struct {
+	sync.Mutex
+	path    string
+	modTime time.Time
+	users   map[string][]byte
+}
+
+// NewUserFile returns a UserFile reading from path, the file must exist and be valid.
+func NewUserFile(path string) (*UserFile, error) {
+	u := &UserFile{path: path}
+	if err := u.load(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Authenticate satisfies Authenticator
+func (u *UserFile) Authenticate(c Credentials) (string, bool) {
+	if c.User == "" || c.Password == "" {
+		return "", false
+	}
+	u.Lock()
+	if err := u.load(); err != nil {
+		// keep using the users we already have
+		log.Error("auth - could not reload user file:", err)
+	}
+	hash, ok := u.users[c.User]
+	u.Unlock()
+	if !ok {
+		return "", false
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(c.Password)) != nil {
+		return "", false
+	}
+	return c.User, true
+}
+
+// load reads the file if it has changed since the last load
+func (u *UserFile) load() error {
+	fi, err := os.Stat(u.path)
+	if err != nil {
+		return err
+	}
+	if u.users != nil && fi.ModTime().Equal(u.modTime) {
+		return nil
+	}
+	b, err := ioutil.ReadFile(u.path)
+	if err != nil {
+		return err
+	}
+	users, err := parseUserFile(b)
+	if err != nil {
+		return err
+	}
+	u.users = users
+	u.modTime = fi.ModTime()
+	return nil
+}
+
+func parseUserFile(b []byte) (map[string][]byte, error) {
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	n := 0
+	for scanner.Scan() {
+		n++
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		p := strings.SplitN(l, ":", 2)
+		if len(p) != 2 || p[0] == "" {
+			return nil, fmt.Errorf("user file line %d is not user:hash", n)
+		}
+		if _, err := bcrypt.Cost([]byte(p[1])); err != nil {
+			return nil, fmt.Errorf("user file line %d for user %s is not a bcrypt hash: %v", n, p[0], err)
+		}
+		users[p[0]] = []byte(p[1])
+	}
+	return users, scanner.Err()
+}
+
+// HashPassword returns the bcrypt hash of the password suitable for a user file
+func HashPassword(pass string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+// Token is a static token and the user it authenticates as
+type Token struct {
+	User  string
+	Token string
+}
+
+// TokenList authenticates a static list of tokens
+type TokenList []Token
+
+// Authenticate satisfies Authenticator
+func (t TokenList) Authenticate(c Credentials) (string, bool) {
+	if c.Token == "" {
+		return "", false
+	}
+	for _, tok := range t {
+		if subtle.ConstantTimeCompare([]byte(tok.Token), []byte(c.Token)) == 1 {
+			return tok.User, true
+		}
+	}
+	return "", false
+}
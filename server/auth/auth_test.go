@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUserFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "users")
+
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(p, []byte("# users\nbob:"+hash+"\n"), 0600)
+
+	u, err := NewUserFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fix := []struct {
+		user string
+		pass string
+		ok   bool
+	}{
+		{"bob", "secret", true},
+		{"bob", "wrong", false},
+		{"alice", "secret", false},
+		{"bob", "", false},
+	}
+	for i, f := range fix {
+		user, ok := u.Authenticate(Credentials{User: f.user, Password: f.pass})
+		if ok != f.ok {
+			t.Errorf("%d - expected %v got %v", i, f.ok, ok)
+		}
+		if ok && user != f.user {
+			t.Errorf("%d - wrong user %s", i, user)
+		}
+	}
+
+	// users added to the file are picked up
+	ioutil.WriteFile(p, []byte("alice:"+hash+"\n"), 0600)
+	os.Chtimes(p, time.Now(), time.Now().Add(time.Second))
+	if _, ok := u.Authenticate(Credentials{User: "alice", Password: "secret"}); !ok {
+		t.Error("alice should have been loaded")
+	}
+	if _, ok := u.Authenticate(Credentials{User: "bob", Password: "secret"}); ok {
+		t.Error("bob should have been removed")
+	}
+}
+
+func TestParseUserFile(t *testing.T) {
+	t.Parallel()
+
+	fix := []struct {
+		in string
+		ok bool
+	}{
+		{"", true},
+		{"# nothing\n\n", true},
+		{"bob", false},
+		{":$2a$10$abc", false},
+		{"bob:plaintext", false},
+	}
+	for i, f := range fix {
+		_, err := parseUserFile([]byte(f.in))
+		if (err == nil) != f.ok {
+			t.Errorf("%d - expected ok %v got %v", i, f.ok, err)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	t.Parallel()
+
+	ch := Chain{
+		TokenList{{User: "ci", Token: "tok-1"}},
+		TokenList{{User: "ops", Token: "tok-2"}},
+	}
+	fix := []struct {
+		tok  string
+		user string
+		ok   bool
+	}{
+		{"tok-1", "ci", true},
+		{"tok-2", "ops", true},
+		{"tok-3", "", false},
+		{"", "", false},
+	}
+	for i, f := range fix {
+		user, ok := ch.Authenticate(Credentials{Token: f.tok})
+		if ok != f.ok || user != f.user {
+			t.Errorf("%d - expected %s %v got %s %v", i, f.user, f.ok, user, ok)
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute // how long a user has to complete a login at the issuer
+	oidcLeeway   = time.Minute      // allowed clock skew when checking token times
+	oidcPending  = 1000             // the most logins that can be in progress at once
+)
+
+// OIDCConfig configures an OpenID Connect authorization code login
+type OIDCConfig struct {
+	Issuer       string   // the issuer url, the discovery document is fetched from below it
+	ClientID     string   // the client id registered with the issuer
+	ClientSecret string   // the client secret, if empty only PKCE protects the code exchange
+	RedirectURL  string   // the callback url registered with the issuer
+	Scopes       []string // extra scopes to ask for in addition to openid
+	UserClaim    string   // the claim used as the floe user name, default email
+	GroupsClaim  string   // the claim listing the users groups, default groups
+}
+
+// Identity is the user and groups from a verified ID token
+type Identity struct {
+	User   string
+	Groups []string
+}
+
+// OIDC performs OpenID Connect logins using the authorization code flow with PKCE.
+type OIDC struct {
+	sync.Mutex
+	conf   OIDCConfig
+	client *http.Client
+
+	disc    *discovery           // the issuers discovery document, fetched when first needed
+	keys    map[string]publicKey // the issuers signing keys by key id
+	pending map[string]login     // logins in progress by state
+}
+
+type discovery struct {
+	Issuer        string `json:"issuer"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+// login is the state kept between sending the user to the issuer and the callback
+type login struct {
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+type publicKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// NewOIDC returns an OIDC login for the config
+func NewOIDC(c OIDCConfig) (*OIDC, error) {
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return nil, errors.New("oidc needs an issuer, client id and redirect url")
+	}
+	if c.UserClaim == "" {
+		c.UserClaim = "email"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return &OIDC{
+		conf:    c,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		pending: map[string]login{},
+	}, nil
+}
+
+// AuthURL returns the url to send the user to at the issuer to start a login, and the state
+// that must be kept by the browser, e.g. in a cookie, and given back to Exchange.
+func (o *OIDC) AuthURL() (string, string, error) {
+	disc, err := o.discover()
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := randString()
+	if err != nil {
+		return "", "", err
+	}
+
+	o.Lock()
+	now := time.Now()
+	for s, l := range o.pending {
+		if now.After(l.expires) {
+			delete(o.pending, s)
+		}
+	}
+	if len(o.pending) >= oidcPending {
+		o.Unlock()
+		return "", "", errors.New("oidc too many logins in progress")
+	}
+	o.pending[state] = login{verifier: verifier, nonce: nonce, expires: now.Add(oidcLoginTTL)}
+	o.Unlock()
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", o.conf.ClientID)
+	q.Set("redirect_uri", o.conf.RedirectURL)
+	q.Set("scope", strings.Join(append([]string{"openid"}, o.conf.Scopes...), " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", challenge(verifier))
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(disc.AuthEndpoint, "?") {
+		sep = "&"
+	}
+	return disc.AuthEndpoint + sep + q.Encode(), state, nil
+}
+
+// Exchange completes the login given the state and code from the issuer callback, returning
+// the identity from the verified ID token. The browser state is the state kept by the browser
+// that started the login, it must match so that no one can log a browser in as themselves.
+func (o *OIDC) Exchange(state, browserState, code string) (Identity, error) {
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return Identity{}, errors.New("oidc login state does not match the browser")
+	}
+	o.Lock()
+	l, ok := o.pending[state]
+	delete(o.pending, state)
+	o.Unlock()
+	if !ok || time.Now().After(l.expires) {
+		return Identity{}, errors.New("oidc unknown or expired login state")
+	}
+
+	disc, err := o.discover()
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.conf.RedirectURL)
+	form.Set("client_id", o.conf.ClientID)
+	form.Set("code_verifier", l.verifier)
+	if o.conf.ClientSecret != "" {
+		form.Set("client_secret", o.conf.ClientSecret)
+	}
+	resp, err := o.client.PostForm(disc.TokenEndpoint, form)
+	if err != nil {
+		return Identity{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return Identity{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("oidc token exchange got: %d %s", resp.StatusCode, body)
+	}
+	tr := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return Identity{}, err
+	}
+	if tr.IDToken == "" {
+		return Identity{}, errors.New("oidc token response has no id token")
+	}
+
+	claims, err := o.verify(tr.IDToken, disc, l.nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+	return o.identity(claims)
+}
+
+// verify checks the signature and standard claims of the ID token returning all its claims
+func (o *OIDC) verify(token string, disc *discovery, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc malformed id token")
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	hdr := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(hb, &hdr); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.key(disc, hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySig(key, hdr.Alg, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	pb, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(pb, &claims); err != nil {
+		return nil, err
+	}
+
+	if iss, _ := claims["iss"].(string); iss != disc.Issuer {
+		return nil, fmt.Errorf("oidc id token issuer %q is not %q", iss, disc.Issuer)
+	}
+	if !audience(claims["aud"], o.conf.ClientID) {
+		return nil, errors.New("oidc id token is not for this client")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcLeeway).After(time.Unix(int64(exp), 0)) {
+		return nil, errors.New("oidc id token has expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(oidcLeeway)) {
+		return nil, errors.New("oidc id token issued in the future")
+	}
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("oidc id token nonce does not match")
+	}
+	return claims, nil
+}
+
+// identity maps the claims to the floe user and groups
+func (o *OIDC) identity(claims map[string]interface{}) (Identity, error) {
+	id := Identity{}
+	id.User, _ = claims[o.conf.UserClaim].(string)
+	if id.User == "" {
+		return id, fmt.Errorf("oidc id token has no %s claim", o.conf.UserClaim)
+	}
+	// anyone can claim an email address they have not verified
+	if o.conf.UserClaim == "email" && !verified(claims["email_verified"]) {
+		return id, errors.New("oidc id token email is not verified")
+	}
+	switch g := claims[o.conf.GroupsClaim].(type) {
+	case string:
+		id.Groups = []string{g}
+	case []interface{}:
+		for _, v := range g {
+			if s, ok := v.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	}
+	return id, nil
+}
+
+// verified returns true if the email_verified claim is true, some issuers send it as a string
+func verified(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+func audience(aud interface{}, clientID string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == clientID
+	case []interface{}:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// discover returns the issuers discovery document, fetching it if needed
+func (o *OIDC) discover() (*discovery, error) {
+	o.Lock()
+	disc := o.disc
+	o.Unlock()
+	if disc != nil {
+		return disc, nil
+	}
+	disc = &discovery{}
+	if err := o.getJSON(strings.TrimSuffix(o.conf.Issuer, "/")+"/.well-known/openid-configuration", disc); err != nil {
+		return nil, err
+	}
+	if disc.Issuer != o.conf.Issuer {
+		return nil, fmt.Errorf("oidc discovery issuer %q does not match %q", disc.Issuer, o.conf.Issuer)
+	}
+	if disc.AuthEndpoint == "" || disc.TokenEndpoint == "" || disc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery document is missing endpoints")
+	}
+	o.Lock()
+	o.disc = disc
+	o.Unlock()
+	return disc, nil
+}
+
+// key returns the signing key by id, the key set is re-fetched if the key is not known
+// so that the issuer can rotate its keys.
+func (o *OIDC) key(disc *discovery, kid string) (publicKey, error) {
+	o.Lock()
+	k, ok := o.keys[kid]
+	o.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := o.getJSON(disc.JWKSURI, &set); err != nil {
+		return k, err
+	}
+	keys := map[string]publicKey{}
+	for _, j := range set.Keys {
+		pk, err := j.publicKey()
+		if err != nil {
+			continue // ignore keys we can not use
+		}
+		keys[j.Kid] = pk
+	}
+	o.Lock()
+	o.keys = keys
+	o.Unlock()
+
+	k, ok = keys[kid]
+	if !ok {
+		return k, fmt.Errorf("oidc no signing key with id %q", kid)
+	}
+	return k, nil
+}
+
+func (o *OIDC) getJSON(u string, v interface{}) error {
+	resp, err := o.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc got %d from %s", resp.StatusCode, u)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jwk is a json web key, only RSA and P-256 EC signing keys are supported
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j jwk) publicKey() (publicKey, error) {
+	if j.Use != "" && j.Use != "sig" {
+		return publicKey{}, errors.New("not a signing key")
+	}
+	switch j.Kty {
+	case "RSA":
+		n, err := b64Int(j.N)
+		if err != nil {
+			return publicKey{}, err
+		}
+		e, err := b64Int(j.E)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{alg: "RS256", key: &rsa.PublicKey{N: n, E: int(e.Int64())}}, nil
+	case "EC":
+		if j.Crv != "P-256" {
+			return publicKey{}, fmt.Errorf("unsupported curve %s", j.Crv)
+		}
+		x, err := b64Int(j.X)
+		if err != nil {
+			return publicKey{}, err
+		}
+		y, err := b64Int(j.Y)
+		if err != nil {
+			return publicKey{}, err
+		}
+		return publicKey{alg: "ES256", key: &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	}
+	return publicKey{}, fmt.Errorf("unsupported key type %s", j.Kty)
+}
+
+// verifySig checks the signature of the signed data, the algorithm must match the key type
+func verifySig(k publicKey, alg string, signed, sig []byte) error {
+	if alg != k.alg {
+		return fmt.Errorf("oidc id token algorithm %s not allowed for key", alg)
+	}
+	h := sha256.Sum256(signed)
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig)
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return errors.New("oidc bad ecdsa signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, h[:], r, s) {
+			return errors.New("oidc bad ecdsa signature")
+		}
+		return nil
+	}
+	return errors.New("oidc unsupported key")
+}
+
+func b64Int(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// challenge is the PKCE S256 code challenge for the verifier
+func challenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func randString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
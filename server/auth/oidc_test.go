@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is a minimal OpenID Connect provider
+type fakeIssuer struct {
+	sync.Mutex
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{} // the claims to put in the next id token
+	codes  map[string]authReq     // issued codes
+}
+
+type authReq struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeIssuer{key: key, codes: map[string]authReq{}}
+	f.srv = httptest.NewServer(f)
+	return f
+}
+
+// authorize simulates the user logging in at the issuer returning the code
+func (f *fakeIssuer) authorize(t *testing.T, authURL string) (state, code string) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatal("missing pkce challenge")
+	}
+	f.Lock()
+	defer f.Unlock()
+	code = "code-" + q.Get("state")[:8]
+	f.codes[code] = authReq{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	return q.Get("state"), code
+}
+
+func (f *fakeIssuer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	case "/jwks":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			}},
+		})
+	case "/token":
+		r.ParseForm()
+		req, ok := f.codes[r.Form.Get("code")]
+		delete(f.codes, r.Form.Get("code"))
+		if !ok || challenge(r.Form.Get("code_verifier")) != req.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss":   f.srv.URL,
+			"aud":   "floe",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": req.nonce,
+		}
+		for k, v := range f.claims {
+			claims[k] = v
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign(claims)})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeIssuer) sign(claims map[string]interface{}) string {
+	hb, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+	pb, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hb) + "." + base64.RawURLEncoding.EncodeToString(pb)
+	h := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, h[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeIssuer(t)
+	defer f.srv.Close()
+
+	o, err := NewOIDC(OIDCConfig{
+		Issuer:      f.srv.URL,
+		ClientID:    "floe",
+		RedirectURL: "http://floe.local/build/api/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fix := []struct {
+		claims map[string]interface{}
+		ok     bool
+		id     Identity
+	}{
+		{ // good with groups
+			claims: map[string]interface{}{"email": "bob@example.com", "email_verified": true, "groups": []string{"devs", "ops"}},
+			ok:     true,
+			id:     Identity{User: "bob@example.com", Groups: []string{"devs", "ops"}},
+		},
+		{ // wrong audience
+			claims: map[string]interface{}{"email": "bob@example.com", "aud": "other"},
+		},
+		{ // expired
+			claims: map[string]interface{}{"email": "bob@example.com", "exp": time.Now().Add(-time.Hour).Unix()},
+		},
+		{ // wrong issuer
+			claims: map[string]interface{}{"email": "bob@example.com", "iss": "http://evil"},
+		},
+		{ // replayed nonce
+			claims: map[string]interface{}{"email": "bob@example.com", "nonce": "stale"},
+		},
+		{ // no user claim
+			claims: map[string]interface{}{"sub": "123"},
+		},
+		{ // unverified email
+			claims: map[string]interface{}{"email": "bob@example.com", "email_verified": false},
+		},
+		{ // email not known to be verified
+			claims: map[string]interface{}{"email": "bob@example.com"},
+		},
+	}
+	for i, fx := range fix {
+		f.Lock()
+		f.claims = fx.claims
+		f.Unlock()
+
+		u, browserState, err := o.AuthURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		state, code := f.authorize(t, u)
+		id, err := o.Exchange(state, browserState, code)
+		if (err == nil) != fx.ok {
+			t.Errorf("%d - expected ok %v got %v", i, fx.ok, err)
+			continue
+		}
+		if fx.ok && (id.User != fx.id.User || len(id.Groups) != len(fx.id.Groups)) {
+			t.Errorf("%d - wrong identity %+v", i, id)
+		}
+	}
+
+	f.Lock()
+	f.claims = map[string]interface{}{"email": "bob@example.com", "email_verified": "true"}
+	f.Unlock()
+
+	// a login started by another browser is refused
+	u, _, _ := o.AuthURL()
+	state, code := f.authorize(t, u)
+	if _, err := o.Exchange(state, "", code); err == nil {
+		t.Error("login without the browser state should fail")
+	}
+	_, other, _ := o.AuthURL()
+	if _, err := o.Exchange(state, other, code); err == nil {
+		t.Error("login with the state of another login should fail")
+	}
+
+	// a state can only be used once
+	u, browserState, _ := o.AuthURL()
+	state, code = f.authorize(t, u)
+	if _, err := o.Exchange(state, browserState, code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Exchange(state, browserState, code); err == nil {
+		t.Error("reused state should fail")
+	}
+
+	// the logins in progress are capped
+	for i := 0; i < oidcPending; i++ {
+		if _, _, err := o.AuthURL(); err != nil {
+			return
+		}
+	}
+	t.Error("unlimited logins should not be allowed in progress")
+}
+
+func TestOIDCBadSignature(t *testing.T) {
+	t.Parallel()
+
+	f := newFakeIssuer(t)
+	defer f.srv.Close()
+	o, _ := NewOIDC(OIDCConfig{Issuer: f.srv.URL, ClientID: "floe", RedirectURL: "http://floe.local/cb"})
+	disc, err := o.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := f.sign(map[string]interface{}{
+		"iss":   f.srv.URL,
+		"aud":   "floe",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": "n",
+	})
+	if _, err := o.verify(tok, disc, "n"); err != nil {
+		t.Fatal("good token failed", err)
+	}
+	// tamper with the payload
+	tampered := tok[:len(tok)-5] + "AAAAA"
+	if _, err := o.verify(tampered, disc, "n"); err == nil {
+		t.Error("tampered token should fail")
+	}
+}
@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Role is a level of access, each role includes all the access of the roles below it.
+type Role int
+
+// The roles in increasing order of access
+const (
+	None     Role = iota // no access
+	Viewer               // can see flows and runs
+	Trigger              // can start runs
+	Approver             // can supply data to data nodes of a run e.g. approvals
+	Admin                // can do anything including cancelling runs and reloading config
+)
+
+var roleNames = []string{"none", "viewer", "trigger", "approver", "admin"}
+
+func (r Role) String() string {
+	if r < None || int(r) >= len(roleNames) {
+		return "unknown"
+	}
+	return roleNames[r]
+}
+
+// ParseRole returns the role named by s
+func ParseRole(s string) (Role, error) {
+	for i, n := range roleNames {
+		if strings.ToLower(s) == n {
+			return Role(i), nil
+		}
+	}
+	return None, fmt.Errorf("unknown role: %s", s)
+}
+
+// Binding grants a role to users and members of groups for the given flows.
+type Binding struct {
+	Role   Role
+	Users  []string
+	Groups []string
+	Flows  []string // the flow ids the binding applies to, if empty it applies to all flows
+}
+
+func (b Binding) subject(user string, groups []string) bool {
+	if contains(b.Users, user) {
+		return true
+	}
+	for _, g := range groups {
+		if contains(b.Groups, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// scoped returns true if the binding applies to the flow, an empty flow is only
+// matched by bindings that apply to all flows.
+func (b Binding) scoped(flow string) bool {
+	if len(b.Flows) == 0 {
+		return true
+	}
+	return flow != "" && contains(b.Flows, flow)
+}
+
+// Policy decides what role a user has for each flow.
+type Policy struct {
+	Groups   map[string][]string // the members of each group
+	Bindings []Binding
+}
+
+// Open returns true if there are no bindings, in which case every authenticated user is an admin.
+func (p Policy) Open() bool {
+	return len(p.Bindings) == 0
+}
+
+// Role returns the highest role the user, who is a member of groups in addition to any groups
+// given in the policy, has for the flow. If flow is empty only roles for all flows are considered.
+func (p Policy) Role(user string, groups []string, flow string) Role {
+	if p.Open() {
+		return Admin
+	}
+	all := append([]string{}, groups...)
+	for g, members := range p.Groups {
+		if contains(members, user) {
+			all = append(all, g)
+		}
+	}
+	role := None
+	for _, b := range p.Bindings {
+		if b.Role > role && b.scoped(flow) && b.subject(user, all) {
+			role = b.Role
+		}
+	}
+	return role
+}
+
+// Allowed returns true if the user has at least role r for the flow
+func (p Policy) Allowed(user string, groups []string, flow string, r Role) bool {
+	return p.Role(user, groups, flow) >= r
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type ctxKey int
+
+const userKey ctxKey = 0
+
+// WithUser returns a copy of the context carrying the authenticated user
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the authenticated user carried by the context, if any
+func User(ctx context.Context) string {
+	u, _ := ctx.Value(userKey).(string)
+	return u
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseRole(t *testing.T) {
+	t.Parallel()
+
+	for _, r := range []Role{None, Viewer, Trigger, Approver, Admin} {
+		p, err := ParseRole(r.String())
+		if err != nil || p != r {
+			t.Errorf("%s did not round trip got %s %v", r, p, err)
+		}
+	}
+	if _, err := ParseRole("boss"); err == nil {
+		t.Error("unknown role should error")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	if !(Policy{}).Allowed("anyone", nil, "flow", Admin) {
+		t.Error("an open policy should allow everything")
+	}
+
+	p := Policy{
+		Groups: map[string][]string{
+			"devs": {"bob", "carol"},
+		},
+		Bindings: []Binding{
+			{Role: Viewer, Groups: []string{"devs"}},
+			{Role: Trigger, Groups: []string{"devs"}, Flows: []string{"build"}},
+			{Role: Approver, Users: []string{"carol"}, Flows: []string{"release"}},
+			{Role: Admin, Users: []string{"alice"}},
+			{Role: Admin, Groups: []string{"oidc-ops"}, Flows: []string{"release"}},
+		},
+	}
+
+	fix := []struct {
+		user   string
+		groups []string
+		flow   string
+		role   Role
+	}{
+		{"alice", nil, "", Admin},
+		{"alice", nil, "build", Admin},
+		{"bob", nil, "", Viewer},
+		{"bob", nil, "build", Trigger},
+		{"bob", nil, "release", Viewer},
+		{"carol", nil, "release", Approver},
+		{"dave", nil, "build", None},
+		{"dave", []string{"oidc-ops"}, "release", Admin},
+		{"dave", []string{"oidc-ops"}, "", None},
+	}
+	for i, f := range fix {
+		if r := p.Role(f.user, f.groups, f.flow); r != f.role {
+			t.Errorf("%d - %s on %s expected %s got %s", i, f.user, f.flow, f.role, r)
+		}
+	}
+	if !p.Allowed("carol", nil, "release", Trigger) {
+		t.Error("approver should include trigger")
+	}
+	if p.Allowed("bob", nil, "release", Trigger) {
+		t.Error("bob should only view release")
+	}
+}
+
+func TestUserContext(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	if User(ctx) != "" {
+		t.Error("no user expected")
+	}
+	if User(WithUser(ctx, "bob")) != "bob" {
+		t.Error("bob expected")
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scopes limit what an API token can do, whatever role its owner has. A token can do no more
+// than its owner and no more than its scopes. The scopes are:
+//
+//	read             - view all flows and runs
+//	trigger:flow-id  - start runs of the flow, trigger:* for all flows
+//	approve          - supply data to data nodes of runs of all flows, approve:flow-id for one flow
+type Scopes []string
+
+// ParseScopes validates the scopes
+func ParseScopes(l []string) (Scopes, error) {
+	if len(l) == 0 {
+		return nil, fmt.Errorf("at least one scope is needed")
+	}
+	for _, s := range l {
+		p := strings.SplitN(s, ":", 2)
+		switch {
+		case s == "read", s == "approve":
+		case len(p) == 2 && (p[0] == "trigger" || p[0] == "approve") && p[1] != "":
+		default:
+			return nil, fmt.Errorf("bad scope: %s", s)
+		}
+	}
+	return Scopes(l), nil
+}
+
+// Allow returns true if the scopes permit the role on the flow, an empty flow needs the scope
+// for all flows. No scope permits the admin role.
+func (s Scopes) Allow(flow string, r Role) bool {
+	for _, sc := range s {
+		switch r {
+		case None:
+			return true
+		case Viewer:
+			if sc == "read" {
+				return true
+			}
+		case Trigger:
+			if flow != "" && (sc == "trigger:"+flow || sc == "trigger:*") {
+				return true
+			}
+		case Approver:
+			if sc == "approve" || (flow != "" && sc == "approve:"+flow) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package auth
+
+import "testing"
+
+func TestParseScopes(t *testing.T) {
+	t.Parallel()
+
+	fix := []struct {
+		in []string
+		ok bool
+	}{
+		{[]string{"read"}, true},
+		{[]string{"read", "trigger:build", "approve"}, true},
+		{[]string{"trigger:*", "approve:release"}, true},
+		{nil, false},
+		{[]string{"trigger"}, false},
+		{[]string{"trigger:"}, false},
+		{[]string{"admin"}, false},
+	}
+	for i, f := range fix {
+		_, err := ParseScopes(f.in)
+		if (err == nil) != f.ok {
+			t.Errorf("%d - expected ok %v got %v", i, f.ok, err)
+		}
+	}
+}
+
+func TestScopesAllow(t *testing.T) {
+	t.Parallel()
+
+	s := Scopes{"read", "trigger:build", "approve:release"}
+	fix := []struct {
+		flow string
+		role Role
+		ok   bool
+	}{
+		{"", Viewer, true},
+		{"build", Viewer, true},
+		{"build", Trigger, true},
+		{"release", Trigger, false},
+		{"", Trigger, false},
+		{"release", Approver, true},
+		{"build", Approver, false},
+		{"build", Admin, false},
+	}
+	for i, f := range fix {
+		if s.Allow(f.flow, f.role) != f.ok {
+			t.Errorf("%d - %s on %s expected %v", i, f.role, f.flow, f.ok)
+		}
+	}
+	if (Scopes{"trigger:build"}).Allow("build", Viewer) {
+		t.Error("trigger scope should not include read")
+	}
+	if !(Scopes{"trigger:*"}).Allow("any", Trigger) {
+		t.Error("trigger:* should trigger any flow")
+	}
+}
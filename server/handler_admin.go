@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/floeit/floe/config"
+)
+
+// LoadConfig reads and parses the host config again for a reload, reloads are refused if it is nil
+var LoadConfig func() (*config.Config, error)
+
+// hndDrain starts draining this host, it stops taking runs and shuts down once its active runs
+// have ended or the drain grace period is over
+func hndDrain(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	go ctx.hub.Drain()
+	return rOK, "draining", nil
+}
+
+type reloadResp struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// hndReload reloads the flows of this host from its config file
+func hndReload(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if LoadConfig == nil {
+		return rBad, "this host can not reload its config", nil
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		return rBad, "config not reloaded: " + err.Error(), nil
+	}
+	res := reloadResp{}
+	res.Added, res.Removed, res.Changed = ctx.hub.Reload(c, ctx.sesh.actor())
+	return rOK, "reloaded", res
+}
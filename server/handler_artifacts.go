@@ -0,0 +1,76 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/floeit/floe/artifact"
+)
+
+// runArtifact is an artifact kept from a node of a run
+type runArtifact struct {
+	Node string
+	artifact.File
+}
+
+// hndArtifacts lists the artifacts kept from each node of the run, from whichever host ran it
+func hndArtifacts(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	run := ctx.hub.AllClientFindRun(ctx.ps.ByName("id"), ctx.ps.ByName("rid"))
+	if run == nil {
+		return rNotFound, "run not found", nil
+	}
+	arts := []runArtifact{}
+	for id, ex := range run.ExecNodes {
+		for _, f := range ex.Artifacts {
+			arts = append(arts, runArtifact{Node: id, File: f})
+		}
+	}
+	sort.Slice(arts, func(i, j int) bool {
+		if arts[i].Node != arts[j].Node {
+			return arts[i].Node < arts[j].Node
+		}
+		return arts[i].Name < arts[j].Name
+	})
+	return rOK, "OK", arts
+}
+
+// hndArtifact downloads an artifact from whichever host has it
+func hndArtifact(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return downloadArtifact(rw, ctx, true)
+}
+
+// hndP2PArtifact downloads an artifact from this host only
+func hndP2PArtifact(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return downloadArtifact(rw, ctx, false)
+}
+
+func downloadArtifact(rw http.ResponseWriter, ctx *context, all bool) (int, string, renderable) {
+	id, rid, nid := ctx.ps.ByName("id"), ctx.ps.ByName("rid"), ctx.ps.ByName("nid")
+	name := strings.TrimPrefix(ctx.ps.ByName("name"), "/") // the catch all param starts with the slash
+	var rc io.ReadCloser
+	var err error
+	if all {
+		rc, err = ctx.hub.AllClientOpenArtifact(id, rid, nid, name)
+	} else {
+		rc, err = ctx.hub.OpenArtifact(id, rid, nid, name)
+	}
+	switch err {
+	case nil:
+	case artifact.ErrNotFound:
+		return rNotFound, err.Error(), nil
+	default:
+		return rErr, err.Error(), nil
+	}
+	defer rc.Close()
+
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": path.Base(name)}))
+	rw.WriteHeader(http.StatusOK)
+	io.Copy(rw, rc)
+	return 0, "", nil
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/floeit/floe/audit"
+)
+
+// hndAudit returns a page of this hosts audit log filtered by the query parameters
+// actor, action, flow, run, since and until (RFC3339), before and limit.
+func (h handler) hndAudit(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	v := r.URL.Query()
+	q := audit.Query{
+		Actor:  v.Get("actor"),
+		Action: v.Get("action"),
+		FlowID: v.Get("flow"),
+		Run:    v.Get("run"),
+	}
+	var err error
+	if s := v.Get("since"); s != "" {
+		if q.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			return rBad, "bad since: " + err.Error(), nil
+		}
+	}
+	if s := v.Get("until"); s != "" {
+		if q.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			return rBad, "bad until: " + err.Error(), nil
+		}
+	}
+	if s := v.Get("before"); s != "" {
+		if q.Before, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return rBad, "bad before: " + err.Error(), nil
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit > 1000 {
+			return rBad, "limit must be a number up to 1000", nil
+		}
+	}
+	p, err := h.audit.Query(q)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
+	return rOK, "OK", p
+}
@@ -1,8 +1,16 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+	"path"
+	"strings"
+	"time"
 
-func loginHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
+)
+
+func (h handler) loginHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
 	v := struct {
 		User     string
 		Password string
@@ -11,26 +19,182 @@ func loginHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, s
 	if ok, code, msg := decodeBody(rw, r, &v); !ok {
 		return code, msg, nil
 	}
+	ctx.rec.Actor = v.User
 
-	token := login(v.User, v.Password)
+	token, err := h.seshs.login(v.User, v.Password)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
 	if token == "" {
 		return rUnauth, "username or password were wrong", nil
 	}
 
 	setCookie(rw, token)
 
-	// authenticated if we got here
+	// authenticated if we got here, the role is the one the user, as a member of the groups
+	// of the session, has across all flows
+	sesh := h.seshs.get(token)
+	if sesh == nil {
+		return rErr, "session not found after login", nil
+	}
+	role := h.policy.Role(sesh.User, sesh.Groups, "")
 	return rOK, "", struct {
 		User  string
 		Role  string
 		Token string
-	}{v.User, "ADMIN", token}
+	}{sesh.User, strings.ToUpper(role.String()), token}
 }
 
-func logoutHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+func (h handler) logoutHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
 	if ctx.sesh == nil {
 		return rBad, "token not supplied", nil
 	}
-	logout(ctx.sesh.token)
+	if err := h.seshs.logout(ctx.sesh.token); err != nil {
+		return rErr, err.Error(), nil
+	}
 	return rOK, "logged out", nil
 }
+
+// hndSessions lists the callers own sessions, or all sessions for the admin
+func (h handler) hndSessions(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if ctx.sesh.apiToken != "" {
+		return rForbid, "api tokens can not manage sessions", nil
+	}
+	user := ctx.sesh.User
+	if ctx.allowed("", auth.Admin) {
+		user = ""
+	}
+	return rOK, "", struct {
+		Current  string
+		Sessions []session
+	}{
+		Current:  ctx.sesh.ID,
+		Sessions: h.seshs.all(user),
+	}
+}
+
+// hndRevokeSession removes the identified session, users may only revoke their own sessions
+func (h handler) hndRevokeSession(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if ctx.sesh.apiToken != "" {
+		return rForbid, "api tokens can not manage sessions", nil
+	}
+	id := ctx.ps.ByName("sid")
+	owner, ok := h.seshs.owner(id)
+	if !ok || (owner != ctx.sesh.User && !ctx.allowed("", auth.Admin)) {
+		return rNotFound, "session not found", nil
+	}
+	if _, err := h.seshs.revoke(id); err != nil {
+		return rErr, err.Error(), nil
+	}
+	return rOK, "session revoked", nil
+}
+
+// hndOIDCLogin sends the browser to the identity provider to log in
+func (h handler) hndOIDCLogin(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	u, state, err := h.oidc.AuthURL()
+	if err != nil {
+		log.Error("oidc login failed", err)
+		return rErr, "could not start single sign on", nil
+	}
+	// tie the login to this browser, lax so the cookie comes back on the redirect from the issuer
+	http.SetCookie(rw, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    state,
+		Path:     path.Dir(r.URL.Path),
+		MaxAge:   int(oidcCookieAge / time.Second),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(rw, r, u, http.StatusFound)
+	return 0, "", nil
+}
+
+// hndOIDCCallback completes the login when the identity provider redirects the browser back,
+// creating a session in the session cookie as a normal login does.
+func (h handler) hndOIDCCallback(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		return rUnauth, "single sign on failed: " + e, nil
+	}
+	browserState := ""
+	if c, err := r.Cookie(oidcCookieName); err == nil {
+		browserState = c.Value
+	}
+	http.SetCookie(rw, &http.Cookie{Name: oidcCookieName, Path: path.Dir(r.URL.Path), MaxAge: -1})
+	id, err := h.oidc.Exchange(q.Get("state"), browserState, q.Get("code"))
+	if err != nil {
+		log.Warning("oidc callback failed", err)
+		return rUnauth, "single sign on failed", nil
+	}
+	ctx.rec.Actor = id.User
+	token, err := h.seshs.create(id.User, id.Groups)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
+	setCookie(rw, token)
+	http.Redirect(rw, r, "/app/dash", http.StatusFound)
+	return 0, "", nil
+}
+
+// hndTokens lists the callers api tokens, or all tokens for the admin
+func (h handler) hndTokens(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if ctx.sesh.apiToken != "" {
+		return rForbid, "api tokens can not manage api tokens", nil
+	}
+	user := ctx.sesh.User
+	if ctx.allowed("", auth.Admin) {
+		user = ""
+	}
+	return rOK, "", h.tokens.all(user)
+}
+
+// hndCreateToken creates a named api token for the caller, the token is only ever returned here
+func (h handler) hndCreateToken(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if ctx.sesh.apiToken != "" {
+		return rForbid, "api tokens can not manage api tokens", nil
+	}
+	v := struct {
+		Name    string
+		Scopes  []string
+		Expires time.Time // optional
+	}{}
+	if ok, code, msg := decodeBody(rw, r, &v); !ok {
+		return code, msg, nil
+	}
+	if v.Name == "" {
+		return rBad, "a token name is needed", nil
+	}
+	scopes, err := auth.ParseScopes(v.Scopes)
+	if err != nil {
+		return rBad, err.Error(), nil
+	}
+	if !v.Expires.IsZero() && v.Expires.Before(time.Now()) {
+		return rBad, "expiry is in the past", nil
+	}
+	ctx.rec.Detail = v.Name
+	token, t, err := h.tokens.create(v.Name, ctx.sesh.User, ctx.sesh.admin, scopes, v.Expires)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
+	return rCreated, "", struct {
+		Token string // the only time the token is available
+		Info  apiToken
+	}{token, t}
+}
+
+// hndRevokeToken removes the identified api token, users may only revoke their own tokens
+func (h handler) hndRevokeToken(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	if ctx.sesh.apiToken != "" {
+		return rForbid, "api tokens can not manage api tokens", nil
+	}
+	id := ctx.ps.ByName("tid")
+	owner, ok := h.tokens.owner(id)
+	if !ok || (owner != ctx.sesh.User && !ctx.allowed("", auth.Admin)) {
+		return rNotFound, "token not found", nil
+	}
+	if _, err := h.tokens.revoke(id); err != nil {
+		return rErr, err.Error(), nil
+	}
+	return rOK, "token revoked", nil
+}
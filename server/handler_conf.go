@@ -4,13 +4,17 @@ import (
 	"net/http"
 
 	"github.com/floeit/floe/client"
+	"github.com/floeit/floe/hub"
 )
 
 // hostConfig is the publishable config of a host
 type hostConfig struct {
-	HostID string
-	Online bool
-	Tags   []string
+	HostID     string
+	Online     bool
+	Tags       []string
+	MaxRuns    int
+	ActiveRuns int
+	FreeDisk   uint64
 }
 
 // the /config endpoint
@@ -18,13 +22,18 @@ func confHandler(rw http.ResponseWriter, r *http.Request, ctx *context) (int, st
 	cnf := struct {
 		Config   hostConfig
 		AllHosts map[string]client.HostConfig
+		Cleanup  hub.CleanupStats // what the janitor has removed from this host
 	}{
 		Config: hostConfig{
-			HostID: ctx.hub.HostID(),
-			Online: true, // TODO consider the option to pretend to be offline
-			Tags:   ctx.hub.Tags(),
+			HostID:     ctx.hub.HostID(),
+			Online:     ctx.hub.Ready().Ready, // peers only send runs to hosts that are ready
+			Tags:       ctx.hub.Tags(),
+			MaxRuns:    ctx.hub.MaxRuns(),
+			ActiveRuns: ctx.hub.ActiveRuns(),
+			FreeDisk:   ctx.hub.FreeDisk(),
 		},
 		AllHosts: ctx.hub.AllHosts(),
+		Cleanup:  ctx.hub.CleanupStats(),
 	}
 
 	return rOK, "OK", cnf
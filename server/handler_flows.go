@@ -6,10 +6,31 @@ import (
 	"github.com/floeit/floe/client"
 	"github.com/floeit/floe/config"
 	"github.com/floeit/floe/hub"
+	"github.com/floeit/floe/server/auth"
 )
 
+// hndAllFlows returns the flows the user can view, and only the common config the web app needs,
+// the rest of it such as the auth bindings is not for viewers
 func hndAllFlows(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
-	return rOK, "", ctx.hub.Config()
+	conf := ctx.hub.Config()
+	flows := []*config.Flow{}
+	for _, f := range conf.Flows {
+		if ctx.allowed(f.ID, auth.Viewer) {
+			flows = append(flows, f)
+		}
+	}
+
+	type common struct {
+		BaseURL string
+	}
+	response := struct {
+		Common common
+		Flows  []*config.Flow
+	}{
+		Common: common{BaseURL: conf.Common.BaseURL},
+		Flows:  flows,
+	}
+	return rOK, "", response
 }
 
 // hndFlow returns the latest config and run summaries from all clients for this flow
@@ -37,7 +58,13 @@ func hndFlow(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string
 	return rOK, "", response
 }
 
-// hndP2PExecFlow is the handler for the internal call to execute the flow on this node
+// hndP2PPends returns the pending list of this host, so peers can adopt them if this host dies
+func hndP2PPends(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return rOK, "", ctx.hub.Pends()
+}
+
+// hndP2PExecFlow is the handler for the internal call to execute the flow on this node.
+// It is idempotent on the pend RunRef, so a retried request will not start a second run.
 func hndP2PExecFlow(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
 
 	pend := hub.Pend{}
@@ -50,7 +77,7 @@ func hndP2PExecFlow(rw http.ResponseWriter, r *http.Request, ctx *context) (int,
 		return rErr, err.Error(), nil
 	}
 	if !ok {
-		return rConflict, "host has resource conflicting active flows", nil
+		return rConflict, "host is draining, at capacity or has resource conflicting active flows", nil
 	}
 
 	return rOK, "started", nil
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"net/http"
+)
+
+// hndHealth responds ok for as long as the host is serving
+func hndHealth(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return rOK, "ok", struct{ HostID string }{ctx.hub.HostID()}
+}
+
+// hndReady responds with the outcome of each readiness check, and is unavailable unless they
+// are all ok
+func hndReady(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	ready := ctx.hub.Ready()
+	if !ready.Ready {
+		return rUnavail, "not ready", ready
+	}
+	return rOK, "ready", ready
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/floeit/floe/runlog"
+)
+
+const (
+	logTailLines = 200   // the lines of output of each node included in the run detail
+	logPageLines = 1000  // the default lines in a page of node output
+	logPageMax   = 10000 // the most lines in a page of node output
+)
+
+// hndNodeLog returns a page of the output of a node from whichever host ran it, starting at the
+// byte offset in the query, so the Next of the previous page gets the next page.
+func hndNodeLog(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return nodeLog(r, ctx, true)
+}
+
+// hndP2PNodeLog returns a page of the output of a node from this host only
+func hndP2PNodeLog(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return nodeLog(r, ctx, false)
+}
+
+func nodeLog(r *http.Request, ctx *context, all bool) (int, string, renderable) {
+	var offset int64
+	var err error
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return rBad, "offset must be a positive number", nil
+		}
+	}
+	limit := logPageLines
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > logPageMax {
+			return rBad, "limit must be a number from 1 to " + strconv.Itoa(logPageMax), nil
+		}
+	}
+	id, rid, nid := ctx.ps.ByName("id"), ctx.ps.ByName("rid"), ctx.ps.ByName("nid")
+
+	var pg runlog.Page
+	if all {
+		pg, err = ctx.hub.AllClientNodeLog(id, rid, nid, offset, limit)
+	} else {
+		pg, err = ctx.hub.NodeLog(id, rid, nid, offset, limit)
+	}
+	switch err {
+	case nil:
+		return rOK, "OK", pg
+	case runlog.ErrNotFound:
+		return rNotFound, err.Error(), nil
+	}
+	return rErr, err.Error(), nil
+}
+
+// hndNodeLogRaw downloads the whole output of a node from whichever host ran it
+func hndNodeLogRaw(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return nodeLogRaw(rw, ctx, true)
+}
+
+// hndP2PNodeLogRaw downloads the whole output of a node from this host only
+func hndP2PNodeLogRaw(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return nodeLogRaw(rw, ctx, false)
+}
+
+func nodeLogRaw(rw http.ResponseWriter, ctx *context, all bool) (int, string, renderable) {
+	id, rid, nid := ctx.ps.ByName("id"), ctx.ps.ByName("rid"), ctx.ps.ByName("nid")
+	var rc io.ReadCloser
+	var err error
+	if all {
+		rc, err = ctx.hub.AllClientOpenNodeLog(id, rid, nid)
+	} else {
+		rc, err = ctx.hub.OpenNodeLog(id, rid, nid)
+	}
+	switch err {
+	case nil:
+	case runlog.ErrNotFound:
+		return rNotFound, err.Error(), nil
+	default:
+		return rErr, err.Error(), nil
+	}
+	defer rc.Close()
+
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": id + "-" + rid + "-" + nid + ".log"}))
+	rw.WriteHeader(http.StatusOK)
+	io.Copy(rw, rc)
+	return 0, "", nil
+}
@@ -0,0 +1,13 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/floeit/floe/metrics"
+)
+
+// hndMetrics writes the metrics of this host in the Prometheus text format
+func hndMetrics(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	metrics.Default.Handler().ServeHTTP(rw, r)
+	return 0, "", nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/floeit/floe/config"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/hub"
+	"github.com/floeit/floe/runlog"
 )
 
 type field struct {
@@ -28,8 +29,13 @@ type runNode struct {
 	Stopped time.Time
 	Status  string          // "", "running", "finished", "waiting"(for data)
 	Result  string          // "success", "failed", "" // only valid when Status="finished"
-	Logs    []string        // TODO - paging
+	Logs    []string        // the tail of the output, the whole output is paged from the node logs endpoint
 	Waits   map[string]bool // the events the merge node has seen
+
+	LogOffset int64 // the byte offset in the whole output of the first line in Logs, 0 if Logs is all of it
+	LogSize   int64 // the size of the whole output
+
+	ApprovedBy string // the user that completed a data node
 }
 
 // hndRun answers external call and returns the individual run detail (may come from other host)
@@ -190,6 +196,7 @@ func buildRunResp(graph [][]string, conf *config.Flow, run *client.Run) [][]runN
 				rn.Enabled = res.Enabled
 				rn.Started = res.Started
 				rn.Stopped = res.Stopped
+				rn.ApprovedBy = res.ApprovedBy
 				switch {
 				case !rn.Stopped.IsZero():
 					rn.Status = "finished"
@@ -203,7 +210,10 @@ func buildRunResp(graph [][]string, conf *config.Flow, run *client.Run) [][]runN
 				buildFields(&rn, cn.Opts, vals)
 			default:
 				res := run.ExecNodes[id]
-				rn.Logs = res.Logs
+				tail := run.Logs[id]
+				rn.Logs = tail.Lines
+				rn.LogOffset = tail.Offset
+				rn.LogSize = tail.Size
 				rn.Started = res.Started
 				rn.Stopped = res.Stopped
 				switch {
@@ -223,6 +233,40 @@ func buildRunResp(graph [][]string, conf *config.Flow, run *client.Run) [][]runN
 	return nodes
 }
 
+// hndCancelRun cancels the run wherever in the cluster it is pending or active
+func hndCancelRun(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	id := ctx.ps.ByName("id")
+	rid := ctx.ps.ByName("rid")
+	ok, err := ctx.hub.CancelRun(id, rid, ctx.sesh.User)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
+	if !ok && !ctx.hub.AllClientCancelRun(id, rid, ctx.sesh.User) {
+		return rNotFound, "no pending or active run found", nil
+	}
+	return rOK, "cancelled", nil
+}
+
+// hndP2PCancelRun cancels the run if it is pending or active on this host
+func hndP2PCancelRun(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	v := struct {
+		FlowID string
+		RunID  string
+		User   string
+	}{}
+	if ok, code, msg := decodeBody(rw, r, &v); !ok {
+		return code, msg, nil
+	}
+	ok, err := ctx.hub.CancelRun(v.FlowID, v.RunID, v.User)
+	if err != nil {
+		return rErr, err.Error(), nil
+	}
+	if !ok {
+		return rNotFound, "not found", nil
+	}
+	return rOK, "cancelled", nil
+}
+
 // hndP2PRun answers internal calls just for this host and returns the individual run detail
 func hndP2PRun(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
 	id := ctx.ps.ByName("id")
@@ -231,7 +275,14 @@ func hndP2PRun(rw http.ResponseWriter, r *http.Request, ctx *context) (int, stri
 	if run == nil {
 		return rNotFound, "not found", nil
 	}
-	return rOK, "", run
+	// only the tail of the logs so huge outputs do not bloat the response
+	return rOK, "", struct {
+		*hub.Run
+		Logs map[string]runlog.Page
+	}{
+		Run:  run,
+		Logs: ctx.hub.LogTails(run, logTailLines),
+	}
 }
 
 // hndP2PRuns answers internal calls just for this host and returns the run summaries
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/floeit/floe/event"
+	"github.com/floeit/floe/hub"
+)
+
+const (
+	streamPing  = 30 * time.Second // how often an idle stream sends a comment to keep it open
+	streamRetry = 2 * time.Second  // how soon a client should reconnect to a pending run
+)
+
+// streamLog is the data of a replayed line of node output
+type streamLog struct {
+	Node   string
+	Offset int64 // the byte offset of the line in the node output
+	Line   string
+}
+
+// hndRunStream streams the events of a run as server sent events from whichever host is executing
+// it. Unless resuming with a Last-Event-ID header the stored node output is replayed first, as
+// "log" events, from the offset query param for the node query param, or in full for every node.
+// The events of the run follow with their event id, the node output is in the "sys.node.update"
+// events. A final "done" event means the run has ended and there is no need to reconnect. The
+// stream of a pending run closes straight away with a retry hint.
+func hndRunStream(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return runStream(rw, r, ctx, true)
+}
+
+// hndP2PRunStream streams the events of a run executing on this host
+func hndP2PRunStream(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+	return runStream(rw, r, ctx, false)
+}
+
+func runStream(rw http.ResponseWriter, r *http.Request, ctx *context, all bool) (int, string, renderable) {
+	id, rid := ctx.ps.ByName("id"), ctx.ps.ByName("rid")
+
+	lastID := r.Header.Get("Last-Event-ID")
+	var after int64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseInt(lastID, 10, 64); err != nil || after < 0 {
+			return rBad, "Last-Event-ID must be a positive number", nil
+		}
+	}
+	node := r.URL.Query().Get("node")
+	var offset int64
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.ParseInt(v, 10, 64); err != nil || offset < 0 {
+			return rBad, "offset must be a positive number", nil
+		}
+	}
+
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		return rErr, "streaming is not supported", nil
+	}
+
+	// the events of a run are only on the host executing it
+	run := ctx.hub.FindRun(id, rid)
+	if run != nil && run.Ref.ExecHost == "" {
+		// still pending here, so ask the client to reconnect shortly
+		streamHeaders(rw)
+		fmt.Fprintf(rw, "retry: %d\n\n", streamRetry/time.Millisecond)
+		return 0, "", nil
+	}
+	if run == nil || run.Ref.ExecHost != ctx.hub.HostID() {
+		if !all {
+			return rNotFound, "run not executing on this host", nil
+		}
+		rc := ctx.hub.AllClientOpenRunStream(id, rid, lastID, r.URL.RawQuery)
+		if rc == nil {
+			return rNotFound, "run not found or not started", nil
+		}
+		defer rc.Close()
+		streamHeaders(rw)
+		proxyStream(rw, flusher, rc)
+		return 0, "", nil
+	}
+
+	// subscribe before replaying the logs so nothing is missed in between
+	ended := runEnded(run)
+	past, sub := ctx.hub.SubscribeRun(id, rid, after)
+	defer sub.Close()
+
+	streamHeaders(rw)
+
+	// replayed is the offset each node output has been replayed up to
+	replayed := map[string]int64{}
+	if lastID == "" {
+		nodes := ctx.hub.LogNodes(run)
+		if node != "" {
+			nodes = []string{node}
+		}
+		for _, nid := range nodes {
+			off := int64(0)
+			if node != "" {
+				off = offset
+			}
+			replayed[nid] = replayLog(rw, ctx.hub, id, rid, nid, off)
+		}
+	}
+
+	send := func(e event.Event) error {
+		// skip output already replayed from the log
+		if o, ok := e.Opts["offset"].(int64); ok && o < replayed[e.SourceNode.ID] {
+			return nil
+		}
+		b, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(rw, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Tag, b)
+		return err
+	}
+
+	for _, e := range past {
+		if err := send(e); err != nil {
+			return 0, "", nil
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(streamPing)
+	defer ping.Stop()
+	for !ended {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// the run ended, or this stream fell too far behind and should reconnect
+				if !runEnded(run) {
+					return 0, "", nil
+				}
+				ended = true
+				continue
+			}
+			if err := send(e); err != nil {
+				return 0, "", nil
+			}
+		case <-ping.C:
+			if _, err := io.WriteString(rw, ": ping\n\n"); err != nil {
+				return 0, "", nil
+			}
+		case <-r.Context().Done():
+			return 0, "", nil
+		}
+		flusher.Flush()
+	}
+
+	io.WriteString(rw, "event: done\ndata: {}\n\n")
+	flusher.Flush()
+	return 0, "", nil
+}
+
+// replayLog writes the output of the node from the offset as log events and returns the offset
+// it reached
+func replayLog(w io.Writer, h *hub.Hub, flowID, runID, nodeID string, offset int64) int64 {
+	for {
+		pg, err := h.NodeLog(flowID, runID, nodeID, offset, logPageMax)
+		if err != nil || len(pg.Lines) == 0 {
+			return offset
+		}
+		for _, l := range pg.Lines {
+			b, _ := json.Marshal(streamLog{Node: nodeID, Offset: offset, Line: l})
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", b)
+			offset += int64(len(l) + 1)
+		}
+		offset = pg.Next
+	}
+}
+
+func streamHeaders(rw http.ResponseWriter) {
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no") // stop nginx buffering the stream
+	rw.WriteHeader(http.StatusOK)
+}
+
+// proxyStream copies the stream from another host, flushing as it goes
+func proxyStream(rw http.ResponseWriter, flusher http.Flusher, rc io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rc.Read(buf)
+		if n > 0 {
+			if _, werr := rw.Write(buf[:n]); werr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func runEnded(run *hub.Run) bool {
+	run.RLock()
+	defer run.RUnlock()
+	return run.Ended
+}
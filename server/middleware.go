@@ -2,10 +2,13 @@ package server
 
 import (
 	"compress/gzip"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"runtime/debug"
 	"strings"
@@ -13,8 +16,11 @@ import (
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/floeit/floe/audit"
 	"github.com/floeit/floe/hub"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
+	"github.com/floeit/floe/server/push"
 )
 
 const (
@@ -25,8 +31,13 @@ const (
 	rErr      = http.StatusInternalServerError
 	rCreated  = http.StatusCreated
 	rConflict = http.StatusConflict
+	rForbid   = http.StatusForbidden
+	rUnavail  = http.StatusServiceUnavailable
 
 	cookieName = "floe-sesh"
+
+	oidcCookieName = "floe-oidc-state" // the state of the single sign on login of the browser
+	oidcCookieAge  = 10 * time.Minute  // how long the user has to log in at the issuer
 )
 
 // AdminToken a configurable admin token for this host
@@ -60,9 +71,26 @@ func jsonResp(w http.ResponseWriter, code int, r interface{}) {
 }
 
 type context struct {
-	ps   *httprouter.Params
-	sesh *session
-	hub  *hub.Hub
+	ps     *httprouter.Params
+	sesh   *session
+	hub    *hub.Hub
+	policy auth.Policy
+	rec    *audit.Record // the audit record for this request, if it is audited
+}
+
+// allowed returns true if the session has at least the role for the flow, an empty flow
+// needs the role for all flows.
+func (c *context) allowed(flow string, role auth.Role) bool {
+	if c.sesh == nil {
+		return false
+	}
+	if c.sesh.apiToken != "" && !c.sesh.scopes.Allow(flow, role) {
+		return false
+	}
+	if c.sesh.admin {
+		return true
+	}
+	return c.policy.Allowed(c.sesh.User, c.sesh.Groups, flow, role)
 }
 
 type notFoundHandler struct{}
@@ -79,15 +107,26 @@ type wrapper struct {
 }
 
 type handler struct {
-	hub *hub.Hub
+	hub    *hub.Hub
+	seshs  *sessions
+	tokens *apiTokens
+	policy auth.Policy
+	oidc   *auth.OIDC // nil if single sign on is not configured
+	audit  *audit.Log
+
+	// peerCerts is true if p2p requests must be authenticated with a client certificate
+	peerCerts bool
 }
 
-// the boolean return is set true if the caller should produce its own response
-func authRequest(rw http.ResponseWriter, r *http.Request) *session {
-	var code int
-	var sesh *session
-
+// authRequest authenticates the request token from the header or cookie, writing the failure
+// response and returning nil if the request is not authenticated
+func (h handler) authRequest(rw http.ResponseWriter, r *http.Request) *session {
 	tok := r.Header.Get("X-Floe-Auth")
+	if tok == "" {
+		if b := r.Header.Get("Authorization"); strings.HasPrefix(b, "Bearer ") {
+			tok = strings.TrimSpace(b[len("Bearer "):])
+		}
+	}
 	if tok == "" {
 		log.Debug("checking cookie")
 		c, err := r.Cookie(cookieName)
@@ -99,30 +138,38 @@ func authRequest(rw http.ResponseWriter, r *http.Request) *session {
 	}
 
 	if tok == "" {
-		code = rUnauth
-		jsonResp(rw, code, wrapper{Message: "missing session"})
+		jsonResp(rw, rUnauth, wrapper{Message: "missing session"})
 		return nil
 	}
 
-	log.Debug("checking token", tok, AdminToken)
+	var sesh *session
 
-	// default to this agent for testing admin token
-	if tok == AdminToken {
-		log.Debug("found admin token", tok)
+	// default to this agent for testing admin token, once peers use certificates the cluster
+	// token is only for the p2p routes
+	if AdminToken != "" && tok == AdminToken && !h.peerCerts {
+		log.Debug("found admin token")
 		sesh = &session{
 			token:      tok,
-			lastActive: time.Now(),
-			user:       "Admin",
+			LastActive: time.Now(),
+			User:       "Admin",
+			admin:      true,
 		}
 	}
 
 	if sesh == nil {
-		sesh = goodToken(tok)
+		sesh = h.seshs.get(tok)
+	}
+	if sesh == nil {
+		// api and static tokens are not sessions so don't need a cookie
+		sesh = h.tokens.get(tok)
+		if sesh == nil {
+			sesh = h.seshs.static(tok)
+		}
 		if sesh == nil {
-			code = rUnauth
-			jsonResp(rw, code, wrapper{Message: "invalid session"})
+			jsonResp(rw, rUnauth, wrapper{Message: "invalid session"})
 			return nil
 		}
+		return sesh
 	}
 
 	// refresh cookie
@@ -131,7 +178,142 @@ func authRequest(rw http.ResponseWriter, r *http.Request) *session {
 	return sesh
 }
 
-func (h handler) mw(f contextFunc, auth bool) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+func (h handler) mw(f contextFunc, authenticate bool) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !authenticate {
+		return h.authMw(f, nil)
+	}
+	return h.authMw(f, h.authRequest)
+}
+
+// can wraps an authenticated route that needs at least the role for the flow identified by the
+// id route parameter, or for all flows if the route has no id.
+func (h handler) can(role auth.Role, f contextFunc) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.mw(needs(role, f), true)
+}
+
+// needs returns the handler wrapped with the check that the session has the role for the
+// flow identified by the id route parameter, or for all flows if the route has no id.
+func needs(role auth.Role, f contextFunc) contextFunc {
+	return func(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+		if !ctx.allowed(ctx.ps.ByName("id"), role) {
+			return rForbid, "forbidden - needs role: " + role.String(), nil
+		}
+		return f(rw, r, ctx)
+	}
+}
+
+// audited returns the handler wrapped so that each request is recorded in the audit log as the
+// action, whether it succeeds or not. The handler can add to or correct the record in ctx.rec.
+func (h handler) audited(action string, f contextFunc) contextFunc {
+	return func(rw http.ResponseWriter, r *http.Request, ctx *context) (int, string, renderable) {
+		ctx.rec = &audit.Record{
+			Action: action,
+			FlowID: ctx.ps.ByName("id"),
+			Run:    ctx.ps.ByName("rid"),
+			IP:     remoteIP(r),
+		}
+		if ctx.sesh != nil {
+			ctx.rec.Actor = ctx.sesh.actor()
+		}
+		sr := &statusRecorder{ResponseWriter: rw, code: rOK}
+		code, msg, res := f(sr, r, ctx)
+		ctx.rec.Result = code
+		if code == 0 {
+			ctx.rec.Result = sr.code
+		}
+		if ctx.rec.Detail == "" && ctx.rec.Result >= 400 {
+			ctx.rec.Detail = msg
+		}
+		h.audit.Record(*ctx.rec)
+		return code, msg, res
+	}
+}
+
+// statusRecorder captures the status of handlers that write their own response
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// remoteIP is the ip address of the immediate client
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// p2p wraps the peer to peer route handlers, which can only be called by other hosts
+// in the cluster, and never with a user session.
+func (h handler) p2p(f contextFunc) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	return h.authMw(f, h.authPeer)
+}
+
+// authPeer authenticates the request as coming from another host. If peer certificates
+// are required the host is identified by the verified certificate common name, which with one
+// of its names must match a known host, otherwise only the cluster admin token is accepted.
+func (h handler) authPeer(rw http.ResponseWriter, r *http.Request) *session {
+	if h.peerCerts {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+			jsonResp(rw, rUnauth, wrapper{Message: "peer certificate required"})
+			return nil
+		}
+		cert := r.TLS.PeerCertificates[0]
+		cn := cert.Subject.CommonName
+		if cn == "" {
+			jsonResp(rw, rUnauth, wrapper{Message: "peer certificate has no common name"})
+			return nil
+		}
+		if !knownPeer(cert, h.hub.HostAddrs()) {
+			log.Warning("peer certificate is not for a known host", cn)
+			jsonResp(rw, rUnauth, wrapper{Message: "peer certificate is not for a known host"})
+			return nil
+		}
+		return &session{
+			LastActive: time.Now(),
+			User:       cn,
+			peer:       true,
+		}
+	}
+
+	tok := r.Header.Get("X-Floe-Auth")
+	if tok == "" || tok != AdminToken {
+		jsonResp(rw, rUnauth, wrapper{Message: "invalid peer token"})
+		return nil
+	}
+	return &session{
+		token:      tok,
+		LastActive: time.Now(),
+		User:       "peer",
+		peer:       true,
+	}
+}
+
+// knownPeer returns true if the common name or a subject alternative name of the certificate
+// is the host name of one of the host addresses
+func knownPeer(cert *x509.Certificate, addrs []string) bool {
+	for _, a := range addrs {
+		u, err := url.Parse(a)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		name := u.Hostname()
+		if strings.EqualFold(cert.Subject.CommonName, name) || cert.VerifyHostname(name) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// authMw returns the handler wrapped with the common middleware, if authFn is not nil it is
+// used to authenticate the request, and is responsible for any failure response.
+func (h handler) authMw(f contextFunc, authFn func(rw http.ResponseWriter, r *http.Request) *session) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	fn := func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 
 		var code int
@@ -152,18 +334,20 @@ func (h handler) mw(f contextFunc, auth bool) func(rw http.ResponseWriter, r *ht
 
 		// authenticate session is needed
 		var sesh *session
-		if auth {
-			sesh = authRequest(rw, r)
+		if authFn != nil {
+			sesh = authFn(rw, r)
 			if sesh == nil {
 				return
 			}
+			r = r.WithContext(auth.WithUser(r.Context(), sesh.actor()))
 		}
 
 		// got here then we are authenticated - so call the specific handler
 		ctx := &context{
-			ps:   &ps,
-			sesh: sesh,
-			hub:  h.hub,
+			ps:     &ps,
+			sesh:   sesh,
+			hub:    h.hub,
+			policy: h.policy,
 		}
 
 		code, msg, res := f(rw, r, ctx)
@@ -195,6 +379,16 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends what has been compressed so far, so streamed responses can still be compressed
+func (w gzipResponseWriter) Flush() {
+	if gz, ok := w.Writer.(*gzip.Writer); ok {
+		gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func zipper(fn func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params)) func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
@@ -236,8 +430,42 @@ func (h handler) setupPushes(basePath string, r *httprouter.Router, hub *hub.Hub
 		}
 		p := t.PostHandler(hub.Queue())
 		if p != nil {
-			r.POST(basePath+subPath, h.mw(adaptSub(hub, p), authenticated))
+			r.POST(basePath+subPath, h.mw(h.audited("trigger", pushAccess(t, adaptSub(hub, p))), authenticated))
+		}
+	}
+}
+
+// pushAccess checks an authenticated push has the trigger role for the flow it triggers, or
+// the approver role if it is supplying data to a run. Pushes that can not say which flow
+// they are for need the admin role.
+func pushAccess(t push.Push, f contextFunc) contextFunc {
+	return func(w http.ResponseWriter, req *http.Request, ctx *context) (int, string, renderable) {
+		flow, run, role := "", "", auth.Admin
+		if tg, ok := t.(push.Targeter); ok {
+			var err error
+			flow, run, err = tg.Target(req)
+			if err != nil {
+				return rBad, err.Error(), nil
+			}
+			role = auth.Trigger
+			if run != "" {
+				role = auth.Approver
+			}
+			if ctx.rec != nil {
+				if run != "" {
+					ctx.rec.Action = "approve"
+				}
+				ctx.rec.FlowID = flow
+				ctx.rec.Run = run
+			}
+		}
+		if ctx.sesh == nil {
+			return f(w, req, ctx)
+		}
+		if !ctx.allowed(flow, role) {
+			return rForbid, "forbidden - needs role: " + role.String(), nil
 		}
+		return f(w, req, ctx)
 	}
 }
 
@@ -270,7 +498,7 @@ func panicHandler(rw http.ResponseWriter, r *http.Request, v interface{}) {
 	jsonResp(rw, http.StatusInternalServerError, string(stack))
 
 	// send it to stderr
-	fmt.Fprintf(os.Stderr, string(stack))
+	fmt.Fprint(os.Stderr, string(stack))
 	// this sends it to the client....
 	// fmt.Fprintf(rw, f, err, )
 }
@@ -1,7 +1,9 @@
 package push
 
 import (
+	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,6 +14,7 @@ import (
 	nt "github.com/floeit/floe/config/nodetype"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
 )
 
 // Data is the push data endpoint handler
@@ -22,6 +25,26 @@ func (d Data) RequiresAuth() bool {
 	return true
 }
 
+// Target satisfies Targeter, data with a Run is targeting a data node of that run
+func (d Data) Target(req *http.Request) (string, string, error) {
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", "", err
+	}
+	// put the body back for the handler
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	o := struct {
+		Ref config.FlowRef
+		Run string
+	}{}
+	if err := json.Unmarshal(b, &o); err != nil {
+		return "", "", err
+	}
+	return o.Ref.ID, o.Run, nil
+}
+
 // PostHandler handles POST requests
 func (d Data) PostHandler(queue *event.Queue) httprouter.Handle {
 	return func(w http.ResponseWriter, req *http.Request, par httprouter.Params) {
@@ -69,6 +92,7 @@ func (d Data) PostHandler(queue *event.Queue) httprouter.Handle {
 			Tag:        "inbound.data", // "inbound" is checked before launching a pending, and data will become the type
 			SourceNode: sourceNode,
 			Opts:       o.Form.Values,
+			Actor:      auth.User(req.Context()),
 		})
 
 		jsonResp(w, http.StatusOK, "OK", nil)
@@ -1,6 +1,8 @@
 package push
 
 import (
+	"net/http"
+
 	"github.com/julienschmidt/httprouter"
 
 	"github.com/floeit/floe/event"
@@ -13,3 +15,10 @@ type Push interface {
 	GetHandler(queue *event.Queue) httprouter.Handle
 	RequiresAuth() bool // this trigger expects to be authenticated with the server
 }
+
+// Targeter is implemented by pushes that can say which flow a request is for, and which run if
+// it is supplying data to an existing run, so that the users access to the flow can be checked
+// before the request is handled. The request body must still be readable afterwards.
+type Targeter interface {
+	Target(req *http.Request) (flowID string, run string, err error)
+}
@@ -1,19 +1,33 @@
 package server
 
 import (
+	stdctx "context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 
+	"github.com/floeit/floe/audit"
+	"github.com/floeit/floe/client"
+	"github.com/floeit/floe/config"
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/hub"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/path"
+	"github.com/floeit/floe/server/auth"
 	"github.com/floeit/floe/server/push"
 )
 
 const rootPath = "/build/api"
 
+// shutdownTimeout is how long requests in progress have to finish when the server shuts down
+const shutdownTimeout = time.Second * 10
+
+// Conf is the server config
 type Conf struct {
 	PubBind string
 	PubCert string
@@ -22,10 +36,14 @@ type Conf struct {
 	PrvBind string
 	PrvCert string
 	PrvKey  string
+	// PrvCA is a ca bundle, if set the private server requires all clients to present
+	// a certificate signed by one of the CAs, and the p2p routes only accept such clients.
+	PrvCA string
 }
 
 // LaunchWeb sets up all the http routes runs the server and launches the trigger flows
-// rp is the root path. Returns the address it binds to.
+// rp is the root path. Sends the address it binds to on addrChan, and returns once the hub
+// has drained and the servers have shut down.
 // If webDev is true then the web files will be served from the filesystem, rather than the compiled in assets
 func LaunchWeb(conf Conf, rp string, hub *hub.Hub, q *event.Queue, addrChan chan string, webDev bool) {
 	if rp == "" {
@@ -36,25 +54,66 @@ func LaunchWeb(conf Conf, rp string, hub *hub.Hub, q *event.Queue, addrChan chan
 	r.NotFound = notFoundHandler{}
 	r.PanicHandler = panicHandler
 
-	h := handler{hub: hub}
+	policy, err := rolePolicy(hub.Config().Common.Auth)
+	if err != nil {
+		log.Fatal("bad role config", err)
+	}
+	h := handler{
+		hub:       hub,
+		seshs:     newSessions(hub.Store(), authenticators(hub.Config().Common.Auth)),
+		tokens:    newAPITokens(hub.Store()),
+		policy:    policy,
+		oidc:      oidcLogin(hub.Config().Common.Auth.OIDC),
+		audit:     auditLog(hub),
+		peerCerts: conf.PrvCA != "",
+	}
 
 	// --- authentication ---
-	r.POST(rp+"/login", h.mw(loginHandler, false))
-	r.POST(rp+"/logout", h.mw(logoutHandler, true))
+	r.POST(rp+"/login", h.mw(h.audited("login", h.loginHandler), false))
+	r.POST(rp+"/logout", h.mw(h.audited("logout", h.logoutHandler), true))
+	r.GET(rp+"/sessions", h.mw(h.hndSessions, true))                                           // list the callers sessions, or all for admin
+	r.DELETE(rp+"/sessions/:sid", h.mw(h.audited("session.revoke", h.hndRevokeSession), true)) // revoke a session
+	r.GET(rp+"/tokens", h.mw(h.hndTokens, true))                                               // list the callers api tokens, or all for admin
+	r.POST(rp+"/tokens", h.mw(h.audited("token.create", h.hndCreateToken), true))              // create an api token
+	r.DELETE(rp+"/tokens/:tid", h.mw(h.audited("token.revoke", h.hndRevokeToken), true))       // revoke an api token
+	if h.oidc != nil {
+		r.GET(rp+"/oidc/login", h.mw(h.hndOIDCLogin, false))                                // redirect to the identity provider
+		r.GET(rp+"/oidc/callback", h.mw(h.audited("login.oidc", h.hndOIDCCallback), false)) // the identity provider redirects back here
+	}
 
 	// --- api ---
-	r.GET(rp+"/flows", h.mw(hndAllFlows, true))          // list all the flows configs
-	r.GET(rp+"/flows/:id", h.mw(hndFlow, true))          // return highest version of the flow config and run summaries from the cluster
-	r.GET(rp+"/flows/:id/runs/:rid", h.mw(hndRun, true)) // returns the identified run detail (may be on another host)
+	r.GET(rp+"/flows", h.mw(hndAllFlows, true))                                                                    // list all the flows configs the user can view
+	r.GET(rp+"/flows/:id", h.can(auth.Viewer, hndFlow))                                                            // return highest version of the flow config and run summaries from the cluster
+	r.GET(rp+"/flows/:id/runs/:rid", h.can(auth.Viewer, hndRun))                                                   // returns the identified run detail (may be on another host)
+	r.GET(rp+"/flows/:id/runs/:rid/stream", h.can(auth.Viewer, hndRunStream))                                      // server sent events of the run, after replaying its output (may be on another host)
+	r.GET(rp+"/flows/:id/runs/:rid/nodes/:nid/logs", h.can(auth.Viewer, hndNodeLog))                               // a page of the node output from offset (may be on another host)
+	r.GET(rp+"/flows/:id/runs/:rid/nodes/:nid/logs/raw", h.can(auth.Viewer, hndNodeLogRaw))                        // download the whole node output (may be on another host)
+	r.GET(rp+"/flows/:id/runs/:rid/artifacts", h.can(auth.Viewer, hndArtifacts))                                   // list the artifacts kept from the run (may be on another host)
+	r.GET(rp+"/flows/:id/runs/:rid/artifacts/:nid/*name", h.can(auth.Viewer, hndArtifact))                         // download an artifact kept from the node (may be on another host)
+	r.POST(rp+"/flows/:id/runs/:rid/cancel", h.mw(h.audited("run.cancel", needs(auth.Admin, hndCancelRun)), true)) // cancel the pending or active run (may be on another host)
+	r.GET(rp+"/audit", h.can(auth.Admin, h.hndAudit))                                                              // query the audit log of this host
+	r.POST(rp+"/admin/drain", h.mw(h.audited("host.drain", needs(auth.Admin, hndDrain)), true))                    // stop this host taking runs and shut it down once its runs have ended
+	r.POST(rp+"/admin/reload", h.mw(h.audited("config.reload", needs(auth.Admin, hndReload)), true))               // reload the flows from the config file of this host
+
+	// --- metrics and health ---
+	r.GET("/metrics", h.mw(hndMetrics, true)) // prometheus metrics of this host, scrape with a bearer token
+	r.GET("/healthz", h.mw(hndHealth, false)) // the host is up
+	r.GET("/readyz", h.mw(hndReady, false))   // the host is ready to accept new runs, 503 if not
 
 	// --- push endpoints ---
 	h.setupPushes(rp+"/push/", r, hub)
 
 	// --- p2p api ---
-	r.POST(rp+"/p2p/flows/exec", h.mw(hndP2PExecFlow, true))    // internal api to pass a pending todo to activate it on this host
-	r.GET(rp+"/p2p/flows/:id/runs", h.mw(hndP2PRuns, true))     // all summary runs from this host for this flow id
-	r.GET(rp+"/p2p/flows/:id/runs/:rid", h.mw(hndP2PRun, true)) // detailed run info from this host for this flow id and run id
-	r.GET(rp+"/p2p/config", h.mw(confHandler, true))            // return host config and what it knows about other hosts
+	r.POST(rp+"/p2p/flows/exec", h.p2p(hndP2PExecFlow))                               // internal api to pass a pending todo to activate it on this host
+	r.GET(rp+"/p2p/pends", h.p2p(hndP2PPends))                                        // the pending runs adopted by this host
+	r.GET(rp+"/p2p/flows/:id/runs", h.p2p(hndP2PRuns))                                // all summary runs from this host for this flow id
+	r.GET(rp+"/p2p/flows/:id/runs/:rid", h.p2p(hndP2PRun))                            // detailed run info from this host for this flow id and run id
+	r.GET(rp+"/p2p/flows/:id/runs/:rid/stream", h.p2p(hndP2PRunStream))               // server sent events of a run executing on this host
+	r.GET(rp+"/p2p/flows/:id/runs/:rid/nodes/:nid/logs", h.p2p(hndP2PNodeLog))        // a page of the node output from this host
+	r.GET(rp+"/p2p/flows/:id/runs/:rid/nodes/:nid/logs/raw", h.p2p(hndP2PNodeLogRaw)) // the whole node output from this host
+	r.GET(rp+"/p2p/flows/:id/runs/:rid/artifacts/:nid/*name", h.p2p(hndP2PArtifact))  // an artifact kept from the node on this host
+	r.POST(rp+"/p2p/cancel", h.p2p(hndP2PCancelRun))                                  // cancel the run if it is pending or active on this host
+	r.GET(rp+"/p2p/config", h.p2p(confHandler))                                       // return host config and what it knows about other hosts
 
 	// --- static files for the spa ---
 	if webDev { // local development mode
@@ -97,18 +156,156 @@ func LaunchWeb(conf Conf, rp string, hub *hub.Hub, q *event.Queue, addrChan chan
 
 	*/
 
+	servers := []*http.Server{}
+
 	// start the private server if one is configured differently to the public server
 	if conf.PrvBind != conf.PubBind && conf.PrvBind != "" {
 		log.Debug("private server listen on:", conf.PrvBind)
-		go launch(conf.PrvBind, conf.PrvCert, conf.PrvKey, r, nil)
+		var tc *tls.Config
+		if conf.PrvCA != "" {
+			var err error
+			tc, err = peerTLS(conf.PrvCA)
+			if err != nil {
+				log.Fatal("can not set up peer tls", err)
+			}
+		}
+		servers = append(servers, launch(conf.PrvBind, conf.PrvCert, conf.PrvKey, tc, r, nil))
 	}
 
 	// start the public server
 	log.Debug("pub server listen on:", conf.PubBind)
-	launch(conf.PubBind, conf.PubCert, conf.PubKey, r, addrChan)
+	servers = append(servers, launch(conf.PubBind, conf.PubCert, conf.PubKey, nil, r, addrChan))
+
+	// serve until the hub has drained
+	<-hub.Drained()
+	for _, srv := range servers {
+		shutdown(srv)
+	}
+}
+
+// shutdown stops the server once the requests in progress are done, long lived streams
+// are closed if they have not finished within the shutdown timeout.
+func shutdown(srv *http.Server) {
+	ctx, cancel := stdctx.WithTimeout(stdctx.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Debug("closing server after shutdown timeout", err)
+		srv.Close()
+	}
+}
+
+// authenticators returns the chain of configured user authenticators
+func authenticators(c config.AuthConfig) auth.Chain {
+	ch := auth.Chain{}
+	if c.UserFile != "" {
+		uf, err := auth.NewUserFile(c.UserFile)
+		if err != nil {
+			log.Fatal("can not load user file", err)
+		}
+		ch = append(ch, uf)
+	}
+	if len(c.Tokens) > 0 {
+		tl := auth.TokenList{}
+		for _, t := range c.Tokens {
+			tl = append(tl, auth.Token{User: t.User, Token: t.Token})
+		}
+		ch = append(ch, tl)
+	}
+	if len(ch) == 0 {
+		log.Warning("no user authentication configured, only the admin token can be used")
+	}
+	return ch
+}
+
+// auditLog returns the audit log, kept beside the store for local and bolt stores and in the
+// store itself for the s3 store, and registers it to record the hub events.
+func auditLog(hub *hub.Hub) *audit.Log {
+	var s audit.Store = audit.NewMemStore()
+	c := hub.Config().Common
+	switch c.StoreType {
+	case "s3":
+		kv, err := audit.NewKVStore(hub.Store(), "audit")
+		if err != nil {
+			log.Fatal("can not load audit log", err)
+		}
+		s = kv
+	case "local", "bolt":
+		root, err := path.Expand(c.StoreRoot)
+		if err != nil {
+			log.Fatal("can not expand store root", err)
+		}
+		if err := os.MkdirAll(root, 0700); err != nil {
+			log.Fatal("can not create store root", err)
+		}
+		fs, err := audit.NewFileStore(filepath.Join(root, "audit.log"))
+		if err != nil {
+			log.Fatal("can not open audit log", err)
+		}
+		s = fs
+	}
+	l := audit.New(s, hub.HostID())
+	hub.Queue().Register(l)
+	return l
+}
+
+// oidcLogin returns the single sign on login if it is configured
+func oidcLogin(c config.OIDCConfig) *auth.OIDC {
+	if c.Issuer == "" {
+		return nil
+	}
+	o, err := auth.NewOIDC(auth.OIDCConfig{
+		Issuer:       c.Issuer,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Scopes:       c.Scopes,
+		UserClaim:    c.UserClaim,
+		GroupsClaim:  c.GroupsClaim,
+	})
+	if err != nil {
+		log.Fatal("bad oidc config", err)
+	}
+	return o
+}
+
+// rolePolicy returns the role policy from the config
+func rolePolicy(c config.AuthConfig) (auth.Policy, error) {
+	p := auth.Policy{
+		Groups: c.Groups,
+	}
+	for _, rb := range c.Roles {
+		role, err := auth.ParseRole(rb.Role)
+		if err != nil {
+			return p, err
+		}
+		p.Bindings = append(p.Bindings, auth.Binding{
+			Role:   role,
+			Users:  rb.Users,
+			Groups: rb.Groups,
+			Flows:  rb.Flows,
+		})
+	}
+	if p.Open() {
+		log.Warning("no roles configured, all users are admins")
+	}
+	return p, nil
+}
+
+// peerTLS returns the tls config that requires clients to present a certificate
+// signed by a CA in the caFile bundle.
+func peerTLS(caFile string) (*tls.Config, error) {
+	pool, err := client.LoadCAs(caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}, nil
 }
 
-func launch(bind, cert, key string, r http.Handler, addrChan chan string) {
+// launch starts serving on the bind address in the background, returning the server
+func launch(bind, cert, key string, tc *tls.Config, r http.Handler, addrChan chan string) *http.Server {
 	log.Debug("attempting to listen on:", bind)
 
 	listener, err := net.Listen("tcp", bind)
@@ -126,13 +323,24 @@ func launch(bind, cert, key string, r http.Handler, addrChan chan string) {
 
 	log.Debug("starting on:", address)
 
-	if cert != "" {
-		log.Debug("using https")
-		log.Fatal(http.ServeTLS(listener, r, cert, key))
-	} else {
-		log.Debug("using http")
-		log.Fatal(http.Serve(listener, r))
+	srv := &http.Server{
+		Handler:   r,
+		TLSConfig: tc,
 	}
+	go func() {
+		var err error
+		if cert != "" {
+			log.Debug("using https")
+			err = srv.ServeTLS(listener, cert, key)
+		} else {
+			log.Debug("using http")
+			err = srv.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	return srv
 }
 
 func singleFile(path string) httprouter.Handle {
@@ -2,55 +2,232 @@ package server
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"sort"
+	"sync"
 	"time"
+
+	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
+	"github.com/floeit/floe/store"
 )
 
-const seshLifetime = time.Hour * 240 // 10 days
+const (
+	seshLifetime = time.Hour * 240 // 10 days
+	seshKey      = "sessions"      // the store key for the sessions
+	seshSweep    = time.Minute     // how often expired sessions are cleared and sessions persisted
+)
 
 type session struct {
-	token      string
-	lastActive time.Time
-	user       string
+	ID         string // hash of the token, safe to list and used to revoke the session
+	User       string
+	Groups     []string // any groups given by the identity provider
+	Created    time.Time
+	LastActive time.Time
+
+	token    string      // the token presented on this request, never persisted
+	peer     bool        // the session is another host in the cluster
+	admin    bool        // the session was authenticated with the admin token
+	apiToken string      // the name of the api token the request was authenticated with
+	scopes   auth.Scopes // the scopes of the api token
+}
+
+// actor is who to attribute the actions of this session to
+func (s *session) actor() string {
+	if s.apiToken != "" {
+		return s.User + " (token " + s.apiToken + ")"
+	}
+	return s.User
+}
+
+func (s *session) expired(now time.Time) bool {
+	return now.Sub(s.LastActive) > seshLifetime
 }
 
-var tokens = map[string]*session{}
+// sessions holds all the logged in sessions by session id persisting them in the store.
+type sessions struct {
+	sync.Mutex
+	store store.Store
+	auth  auth.Authenticator
+	list  map[string]*session
+	dirty bool // last active times have changed since the last save
+}
 
-func goodToken(token string) *session {
-	t, ok := tokens[token]
+func newSessions(s store.Store, a auth.Authenticator) *sessions {
+	ss := &sessions{
+		store: s,
+		auth:  a,
+		list:  map[string]*session{},
+	}
+	if err := s.Load(seshKey, &ss.list); err != nil {
+		log.Error("can not load sessions", err)
+	}
+	if ss.list == nil {
+		ss.list = map[string]*session{}
+	}
+	go func() {
+		for now := range time.Tick(seshSweep) {
+			ss.sweep(now)
+		}
+	}()
+	return ss
+}
+
+// seshID is the id a token is stored against, so the store never holds live tokens.
+func seshID(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// get returns a copy of the session for the token if there is a live one
+func (ss *sessions) get(token string) *session {
+	ss.Lock()
+	defer ss.Unlock()
+	t, ok := ss.list[seshID(token)]
 	if !ok {
 		return nil
 	}
 
 	// expired?
 	now := time.Now()
-	d := now.Sub(t.lastActive)
-	if d > seshLifetime {
+	if t.expired(now) {
 		return nil
 	}
 
 	// update last active in token store
-	t.lastActive = now
-	t.token = token
+	t.LastActive = now
+	ss.dirty = true
+
+	sesh := *t
+	sesh.token = token
+	return &sesh
+}
+
+// login authenticates the user and if good creates and returns a new session token
+func (ss *sessions) login(user, pass string) (string, error) {
+	if ss.auth == nil {
+		return "", nil
+	}
+	user, ok := ss.auth.Authenticate(auth.Credentials{User: user, Password: pass})
+	if !ok {
+		return "", nil
+	}
+	return ss.create(user, nil)
+}
+
+// create makes a new session for the already authenticated user
+func (ss *sessions) create(user string, groups []string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := fmt.Sprintf("%x", b)
+	now := time.Now()
 
-	return t
+	ss.Lock()
+	defer ss.Unlock()
+	id := seshID(token)
+	ss.list[id] = &session{
+		ID:         id,
+		User:       user,
+		Groups:     groups,
+		Created:    now,
+		LastActive: now,
+	}
+	return token, ss.save()
+}
+
+// static returns a session if the token is one of the statically configured tokens
+func (ss *sessions) static(token string) *session {
+	if ss.auth == nil {
+		return nil
+	}
+	user, ok := ss.auth.Authenticate(auth.Credentials{Token: token})
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	return &session{
+		User:       user,
+		Created:    now,
+		LastActive: now,
+		token:      token,
+	}
 }
 
-// TODO - inject an authenticator
-func login(user, pass string) string {
-	if user == "admin" && pass == "password" {
-		b := make([]byte, 8)
-		rand.Read(b)
-		token := fmt.Sprintf("%x", b)
-		tokens[token] = &session{
-			lastActive: time.Now(),
-			user:       user,
+// all returns copies of all the live sessions, or only those for user if user is not empty
+func (ss *sessions) all(user string) []session {
+	ss.Lock()
+	defer ss.Unlock()
+	now := time.Now()
+	res := []session{}
+	for _, s := range ss.list {
+		if s.expired(now) || (user != "" && s.User != user) {
+			continue
 		}
-		return token
+		res = append(res, *s)
 	}
-	return ""
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].LastActive.After(res[j].LastActive)
+	})
+	return res
 }
 
-func logout(token string) {
-	delete(tokens, token)
+// owner returns the user of the session with the id
+func (ss *sessions) owner(id string) (string, bool) {
+	ss.Lock()
+	defer ss.Unlock()
+	s, ok := ss.list[id]
+	if !ok {
+		return "", false
+	}
+	return s.User, true
+}
+
+// revoke removes the session by id returning false if there was no such session
+func (ss *sessions) revoke(id string) (bool, error) {
+	ss.Lock()
+	defer ss.Unlock()
+	if _, ok := ss.list[id]; !ok {
+		return false, nil
+	}
+	delete(ss.list, id)
+	return true, ss.save()
+}
+
+func (ss *sessions) logout(token string) error {
+	_, err := ss.revoke(seshID(token))
+	return err
+}
+
+// sweep removes any expired sessions and saves any changes
+func (ss *sessions) sweep(now time.Time) {
+	ss.Lock()
+	defer ss.Unlock()
+	for id, s := range ss.list {
+		if s.expired(now) {
+			delete(ss.list, id)
+			ss.dirty = true
+		}
+	}
+	if !ss.dirty {
+		return
+	}
+	if err := ss.save(); err != nil {
+		log.Error("could not save sessions", err)
+	}
+}
+
+// save must be called with the lock held
+func (ss *sessions) save() error {
+	// save a copy, as the mem store keeps a reference
+	cp := make(map[string]*session, len(ss.list))
+	for id, s := range ss.list {
+		c := *s
+		cp[id] = &c
+	}
+	ss.dirty = false
+	return ss.store.Save(seshKey, cp)
 }
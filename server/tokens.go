@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
+	"github.com/floeit/floe/store"
+)
+
+const (
+	apiTokenKey    = "api-tokens" // the store key for the api tokens
+	apiTokenPrefix = "floe_"      // makes api tokens easy to spot e.g. in leaked secret scans
+)
+
+// apiToken is a named token with limited scopes, that acts on behalf of the user that created it.
+// Only the hash of the token is kept.
+type apiToken struct {
+	ID       string // hash of the token
+	Name     string
+	User     string // the user the token acts as
+	Scopes   auth.Scopes
+	Created  time.Time
+	Expires  time.Time // zero if the token never expires
+	LastUsed time.Time
+
+	Admin bool // created with the admin token, so not limited by any roles
+}
+
+func (t *apiToken) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// apiTokens holds all the api tokens by id persisting them in the store.
+type apiTokens struct {
+	sync.Mutex
+	store store.Store
+	list  map[string]*apiToken
+	dirty bool // last used times have changed since the last save
+}
+
+func newAPITokens(s store.Store) *apiTokens {
+	at := &apiTokens{
+		store: s,
+		list:  map[string]*apiToken{},
+	}
+	if err := s.Load(apiTokenKey, &at.list); err != nil {
+		log.Error("can not load api tokens", err)
+	}
+	if at.list == nil {
+		at.list = map[string]*apiToken{}
+	}
+	go func() {
+		for now := range time.Tick(seshSweep) {
+			at.sweep(now)
+		}
+	}()
+	return at
+}
+
+// get returns a session for the token if it is a live api token
+func (at *apiTokens) get(token string) *session {
+	at.Lock()
+	defer at.Unlock()
+	t, ok := at.list[seshID(token)]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	if t.expired(now) {
+		return nil
+	}
+	t.LastUsed = now
+	at.dirty = true
+	return &session{
+		ID:         t.ID,
+		User:       t.User,
+		Created:    t.Created,
+		LastActive: now,
+		token:      token,
+		admin:      t.Admin,
+		apiToken:   t.Name,
+		scopes:     t.Scopes,
+	}
+}
+
+// create makes a new token returning the token, which is not kept, and its details
+func (at *apiTokens) create(name, user string, admin bool, scopes auth.Scopes, expires time.Time) (string, apiToken, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", apiToken{}, err
+	}
+	token := fmt.Sprintf("%s%x", apiTokenPrefix, b)
+	id := seshID(token)
+	t := &apiToken{
+		ID:      id,
+		Name:    name,
+		User:    user,
+		Scopes:  scopes,
+		Created: time.Now(),
+		Expires: expires,
+		Admin:   admin,
+	}
+
+	at.Lock()
+	defer at.Unlock()
+	at.list[id] = t
+	return token, *t, at.save()
+}
+
+// all returns copies of all the live tokens, or only those for user if user is not empty
+func (at *apiTokens) all(user string) []apiToken {
+	at.Lock()
+	defer at.Unlock()
+	now := time.Now()
+	res := []apiToken{}
+	for _, t := range at.list {
+		if t.expired(now) || (user != "" && t.User != user) {
+			continue
+		}
+		res = append(res, *t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Created.After(res[j].Created)
+	})
+	return res
+}
+
+// owner returns the user of the token with the id
+func (at *apiTokens) owner(id string) (string, bool) {
+	at.Lock()
+	defer at.Unlock()
+	t, ok := at.list[id]
+	if !ok {
+		return "", false
+	}
+	return t.User, true
+}
+
+// revoke removes the token by id returning false if there was no such token
+func (at *apiTokens) revoke(id string) (bool, error) {
+	at.Lock()
+	defer at.Unlock()
+	if _, ok := at.list[id]; !ok {
+		return false, nil
+	}
+	delete(at.list, id)
+	return true, at.save()
+}
+
+// sweep removes any expired tokens and saves any changes
+func (at *apiTokens) sweep(now time.Time) {
+	at.Lock()
+	defer at.Unlock()
+	for id, t := range at.list {
+		if t.expired(now) {
+			delete(at.list, id)
+			at.dirty = true
+		}
+	}
+	if !at.dirty {
+		return
+	}
+	if err := at.save(); err != nil {
+		log.Error("could not save api tokens", err)
+	}
+}
+
+// save must be called with the lock held
+func (at *apiTokens) save() error {
+	cp := make(map[string]*apiToken, len(at.list))
+	for id, t := range at.list {
+		c := *t
+		cp[id] = &c
+	}
+	at.dirty = false
+	return at.store.Save(apiTokenKey, cp)
+}
@@ -1,101 +1,299 @@
 package server
 
 import (
+	"bufio"
 	"encoding/json"
-	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"path"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/net/websocket"
 
 	"github.com/floeit/floe/event"
 	"github.com/floeit/floe/log"
+	"github.com/floeit/floe/server/auth"
 )
 
+const (
+	wsQueue     = 256              // events queued for a connection before it is dropped as too slow
+	wsWriteWait = 10 * time.Second // how long a write to a connection can take
+	wsPing      = 30 * time.Second // how often each connection is pinged
+	wsPongWait  = 2 * wsPing       // how long a connection can be silent, not even answering pings
+	wsMaxMsg    = 64 * 1024        // the biggest message accepted from a client
+)
+
+// wsSubscribe is the message a client sends to choose the events it is sent. Each list that is
+// not empty must match the event, so an empty message subscribes to all events again. Tags are
+// patterns e.g. "sys.node.*" or "task.*.good".
+type wsSubscribe struct {
+	Flows []string // flow ids
+	Runs  []string // run ids e.g. h1-5
+	Tags  []string // tag patterns
+}
+
+func (s *wsSubscribe) match(e event.Event) bool {
+	if len(s.Flows) > 0 && !contains(s.Flows, e.RunRef.FlowRef.ID) {
+		return false
+	}
+	if len(s.Runs) > 0 && !contains(s.Runs, e.RunRef.Run.String()) {
+		return false
+	}
+	if len(s.Tags) == 0 {
+		return true
+	}
+	for _, p := range s.Tags {
+		if ok, _ := path.Match(p, e.Tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// wsConn is a client connection with its own queue of events to send
+type wsConn struct {
+	ws   *websocket.Conn
+	ctx  *context // to check the flows the session can view
+	send chan []byte
+	done chan struct{}
+	once sync.Once
+	act  *wsActivity
+
+	mu  sync.Mutex
+	sub *wsSubscribe // nil until the client subscribes, when it is sent every event
+}
+
+func (c *wsConn) wants(e event.Event) bool {
+	if !c.ctx.allowed(e.RunRef.FlowRef.ID, auth.Viewer) {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sub == nil || c.sub.match(e)
+}
+
+// close closes the connection, which ends both its reader and writer, it is safe to call more than once
+func (c *wsConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
+
+// writer sends the queued events and the pings, it is the only writer to the connection
+func (c *wsConn) writer() {
+	ping := time.NewTicker(wsPing)
+	defer ping.Stop()
+	defer c.close()
+	for {
+		select {
+		case b := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if _, err := c.ws.Write(b); err != nil {
+				log.Debug("websocket - write failed", err)
+				return
+			}
+		case <-ping.C:
+			if c.act.since() > wsPongWait {
+				log.Debug("websocket - client stopped answering pings")
+				return
+			}
+			c.ws.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.ws.PayloadType = websocket.PingFrame
+			_, err := c.ws.Write(nil)
+			c.ws.PayloadType = websocket.TextFrame
+			if err != nil {
+				log.Debug("websocket - ping failed", err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reader handles the subscribe messages from the client until it closes
+func (c *wsConn) reader() {
+	defer c.close()
+	c.ws.MaxPayloadBytes = wsMaxMsg
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			select {
+			case <-c.done: // closed this end
+			default:
+				if err != io.EOF {
+					log.Debug("websocket - read failed", err)
+				}
+			}
+			return
+		}
+		sub := &wsSubscribe{}
+		if err := json.Unmarshal(msg, sub); err != nil {
+			log.Debug("websocket - ignoring a message that is not a subscription", err)
+			continue
+		}
+		for _, p := range sub.Tags {
+			if _, err := path.Match(p, ""); err != nil {
+				c.reply("bad tag pattern: " + p)
+				sub = nil
+				break
+			}
+		}
+		if sub == nil {
+			continue
+		}
+		c.mu.Lock()
+		c.sub = sub
+		c.mu.Unlock()
+	}
+}
+
+// reply queues a message to the client that is not an event
+func (c *wsConn) reply(msg string) {
+	b, _ := json.Marshal(wrapper{Message: msg})
+	select {
+	case c.send <- b:
+	default:
+	}
+}
+
+// wsActivity records when anything, including the pongs the websocket package answers itself,
+// was last read from the connection.
+type wsActivity struct {
+	http.ResponseWriter
+	last int64 // unix nano
+}
+
+func (a *wsActivity) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := a.ResponseWriter.(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	a.touch()
+	return conn, bufio.NewReadWriter(bufio.NewReader(activityReader{brw.Reader, a}), brw.Writer), nil
+}
+
+func (a *wsActivity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+func (a *wsActivity) since() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+type activityReader struct {
+	r io.Reader
+	a *wsActivity
+}
+
+func (r activityReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if n > 0 {
+		r.a.touch()
+	}
+	return n, err
+}
+
 type wsHub struct {
 	sync.RWMutex
-	cons map[*websocket.Conn]bool
+	cons map[*wsConn]bool
 }
 
 func newWsHub() *wsHub {
 	return &wsHub{
-		cons: map[*websocket.Conn]bool{},
+		cons: map[*wsConn]bool{},
 	}
 }
 
+// Notify queues the event to every connection that wants it, dropping any connection that has
+// fallen too far behind.
 func (w *wsHub) Notify(e event.Event) {
-	w.RLock()
-	defer w.RUnlock()
-
 	b, err := json.Marshal(e)
 	if err != nil {
 		log.Error("json encoding event failed:", err)
 		return
 	}
 
-	for ws := range w.cons {
-		m, err := ws.Write(b)
-		if err != nil {
-			log.Fatal(err)
+	var slow []*wsConn
+	w.RLock()
+	for c := range w.cons {
+		if !c.wants(e) {
+			continue
 		}
-		if m != len(b) {
-			log.Errorf("ws write did not send full event (%d out of %d):", m, len(b))
+		select {
+		case c.send <- b:
+		default:
+			slow = append(slow, c)
 		}
 	}
+	w.RUnlock()
+
+	for _, c := range slow {
+		log.Warning("ws - dropping a slow client", c.ws.Request().RemoteAddr)
+		w.remove(c)
+		go c.close()
+	}
 }
 
-func (w *wsHub) add(ws *websocket.Conn) {
+func (w *wsHub) add(c *wsConn) {
 	w.Lock()
 	defer w.Unlock()
 
 	log.Debug("ws - adding new client")
 
-	w.cons[ws] = true
+	w.cons[c] = true
 }
 
-func (w *wsHub) remove(ws *websocket.Conn) {
+func (w *wsHub) remove(c *wsConn) {
 	w.Lock()
 	defer w.Unlock()
 
-	delete(w.cons, ws)
+	delete(w.cons, c)
 }
 
 func (w *wsHub) getWsHandler(h *handler) httprouter.Handle {
 	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		sesh := authRequest(rw, r)
+		sesh := h.authRequest(rw, r)
 		if sesh == nil {
 			return
 		}
-		h := websocket.Handler(w.handler)
-		h.ServeHTTP(rw, r)
+		ctx := &context{
+			sesh:   sesh,
+			policy: h.policy,
+		}
+		act := &wsActivity{ResponseWriter: rw}
+		websocket.Handler(func(ws *websocket.Conn) {
+			w.serve(ws, ctx, act)
+		}).ServeHTTP(act, r)
 	}
 }
 
-func (w *wsHub) handler(ws *websocket.Conn) {
-	w.add(ws)
-	defer func() {
-		w.remove(ws)
-	}()
-
-	for {
-		msg := make([]byte, 512)
-		n, err := ws.Read(msg)
-		if err != nil {
-			// normal client close
-			if err == io.EOF {
-				log.Debug("websocket - client closed")
-			} else {
-				log.Error("websocket - got an error", err)
-			}
-			err = ws.Close()
-			if err != nil {
-				log.Error("websocket - close error", err)
-			}
-			return
-		}
-
-		fmt.Printf("TODO - something with this - Receive: %s\n", msg[:n])
+func (w *wsHub) serve(ws *websocket.Conn, ctx *context, act *wsActivity) {
+	c := &wsConn{
+		ws:   ws,
+		ctx:  ctx,
+		send: make(chan []byte, wsQueue),
+		done: make(chan struct{}),
+		act:  act,
 	}
+	w.add(c)
+	defer w.remove(c)
+
+	go c.writer()
+	c.reader()
+	log.Debug("websocket - client closed")
 }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/floeit/floe/config"
+	"github.com/floeit/floe/event"
+)
+
+func TestWsSubscribeMatch(t *testing.T) {
+	t.Parallel()
+
+	e := event.Event{
+		RunRef: event.RunRef{
+			FlowRef: config.FlowRef{ID: "build"},
+			Run:     event.HostedIDRef{HostID: "h1", ID: 5},
+		},
+		Tag: "sys.node.start",
+	}
+	fix := []struct {
+		sub wsSubscribe
+		ok  bool
+	}{
+		{sub: wsSubscribe{}, ok: true},
+		{sub: wsSubscribe{Flows: []string{"build"}}, ok: true},
+		{sub: wsSubscribe{Flows: []string{"other", "build"}}, ok: true},
+		{sub: wsSubscribe{Flows: []string{"other"}}, ok: false},
+		{sub: wsSubscribe{Runs: []string{e.RunRef.Run.String()}}, ok: true},
+		{sub: wsSubscribe{Runs: []string{"h2-5"}}, ok: false},
+		{sub: wsSubscribe{Tags: []string{"sys.node.*"}}, ok: true},
+		{sub: wsSubscribe{Tags: []string{"task.*", "sys.*.start"}}, ok: true},
+		{sub: wsSubscribe{Tags: []string{"sys.*"}}, ok: true}, // * spans the dots
+		{sub: wsSubscribe{Tags: []string{"sys.node.end"}}, ok: false},
+		{sub: wsSubscribe{Flows: []string{"build"}, Tags: []string{"task.*"}}, ok: false},
+		{sub: wsSubscribe{Flows: []string{"build"}, Runs: []string{"h2-5"}, Tags: []string{"sys.node.*"}}, ok: false},
+	}
+	for i, f := range fix {
+		if f.sub.match(e) != f.ok {
+			t.Errorf("%d - %+v expected match %v", i, f.sub, f.ok)
+		}
+	}
+}
+
+// testWsConns returns n server side websocket connections, which stay open until the returned
+// func is called.
+func testWsConns(t *testing.T, n int) ([]*websocket.Conn, func()) {
+	got := make(chan *websocket.Conn)
+	release := make(chan struct{})
+	srv := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		got <- ws
+		<-release
+	}))
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var clients []*websocket.Conn
+	var cons []*websocket.Conn
+	for i := 0; i < n; i++ {
+		c, err := websocket.Dial(url, "", srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, c)
+		cons = append(cons, <-got)
+	}
+	return cons, func() {
+		close(release)
+		for _, c := range clients {
+			c.Close()
+		}
+		srv.Close()
+	}
+}
+
+func TestWsHubNotifySlow(t *testing.T) {
+	t.Parallel()
+
+	wss, done := testWsConns(t, 3)
+	defer done()
+
+	ctx := &context{sesh: &session{admin: true}}
+	newConn := func(ws *websocket.Conn, sub *wsSubscribe) *wsConn {
+		return &wsConn{
+			ws:   ws,
+			ctx:  ctx,
+			send: make(chan []byte, 1),
+			done: make(chan struct{}),
+			sub:  sub,
+		}
+	}
+	fast := newConn(wss[0], nil)
+	slow := newConn(wss[1], nil)
+	slow.send <- []byte("unread")
+	// a full connection that does not want the event is not dropped
+	other := newConn(wss[2], &wsSubscribe{Flows: []string{"other"}})
+	other.send <- []byte("unread")
+
+	w := newWsHub()
+	w.add(fast)
+	w.add(slow)
+	w.add(other)
+
+	notified := make(chan struct{})
+	go func() {
+		w.Notify(event.Event{
+			RunRef: event.RunRef{FlowRef: config.FlowRef{ID: "build"}},
+			Tag:    "sys.node.start",
+		})
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notify blocked on the slow connection")
+	}
+
+	select {
+	case b := <-fast.send:
+		if !strings.Contains(string(b), "sys.node.start") {
+			t.Error("fast connection got the wrong event", string(b))
+		}
+	default:
+		t.Error("fast connection did not get the event")
+	}
+
+	w.RLock()
+	if !w.cons[fast] || w.cons[slow] || !w.cons[other] {
+		t.Error("only the slow connection should have been removed", w.cons)
+	}
+	w.RUnlock()
+
+	select {
+	case <-slow.done:
+	case <-time.After(5 * time.Second):
+		t.Error("slow connection was not closed")
+	}
+	select {
+	case <-fast.done:
+		t.Error("fast connection should not be closed")
+	case <-other.done:
+		t.Error("other connection should not be closed")
+	default:
+	}
+}
@@ -0,0 +1,131 @@
+//go:build !solaris
+// +build !solaris
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/floeit/floe/path"
+)
+
+// boltBucket holds all the keys, the key space is flat like the other stores
+var boltBucket = []byte("floe")
+
+// BoltStore is an embedded key value store in a single file. Every Save is an atomic transaction
+// that is synced to disk before it returns, so a crash never leaves a partly written value.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates the bolt database file at path
+func NewBoltStore(dbPath string) (*BoltStore, error) {
+	p, err := path.Expand(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	// only one process can have the file open, so don't wait forever for another floe
+	db, err := bolt.Open(p, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can not open bolt store %s: %v", p, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Save saves the data at the key
+func (b *BoltStore) Save(key string, data interface{}) error {
+	defer saveSeconds.Since(time.Now(), "bolt")
+	v, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), v)
+	})
+}
+
+// Load loads data from the key, a missing key leaves thing untouched
+func (b *BoltStore) Load(key string, thing interface{}) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		// v is only valid in the transaction, but Unmarshal copies what it needs
+		return json.Unmarshal(v, thing)
+	})
+}
+
+// Close closes the database file
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
+
+// ImportLocal copies every key saved by a LocalStore rooted at root into this store in a single
+// transaction, so either all the keys are imported or none are. It returns the imported keys.
+func (b *BoltStore) ImportLocal(root string) ([]string, error) {
+	r, err := path.Expand(root)
+	if err != nil {
+		return nil, err
+	}
+	vals := map[string][]byte{}
+	err = filepath.Walk(r, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(p) != ".json" {
+			return nil
+		}
+		v, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(v) {
+			return fmt.Errorf("%s is not valid json", p)
+		}
+		rel, err := filepath.Rel(r, p)
+		if err != nil {
+			return err
+		}
+		vals[strings.TrimSuffix(filepath.ToSlash(rel), ".json")] = v
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(boltBucket)
+		for k, v := range vals {
+			if err := bk.Put([]byte(k), v); err != nil {
+				return err
+			}
+			keys = append(keys, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
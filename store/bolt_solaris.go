@@ -0,0 +1,34 @@
+package store
+
+import "errors"
+
+// errNoBolt is returned on solaris where the vendored bolt needs golang.org/x/sys/unix
+var errNoBolt = errors.New("bolt store not supported on this platform")
+
+// BoltStore is not supported on this platform.
+type BoltStore struct{}
+
+// NewBoltStore is not supported on this platform.
+func NewBoltStore(dbPath string) (*BoltStore, error) {
+	return nil, errNoBolt
+}
+
+// Save is not supported on this platform.
+func (b *BoltStore) Save(key string, data interface{}) error {
+	return errNoBolt
+}
+
+// Load is not supported on this platform.
+func (b *BoltStore) Load(key string, thing interface{}) error {
+	return errNoBolt
+}
+
+// Close is not supported on this platform.
+func (b *BoltStore) Close() error {
+	return errNoBolt
+}
+
+// ImportLocal is not supported on this platform.
+func (b *BoltStore) ImportLocal(root string) ([]string, error) {
+	return nil, errNoBolt
+}
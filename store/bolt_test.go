@@ -0,0 +1,98 @@
+//go:build !solaris
+// +build !solaris
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBoltStore(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bs, err := NewBoltStore(filepath.Join(dir, "floe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore("bolt", t, bs)
+
+	// a missing key leaves the value alone
+	v := "untouched"
+	if err := bs.Load("missing", &v); err != nil || v != "untouched" {
+		t.Error("bad missing load", v, err)
+	}
+
+	// values survive reopening
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err = NewBoltStore(filepath.Join(dir, "floe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	if err := bs.Load("foo/bar", &v); err != nil || v != "test data" {
+		t.Error("bad reload", v, err)
+	}
+}
+
+func TestImportLocal(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "floe-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ls, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	type thing struct {
+		Name  string
+		Count int
+	}
+	ls.Save("active-list", []thing{{"a", 1}, {"b", 2}})
+	ls.Save("sub/key", thing{"c", 3})
+
+	bs, err := NewBoltStore(filepath.Join(dir, "floe.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Close()
+	keys, err := bs.ImportLocal(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Error("expected 2 keys got", keys)
+	}
+	var l []thing
+	if err := bs.Load("active-list", &l); err != nil || len(l) != 2 || l[1].Name != "b" {
+		t.Error("bad import", l, err)
+	}
+	var th thing
+	if err := bs.Load("sub/key", &th); err != nil || th.Count != 3 {
+		t.Error("bad import", th, err)
+	}
+
+	// a corrupt file fails the whole import
+	ioutil.WriteFile(filepath.Join(dir, "store", "bad.json"), []byte(`{"Name":`), 0644)
+	ls.Save("another", thing{"d", 4})
+	if _, err := bs.ImportLocal(filepath.Join(dir, "store")); err == nil {
+		t.Error("corrupt file should fail the import")
+	}
+	if err := bs.Load("another", &th); err != nil || th.Name != "c" {
+		t.Error("failed import should import nothing", th, err)
+	}
+}
@@ -0,0 +1,238 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3Options configures an S3Store, empty credentials and region are taken from the standard
+// AWS_ env vars.
+type S3Options struct {
+	Endpoint  string // e.g. http://localhost:9000 for MinIO, default https://s3.<region>.amazonaws.com
+	Region    string // default AWS_REGION, AWS_DEFAULT_REGION or us-east-1
+	AccessKey string // default AWS_ACCESS_KEY_ID
+	SecretKey string // default AWS_SECRET_ACCESS_KEY
+	Token     string // default AWS_SESSION_TOKEN, only needed with temporary credentials
+}
+
+// S3Store keeps each key as a json object in an S3 compatible bucket. Every host writes below its
+// own host id in the bucket, so hosts sharing a bucket never write the same keys.
+type S3Store struct {
+	sync.Mutex
+	opts   S3Options
+	bucket string
+	prefix string
+	client *http.Client
+}
+
+// NewS3Store returns a store for the host in the bucket and optional key prefix given by root
+// e.g. my-bucket/floe, its keys are below the prefix and host e.g. floe/h1/
+func NewS3Store(root, host string, opts S3Options) (*S3Store, error) {
+	p := strings.SplitN(strings.Trim(root, "/"), "/", 2)
+	if p[0] == "" {
+		return nil, errors.New("s3 store root must start with the bucket name")
+	}
+	if host == "" || strings.Contains(host, "/") {
+		return nil, fmt.Errorf("s3 store needs a host name without a / got %q", host)
+	}
+	s := &S3Store{
+		opts:   opts,
+		bucket: p[0],
+		prefix: host + "/",
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if len(p) == 2 {
+		s.prefix = p[1] + "/" + s.prefix
+	}
+	s.opts.Region = firstOf(s.opts.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1")
+	s.opts.AccessKey = firstOf(s.opts.AccessKey, os.Getenv("AWS_ACCESS_KEY_ID"))
+	s.opts.SecretKey = firstOf(s.opts.SecretKey, os.Getenv("AWS_SECRET_ACCESS_KEY"))
+	s.opts.Token = firstOf(s.opts.Token, os.Getenv("AWS_SESSION_TOKEN"))
+	s.opts.Endpoint = strings.TrimRight(firstOf(s.opts.Endpoint, "https://s3."+s.opts.Region+".amazonaws.com"), "/")
+	if s.opts.AccessKey == "" || s.opts.SecretKey == "" {
+		return nil, errors.New("s3 store needs an access key and secret key")
+	}
+	return s, nil
+}
+
+func firstOf(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Save saves the data at the key
+func (s *S3Store) Save(key string, data interface{}) error {
+	defer saveSeconds.Since(time.Now(), "s3")
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	req, err := s.request("PUT", key, b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, b, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Load loads data from the key, a missing key leaves thing untouched
+func (s *S3Store) Load(key string, thing interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	req, err := s.request("GET", key, nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, nil, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil
+	default:
+		return s3Error(resp)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, thing)
+}
+
+// request returns the path style request for the object holding key
+func (s *S3Store) request(method, key string, body []byte) (*http.Request, error) {
+	u := s.opts.Endpoint + "/" + s.bucket + "/" + uriEncode(s.prefix+key+".json", false)
+	return http.NewRequest(method, u, bytes.NewReader(body))
+}
+
+func s3Error(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("s3 %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, b)
+}
+
+// sign adds the AWS signature version 4 authorization header to req, signing all its headers.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.opts.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.opts.Token)
+	}
+
+	// the canonical headers are the lower case names sorted, with the host
+	hdrs := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		hdrs[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(hdrs))
+	for k := range hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHdrs bytes.Buffer
+	for _, k := range names {
+		canonHdrs.WriteString(k + ":" + hdrs[k] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonQuery(req.URL.Query()),
+		canonHdrs.String(),
+		signed,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.opts.Region + "/s3/aws4_request"
+	crh := sha256.Sum256([]byte(canonReq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crh[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.opts.SecretKey), date)
+	k = hmacSHA256(k, s.opts.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func canonQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := q[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode encodes s as SigV4 requires, every byte except the unreserved characters, and '/' unless
+// it is a query component.
+func uriEncode(s string, query bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
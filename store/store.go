@@ -8,10 +8,15 @@ import (
 	"path/filepath"
 	"reflect"
 	"sync"
+	"time"
 
+	"github.com/floeit/floe/metrics"
 	"github.com/floeit/floe/path"
 )
 
+var saveSeconds = metrics.NewHistogram("floe_store_save_seconds",
+	"How long saves to the store take, by store type.", metrics.DefBuckets, "store")
+
 // Store links events to the config rules
 type Store interface {
 	Save(key string, data interface{}) error
@@ -34,6 +39,7 @@ func NewMemStore() *MemStore {
 
 // Save saves the data at the key
 func (m *MemStore) Save(key string, data interface{}) error {
+	defer saveSeconds.Since(time.Now(), "memory")
 	m.Lock()
 	defer m.Unlock()
 	m.stuff[key] = data
@@ -85,6 +91,7 @@ func NewLocalStore(root string) (*LocalStore, error) {
 
 // Save saves the data at the key
 func (m *LocalStore) Save(key string, data interface{}) error {
+	defer saveSeconds.Since(time.Now(), "local")
 	m.Lock()
 	defer m.Unlock()
 	b, err := json.Marshal(data)